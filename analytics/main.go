@@ -108,6 +108,19 @@ func (a *Analytics) handleGameStarted(event GameEvent) {
 		"is_bot":  isBot,
 		"players": []string{player1, player2},
 	})
+
+	// Matchmaking figures, present for games that came through the queue
+	if queue, ok := event.Data["queue"].(string); ok {
+		metric := map[string]interface{}{
+			"queue":     queue,
+			"wait_time": event.Data["waitTime"],
+			"is_bot":    isBot,
+		}
+		if spread, ok := event.Data["ratingSpread"]; ok {
+			metric["rating_spread"] = spread
+		}
+		a.trackMetric("matchmaking", metric)
+	}
 }
 
 func (a *Analytics) handleMoveMade(event GameEvent) {
@@ -0,0 +1,696 @@
+// Package admin serves the /admin API for operating a live server: looking
+// at games in progress, ending them, disconnecting and banning players,
+// running tournaments and arenas, and announcing maintenance. Admins authenticate with
+// a bearer token from ADMIN_TOKENS, and every change they make is written
+// to the audit log whether or not it succeeds.
+package admin
+
+import (
+	"connect4-backend/arena"
+	"connect4-backend/game"
+	"connect4-backend/store"
+	"connect4-backend/tournament"
+	"connect4-backend/websocket"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+	maxReasonLength   = 500
+)
+
+var (
+	errReasonRequired = errors.New("a reason is required")
+	errWinnerRequired = errors.New(`a winner, or "draw", is required`)
+)
+
+type contextKey struct{}
+
+// API is the admin API of one server.
+type API struct {
+	games       *game.Manager
+	hub         *websocket.Hub
+	tournaments *tournament.Director
+	arenas      *arena.Director
+	store       store.Store
+	tokens      map[string]string // Admin name by token
+	proxies     []*net.IPNet      // Trusted to say who they forward requests for
+}
+
+// TokensFromEnv reads ADMIN_TOKENS, a list of name=token pairs such as
+// "alice=s3cret,bob=0th3r". The name is what the audit log records. It
+// returns nil when ADMIN_TOKENS is not set, which leaves the API off.
+func TokensFromEnv() (map[string]string, error) {
+	value := strings.TrimSpace(os.Getenv("ADMIN_TOKENS"))
+	if value == "" {
+		return nil, nil
+	}
+
+	tokens := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		name, token, found := strings.Cut(strings.TrimSpace(pair), "=")
+		name, token = strings.TrimSpace(name), strings.TrimSpace(token)
+		if !found || name == "" || token == "" {
+			return nil, fmt.Errorf("invalid ADMIN_TOKENS entry %q, want name=token", pair)
+		}
+		if _, exists := tokens[token]; exists {
+			return nil, fmt.Errorf("admins in ADMIN_TOKENS share a token")
+		}
+		tokens[token] = name
+	}
+	return tokens, nil
+}
+
+// TrustedProxiesFromEnv reads ADMIN_TRUSTED_PROXIES, a list of the
+// addresses or CIDR ranges of proxies in front of the server, such as
+// "10.0.0.5,10.1.0.0/16". Only their X-Forwarded-For headers are recorded
+// in the audit log.
+func TrustedProxiesFromEnv() ([]*net.IPNet, error) {
+	value := strings.TrimSpace(os.Getenv("ADMIN_TRUSTED_PROXIES"))
+	if value == "" {
+		return nil, nil
+	}
+
+	var proxies []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ADMIN_TRUSTED_PROXIES entry %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ADMIN_TRUSTED_PROXIES entry %q", entry)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+func New(games *game.Manager, hub *websocket.Hub, tournaments *tournament.Director, arenas *arena.Director,
+	st store.Store, tokens map[string]string) *API {
+	return &API{
+		games:       games,
+		hub:         hub,
+		tournaments: tournaments,
+		arenas:      arenas,
+		store:       st,
+		tokens:      tokens,
+	}
+}
+
+// SetTrustedProxies sets the proxies whose X-Forwarded-For headers are
+// recorded in the audit log.
+func (a *API) SetTrustedProxies(proxies []*net.IPNet) {
+	a.proxies = proxies
+}
+
+// Register adds the API's routes under /admin.
+func (a *API) Register(router *mux.Router) {
+	r := router.PathPrefix("/admin").Subrouter()
+	r.Use(a.authenticate)
+
+	r.HandleFunc("/games", a.listGames).Methods("GET")
+	r.HandleFunc("/games/{id}", a.getGame).Methods("GET")
+	r.HandleFunc("/games/{id}/finish", a.finishGame).Methods("POST")
+	r.HandleFunc("/games/{id}/abort", a.abortGame).Methods("POST")
+	r.HandleFunc("/players/{username}/disconnect", a.disconnect).Methods("POST")
+	r.HandleFunc("/bans", a.listBans).Methods("GET")
+	r.HandleFunc("/bans/{username}", a.ban).Methods("PUT")
+	r.HandleFunc("/bans/{username}", a.unban).Methods("DELETE")
+	r.HandleFunc("/tournaments", a.createTournament).Methods("POST")
+	r.HandleFunc("/tournaments/{id}/start", a.startTournament).Methods("POST")
+	r.HandleFunc("/tournaments/{id}/cancel", a.cancelTournament).Methods("POST")
+	r.HandleFunc("/arenas", a.createArena).Methods("POST")
+	r.HandleFunc("/arenas/{id}/start", a.startArena).Methods("POST")
+	r.HandleFunc("/arenas/{id}/cancel", a.cancelArena).Methods("POST")
+	r.HandleFunc("/broadcast", a.broadcast).Methods("POST")
+	r.HandleFunc("/audit", a.auditLog).Methods("GET")
+}
+
+// authenticate lets through requests with an admin's bearer token, and
+// notes which admin made them.
+func (a *API) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		name := ""
+		if strings.HasPrefix(header, "Bearer ") {
+			token := strings.TrimPrefix(header, "Bearer ")
+			// Every token is compared, so timing does not tell how close a guess was
+			for known, admin := range a.tokens {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+					name = admin
+				}
+			}
+		}
+
+		if name == "" {
+			log.Printf("Rejected admin request %s %s from %s", r.Method, r.URL.Path, address(r))
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, name)))
+	})
+}
+
+func adminName(r *http.Request) string {
+	name, _ := r.Context().Value(contextKey{}).(string)
+	return name
+}
+
+// address is where a request came from. Headers are not trusted here, as
+// anyone can set them.
+func address(r *http.Request) string {
+	return r.RemoteAddr
+}
+
+// forwardedFor is the X-Forwarded-For header of a request that came from a
+// trusted proxy.
+func (a *API) forwardedFor(r *http.Request) string {
+	forwarded := strings.TrimSpace(r.Header.Get("X-Forwarded-For"))
+	if forwarded == "" {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	for _, proxy := range a.proxies {
+		if proxy.Contains(ip) {
+			return forwarded
+		}
+	}
+	return ""
+}
+
+// audit records an action in the audit log. err is the action's outcome.
+func (a *API) audit(r *http.Request, action, target, reason string, err error) {
+	entry := store.AuditEntry{
+		Admin:        adminName(r),
+		Action:       action,
+		Target:       target,
+		Reason:       reason,
+		Address:      address(r),
+		ForwardedFor: a.forwardedFor(r),
+		CreatedAt:    time.Now().UTC(),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	if err := a.store.SaveAuditEntry(entry); err != nil {
+		log.Printf("Failed to write audit entry %+v: %v", entry, err)
+	}
+	log.Printf("Admin %s: %s %s (%s) %s", entry.Admin, action, target, reason, entry.Error)
+}
+
+// request is the body of the API's POST and PUT requests.
+type request struct {
+	Reason  string `json:"reason"`
+	Winner  string `json:"winner"`  // For finish: a player's username or "draw"
+	Message string `json:"message"` // For broadcast
+}
+
+func readRequest(w http.ResponseWriter, r *http.Request) (request, error) {
+	var req request
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			return req, errors.New("invalid request body")
+		}
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	req.Winner = strings.TrimSpace(req.Winner)
+	req.Message = strings.TrimSpace(req.Message)
+	if len(req.Reason) > maxReasonLength {
+		req.Reason = req.Reason[:maxReasonLength]
+	}
+	return req, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// statusOf is the HTTP status for an error from the game manager or the
+// tournament and arena directors.
+func statusOf(err error) int {
+	switch err {
+	case game.ErrGameNotFound, game.ErrPlayerNotFound, tournament.ErrTournamentNotFound, arena.ErrArenaNotFound:
+		return http.StatusNotFound
+	case game.ErrGameNotActive, tournament.ErrAlreadyStarted, tournament.ErrTournamentOver, tournament.ErrNotEnoughPlayers,
+		arena.ErrAlreadyStarted, arena.ErrArenaOver:
+		return http.StatusConflict
+	case game.ErrInvalidUsername, game.ErrReservedUsername, errReasonRequired, errWinnerRequired:
+		return http.StatusBadRequest
+	case tournament.ErrNameRequired, tournament.ErrUnknownFormat, tournament.ErrInvalidRounds, tournament.ErrInvalidMaxPlayers,
+		arena.ErrNameRequired, arena.ErrInvalidDuration, arena.ErrClockRequired,
+		game.ErrInvalidTimeControl, game.ErrUnknownVariant:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// gameSummary is a game in progress as the games list shows it.
+type gameSummary struct {
+	ID          string         `json:"id"`
+	Status      string         `json:"status"`
+	Variant     string         `json:"variant"`
+	TimeControl string         `json:"timeControl"`
+	Rated       bool           `json:"rated"`
+	Private     bool           `json:"private"`
+	Players     []*game.Player `json:"players"`
+	Moves       int            `json:"moves"`
+	ToMove      string         `json:"toMove,omitempty"`
+	Clock       clock          `json:"clock"`
+	Node        string         `json:"node,omitempty"`
+}
+
+// clock is how long a game has been going, how long the player to move
+// has been thinking and, in games with a time control, how long each
+// player has left.
+type clock struct {
+	StartedAt time.Time          `json:"startedAt"`
+	LastMove  time.Time          `json:"lastMove"`
+	Elapsed   float64            `json:"elapsed"`            // Seconds
+	Thinking  float64            `json:"thinking,omitempty"` // Seconds, while playing
+	Left      map[string]float64 `json:"left,omitempty"`     // Seconds, by username
+	Berserked []string           `json:"berserked,omitempty"`
+}
+
+func (a *API) summarize(g *game.Game, now time.Time) gameSummary {
+	summary := gameSummary{
+		ID:          g.ID,
+		Status:      g.Status,
+		Variant:     g.Variant,
+		TimeControl: g.TimeControl,
+		Rated:       g.Rated,
+		Private:     g.Private,
+		Players:     []*game.Player{g.Player1},
+		Moves:       len(g.Moves),
+		Clock: clock{
+			StartedAt: g.CreatedAt,
+			LastMove:  g.LastMove,
+			Elapsed:   now.Sub(g.CreatedAt).Seconds(),
+		},
+	}
+	if g.Player2 != nil {
+		summary.Players = append(summary.Players, g.Player2)
+	}
+	if g.Status == "playing" {
+		summary.ToMove = g.Player1.Username
+		if g.CurrentTurn == game.PLAYER2 {
+			summary.ToMove = g.Player2.Username
+		}
+		summary.Clock.Thinking = now.Sub(g.LastMove).Seconds()
+	}
+	if g.Clock != nil && g.Player2 != nil {
+		summary.Clock.Left = map[string]float64{
+			g.Player1.Username: g.TimeLeft(game.PLAYER1, now).Seconds(),
+			g.Player2.Username: g.TimeLeft(game.PLAYER2, now).Seconds(),
+		}
+		if g.Clock.Berserk1 {
+			summary.Clock.Berserked = append(summary.Clock.Berserked, g.Player1.Username)
+		}
+		if g.Clock.Berserk2 {
+			summary.Clock.Berserked = append(summary.Clock.Berserked, g.Player2.Username)
+		}
+	}
+	if node := a.games.Cluster(); node != nil {
+		summary.Node = node.Owner(g.ID).ID
+	}
+	return summary
+}
+
+// listGames lists the games in progress, optionally only a player's.
+func (a *API) listGames(w http.ResponseWriter, r *http.Request) {
+	player := strings.TrimSpace(r.URL.Query().Get("player"))
+	now := time.Now()
+
+	summaries := []gameSummary{}
+	for _, g := range a.games.ActiveGames() {
+		if player != "" && g.Player1.Username != player && (g.Player2 == nil || g.Player2.Username != player) {
+			continue
+		}
+		summaries = append(summaries, a.summarize(g, now))
+	}
+	writeJSON(w, map[string]interface{}{"games": summaries})
+}
+
+// getGame shows a game in progress, or the record of a finished one.
+func (a *API) getGame(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	live, err := a.games.FindGame(id)
+	if err == nil {
+		writeJSON(w, map[string]interface{}{
+			"game":    live,
+			"summary": a.summarize(live, time.Now()),
+		})
+		return
+	}
+	if err != game.ErrGameNotFound {
+		http.Error(w, err.Error(), statusOf(err))
+		return
+	}
+
+	record, err := a.games.FinishedGame(id)
+	if err != nil {
+		http.Error(w, err.Error(), statusOf(err))
+		return
+	}
+	writeJSON(w, map[string]interface{}{"record": record})
+}
+
+// finishGame ends a game with a result the admin picks.
+func (a *API) finishGame(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	req, err := readRequest(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var ended *game.Game
+	switch {
+	case req.Reason == "":
+		err = errReasonRequired
+	case req.Winner == "":
+		err = errWinnerRequired
+	default:
+		ended, err = a.games.EndGame(id, req.Winner, req.Reason)
+	}
+	a.audit(r, "finish_game", id, "winner "+req.Winner+": "+req.Reason, err)
+	if err != nil {
+		http.Error(w, err.Error(), statusOf(err))
+		return
+	}
+	writeJSON(w, map[string]interface{}{"game": ended})
+}
+
+// abortGame ends a game without a result.
+func (a *API) abortGame(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	req, err := readRequest(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var aborted *game.Game
+	if req.Reason == "" {
+		err = errReasonRequired
+	} else {
+		aborted, err = a.games.AbortGame(id, req.Reason)
+	}
+	a.audit(r, "abort_game", id, req.Reason, err)
+	if err != nil {
+		http.Error(w, err.Error(), statusOf(err))
+		return
+	}
+	writeJSON(w, map[string]interface{}{"game": aborted})
+}
+
+// disconnect closes a player's connections. A player in a game then has
+// the usual grace period to come back.
+func (a *API) disconnect(w http.ResponseWriter, r *http.Request) {
+	username := strings.TrimSpace(mux.Vars(r)["username"])
+	req, err := readRequest(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	closed := 0
+	if req.Reason == "" {
+		err = errReasonRequired
+	} else {
+		closed = a.hub.Disconnect(username, req.Reason)
+	}
+	a.audit(r, "disconnect", username, req.Reason, err)
+	if err != nil {
+		http.Error(w, err.Error(), statusOf(err))
+		return
+	}
+	writeJSON(w, map[string]interface{}{"username": username, "connections": closed})
+}
+
+func (a *API) listBans(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]interface{}{"bans": a.games.Bans()})
+}
+
+// ban bans a player and disconnects them.
+func (a *API) ban(w http.ResponseWriter, r *http.Request) {
+	username := strings.TrimSpace(mux.Vars(r)["username"])
+	req, err := readRequest(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var ban store.Ban
+	if req.Reason == "" {
+		err = errReasonRequired
+	} else {
+		ban, err = a.games.Ban(username, req.Reason, adminName(r))
+	}
+	a.audit(r, "ban", username, req.Reason, err)
+	if err != nil {
+		http.Error(w, err.Error(), statusOf(err))
+		return
+	}
+
+	closed := a.hub.Disconnect(ban.Username, "Banned: "+req.Reason)
+	writeJSON(w, map[string]interface{}{"ban": ban, "connections": closed})
+}
+
+func (a *API) unban(w http.ResponseWriter, r *http.Request) {
+	username := strings.TrimSpace(mux.Vars(r)["username"])
+	req, err := readRequest(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = a.games.Unban(username)
+	a.audit(r, "unban", username, req.Reason, err)
+	if err != nil {
+		http.Error(w, err.Error(), statusOf(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// createTournament opens a tournament for registration. The body holds its
+// settings.
+func (a *API) createTournament(w http.ResponseWriter, r *http.Request) {
+	var settings tournament.Settings
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&settings); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	created, err := a.tournaments.Create(settings, adminName(r))
+	target := settings.Name
+	if err == nil {
+		target = created.ID
+	}
+	a.audit(r, "create_tournament", target, settings.Format+": "+settings.Name, err)
+	if err != nil {
+		http.Error(w, err.Error(), statusOf(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"tournament": created})
+}
+
+// startTournament starts a tournament's first round without waiting for
+// its start time.
+func (a *API) startTournament(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	req, err := readRequest(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	started, err := a.tournaments.Start(id)
+	a.audit(r, "start_tournament", id, req.Reason, err)
+	if err != nil {
+		http.Error(w, err.Error(), statusOf(err))
+		return
+	}
+	writeJSON(w, map[string]interface{}{"tournament": started})
+}
+
+// cancelTournament ends a tournament without a winner, aborting its games.
+func (a *API) cancelTournament(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	req, err := readRequest(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var cancelled *tournament.Tournament
+	if req.Reason == "" {
+		err = errReasonRequired
+	} else {
+		cancelled, err = a.tournaments.Cancel(id, req.Reason)
+	}
+	a.audit(r, "cancel_tournament", id, req.Reason, err)
+	if err != nil {
+		http.Error(w, err.Error(), statusOf(err))
+		return
+	}
+	writeJSON(w, map[string]interface{}{"tournament": cancelled})
+}
+
+// createArena sets up an arena, which starts straight away unless the
+// body, holding its settings, gives a later start time.
+func (a *API) createArena(w http.ResponseWriter, r *http.Request) {
+	var settings arena.Settings
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&settings); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	created, err := a.arenas.Create(settings, adminName(r))
+	target := settings.Name
+	if err == nil {
+		target = created.ID
+	}
+	a.audit(r, "create_arena", target, settings.TimeControl+": "+settings.Name, err)
+	if err != nil {
+		http.Error(w, err.Error(), statusOf(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"arena": created})
+}
+
+// startArena starts a scheduled arena without waiting for its start time.
+func (a *API) startArena(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	req, err := readRequest(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	started, err := a.arenas.Start(id)
+	a.audit(r, "start_arena", id, req.Reason, err)
+	if err != nil {
+		http.Error(w, err.Error(), statusOf(err))
+		return
+	}
+	writeJSON(w, map[string]interface{}{"arena": started})
+}
+
+// cancelArena ends an arena without a winner, aborting its games.
+func (a *API) cancelArena(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	req, err := readRequest(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var cancelled *arena.Arena
+	if req.Reason == "" {
+		err = errReasonRequired
+	} else {
+		cancelled, err = a.arenas.Cancel(id, req.Reason)
+	}
+	a.audit(r, "cancel_arena", id, req.Reason, err)
+	if err != nil {
+		http.Error(w, err.Error(), statusOf(err))
+		return
+	}
+	writeJSON(w, map[string]interface{}{"arena": cancelled})
+}
+
+// broadcast sends a maintenance notice to everyone connected.
+func (a *API) broadcast(w http.ResponseWriter, r *http.Request) {
+	req, err := readRequest(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Message == "" {
+		err = errors.New("a message is required")
+	} else {
+		a.hub.Announce(req.Message)
+	}
+	a.audit(r, "broadcast", "", req.Message, err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// auditLog lists admin actions, newest first, optionally only an admin's
+// or only those on one game or player.
+func (a *API) auditLog(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	filter := store.AuditFilter{
+		Admin:  values.Get("admin"),
+		Target: values.Get("target"),
+		Limit:  defaultAuditLimit,
+	}
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		if n > maxAuditLimit {
+			n = maxAuditLimit
+		}
+		filter.Limit = n
+	}
+
+	entries, err := a.store.AuditLog(filter)
+	if err != nil {
+		log.Printf("Failed to read audit log: %v", err)
+		http.Error(w, "Failed to read audit log", http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []store.AuditEntry{}
+	}
+	writeJSON(w, map[string]interface{}{"entries": entries})
+}
@@ -0,0 +1,144 @@
+package arena
+
+import (
+	"connect4-backend/game"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var statuses = map[string]bool{"scheduled": true, "running": true, "finished": true, "cancelled": true}
+
+// Summary is an arena as the arena list shows it.
+type Summary struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Status      string     `json:"status"`
+	Minutes     int        `json:"minutes"`
+	TimeControl string     `json:"timeControl"`
+	Variant     string     `json:"variant"`
+	Rated       bool       `json:"rated"`
+	Players     int        `json:"players"`
+	Games       int        `json:"games"`
+	StartsAt    *time.Time `json:"startsAt,omitempty"`
+	EndsAt      *time.Time `json:"endsAt,omitempty"`
+	Winner      string     `json:"winner,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+func summarize(a *Arena) Summary {
+	return Summary{
+		ID:          a.ID,
+		Name:        a.Name,
+		Status:      a.Status,
+		Minutes:     a.Minutes,
+		TimeControl: a.TimeControl,
+		Variant:     a.Variant,
+		Rated:       a.Rated,
+		Players:     len(a.Players),
+		Games:       len(a.Pairings),
+		StartsAt:    a.StartsAt,
+		EndsAt:      a.EndsAt,
+		Winner:      a.Winner,
+		CreatedAt:   a.CreatedAt,
+	}
+}
+
+// statusOf is the HTTP status for an error from the director.
+func statusOf(err error) int {
+	switch err {
+	case ErrArenaNotFound, ErrNotJoined:
+		return http.StatusNotFound
+	case ErrArenaOver:
+		return http.StatusConflict
+	case game.ErrBanned:
+		return http.StatusForbidden
+	case game.ErrInvalidUsername, game.ErrReservedUsername:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func (d *Director) fail(w http.ResponseWriter, err error, action string) {
+	status := statusOf(err)
+	if status == http.StatusInternalServerError {
+		log.Printf("Failed to %s: %v", action, err)
+		http.Error(w, "Failed to "+action, status)
+		return
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// GetArenas serves /api/arenas. Parameters: status.
+func (d *Director) GetArenas(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status != "" && !statuses[status] {
+		http.Error(w, "unknown status", http.StatusBadRequest)
+		return
+	}
+
+	arenas, err := d.List(status)
+	if err != nil {
+		d.fail(w, err, "fetch arenas")
+		return
+	}
+
+	summaries := make([]Summary, len(arenas))
+	for i, a := range arenas {
+		summaries[i] = summarize(a)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"arenas": summaries})
+}
+
+// GetArena serves /api/arenas/{id}, with the arena's leaderboard.
+func (d *Director) GetArena(w http.ResponseWriter, r *http.Request) {
+	a, err := d.Get(mux.Vars(r)["id"])
+	if err != nil {
+		d.fail(w, err, "fetch arena")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"arena":       a,
+		"leaderboard": Standings(a),
+	})
+}
+
+// JoinPlayer serves POST /api/arenas/{id}/players, whose body names the
+// player: {"username": "..."}.
+func (d *Director) JoinPlayer(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&body); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	a, err := d.Join(mux.Vars(r)["id"], body.Username)
+	if err != nil {
+		d.fail(w, err, "join arena")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"arena": summarize(a)})
+}
+
+// LeavePlayer serves DELETE /api/arenas/{id}/players/{username}. The
+// player keeps their score and can join again.
+func (d *Director) LeavePlayer(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if _, err := d.Leave(vars["id"], strings.TrimSpace(vars["username"])); err != nil {
+		d.fail(w, err, "leave arena")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,496 @@
+// Package arena runs arenas: tournaments played for a fixed time, in which
+// players are paired again as soon as their game ends. Wins and draws score
+// points, doubled while a player is on a winning streak, and a player who
+// berserks and wins scores one more.
+package arena
+
+import (
+	"connect4-backend/cluster"
+	"connect4-backend/game"
+	"connect4-backend/store"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultMinutes     = 30
+	maxMinutes         = 360
+	defaultTimeControl = "3+2"
+	maxNameLength      = 100
+
+	// showUpTime is how long players have to take their seat in an arena
+	// game before they forfeit it and are paused.
+	showUpTime = 30 * time.Second
+
+	// How often waiting players are paired and leaderboards sent
+	pairingInterval = time.Second
+
+	// rematchWait is how long two players who just met must both have
+	// waited before they are paired again.
+	rematchWait = 15 * time.Second
+
+	// leaderboardSize is how many players the pushed leaderboard lists.
+	leaderboardSize = 10
+)
+
+var (
+	ErrArenaNotFound   = errors.New("arena not found")
+	ErrNameRequired    = errors.New("an arena name is required")
+	ErrInvalidDuration = errors.New("minutes must be between 1 and 360")
+	ErrClockRequired   = errors.New("an arena needs a time control with a clock")
+	ErrNotJoined       = errors.New("player has not joined the arena")
+	ErrAlreadyStarted  = errors.New("arena has already started")
+	ErrArenaOver       = errors.New("arena is over")
+)
+
+// Settings are what an admin chooses when creating an arena. An arena
+// without a StartsAt time starts straight away.
+type Settings struct {
+	Name        string     `json:"name"`
+	Minutes     int        `json:"minutes"`     // How long it runs; 30 when 0
+	TimeControl string     `json:"timeControl"` // "3+2" when empty
+	Variant     string     `json:"variant"`
+	Rated       bool       `json:"rated"`
+	StartsAt    *time.Time `json:"startsAt,omitempty"`
+}
+
+// Arena is an arena and every game started in it so far. Status is
+// "scheduled", "running", "finished" or "cancelled".
+type Arena struct {
+	ID string `json:"id"`
+	Settings
+	Status     string     `json:"status"`
+	Players    []*Player  `json:"players"`
+	Pairings   []*Pairing `json:"pairings"` // Oldest first
+	Winner     string     `json:"winner,omitempty"`
+	Reason     string     `json:"reason,omitempty"` // Why it was cancelled
+	CreatedBy  string     `json:"createdBy,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	EndsAt     *time.Time `json:"endsAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+
+	changed bool // The leaderboard has changed since it was last sent
+}
+
+// Player is a player who joined an arena. Active players are paired
+// whenever they are not playing; leaving or missing a game pauses them
+// until they join again.
+type Player struct {
+	Username string    `json:"username"`
+	Rating   float64   `json:"rating"` // When they joined
+	Score    int       `json:"score"`
+	Sheet    []int     `json:"sheet"`  // Points from each game, oldest first
+	Streak   int       `json:"streak"` // Wins in a row
+	Wins     int       `json:"wins"`
+	Draws    int       `json:"draws"`
+	Losses   int       `json:"losses"`
+	Berserks int       `json:"berserks"` // Games won after berserking
+	Active   bool      `json:"active"`
+	GameID   string    `json:"gameId,omitempty"` // The game being played
+	JoinedAt time.Time `json:"joinedAt"`
+
+	waitingSince time.Time // When they last became free to pair
+}
+
+// Pairing is a game between two players. Result is the winner's username,
+// "draw", or "none" when the game was not played out; it stays empty while
+// the game is being played.
+type Pairing struct {
+	GameID    string    `json:"gameId"`
+	Player1   string    `json:"player1"` // Moves first
+	Player2   string    `json:"player2"`
+	Result    string    `json:"result,omitempty"`
+	Points1   int       `json:"points1"`
+	Points2   int       `json:"points2"`
+	Berserk1  bool      `json:"berserk1,omitempty"`
+	Berserk2  bool      `json:"berserk2,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// notice is a WebSocket message for one player.
+type notice struct {
+	username    string
+	messageType string
+	data        interface{}
+}
+
+// Director runs every arena. In cluster mode arenas are kept by the node
+// owning directorKey, which also hosts their games and so sees them end;
+// other nodes forward calls to it.
+type Director struct {
+	games    *game.Manager
+	store    store.Store
+	cluster  *cluster.Cluster // nil when running on a single node
+	saves    *saver
+	mutex    sync.Mutex
+	arenas   map[string]*Arena // Guarded by mutex
+	onNotify func(username, messageType string, data interface{})
+}
+
+// NewDirector returns the director of a server's arenas, resuming any that
+// were running when it last stopped.
+func NewDirector(games *game.Manager, st store.Store) *Director {
+	d := &Director{
+		games:   games,
+		store:   st,
+		cluster: games.Cluster(),
+		saves:   newSaver(st),
+		arenas:  make(map[string]*Arena),
+	}
+	if d.cluster != nil {
+		d.serveCluster()
+	}
+	if !d.here() {
+		return d
+	}
+
+	d.load()
+	games.Events().SubscribeLossless("arenas", d.onGameEvent)
+	go d.run()
+	return d
+}
+
+// SetNotifyCallback sets how players are sent arena messages, such as
+// their next game being ready or the leaderboard changing.
+func (d *Director) SetNotifyCallback(callback func(username, messageType string, data interface{})) {
+	d.onNotify = callback
+}
+
+func (d *Director) notify(notices []notice) {
+	if d.onNotify == nil {
+		return
+	}
+	for _, n := range notices {
+		d.onNotify(n.username, n.messageType, n.data)
+	}
+}
+
+// Create sets up an arena, starting it straight away unless it has a start
+// time in the future.
+func (d *Director) Create(settings Settings, createdBy string) (*Arena, error) {
+	if !d.here() {
+		return d.forward("arena.create", directorArgs{Settings: &settings, Username: createdBy})
+	}
+
+	settings, err := settings.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Arena{
+		ID:        uuid.New().String(),
+		Settings:  settings,
+		Status:    "scheduled",
+		Players:   []*Player{},
+		Pairings:  []*Pairing{},
+		CreatedBy: createdBy,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	d.mutex.Lock()
+	d.arenas[a.ID] = a
+	if a.StartsAt == nil || !a.StartsAt.After(time.Now()) {
+		d.start(a)
+	}
+	d.save(a)
+	view := a.clone()
+	d.mutex.Unlock()
+
+	log.Printf("Arena %s (%s, %d minutes of %s) created by %s", a.ID, a.Name, a.Minutes, a.TimeControl, createdBy)
+	return view, nil
+}
+
+func (s Settings) validate() (Settings, error) {
+	s.Name = strings.TrimSpace(s.Name)
+	if s.Name == "" {
+		return s, ErrNameRequired
+	}
+	if len(s.Name) > maxNameLength {
+		s.Name = s.Name[:maxNameLength]
+	}
+
+	if s.Minutes == 0 {
+		s.Minutes = defaultMinutes
+	}
+	if s.Minutes < 0 || s.Minutes > maxMinutes {
+		return s, ErrInvalidDuration
+	}
+
+	if s.TimeControl == "" {
+		s.TimeControl = defaultTimeControl
+	}
+	prefs, err := s.prefs().Normalize()
+	if err != nil {
+		return s, err
+	}
+	if prefs.TimeControl == "unlimited" {
+		return s, ErrClockRequired
+	}
+	s.Variant = prefs.Variant
+	return s, nil
+}
+
+func (s Settings) prefs() game.QueuePreferences {
+	return game.QueuePreferences{
+		TimeControl: s.TimeControl,
+		Variant:     s.Variant,
+		Rated:       s.Rated,
+	}
+}
+
+// Join enters a player in an arena that is not over, or has a paused
+// player paired again. A player who joins again while playing is sent
+// their game again.
+func (d *Director) Join(id, username string) (*Arena, error) {
+	if !d.here() {
+		return d.forward("arena.join", directorArgs{ID: id, Username: username})
+	}
+
+	username, err := d.games.Admit(username)
+	if err != nil {
+		return nil, err
+	}
+	// May hit the database, so look it up before taking the lock
+	rating := d.games.PlayerRatings([]string{username})[username].Rating
+
+	d.mutex.Lock()
+	a, exists := d.arenas[id]
+	if !exists {
+		d.mutex.Unlock()
+		return nil, ErrArenaNotFound
+	}
+	if a.Status != "scheduled" && a.Status != "running" {
+		d.mutex.Unlock()
+		return nil, ErrArenaOver
+	}
+
+	var notices []notice
+	p := a.player(username)
+	if p == nil {
+		p = &Player{
+			Username: username,
+			Rating:   rating,
+			Sheet:    []int{},
+			JoinedAt: time.Now().UTC(),
+		}
+		a.Players = append(a.Players, p)
+		log.Printf("Player %s joined arena %s", username, id)
+	}
+	if !p.Active {
+		p.Active = true
+		p.waitingSince = time.Now()
+	}
+	if p.GameID != "" {
+		notices = d.remind(a, p)
+	}
+	a.changed = true
+	d.save(a)
+	view := a.clone()
+	d.mutex.Unlock()
+
+	d.notify(notices)
+	return view, nil
+}
+
+// Leave pauses a player: they keep their score, and their game in progress
+// still counts, but they are not paired again until they rejoin.
+func (d *Director) Leave(id, username string) (*Arena, error) {
+	if !d.here() {
+		return d.forward("arena.leave", directorArgs{ID: id, Username: username})
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	a, exists := d.arenas[id]
+	if !exists {
+		return nil, ErrArenaNotFound
+	}
+	p := a.player(strings.TrimSpace(username))
+	if p == nil {
+		return nil, ErrNotJoined
+	}
+
+	if p.Active {
+		p.Active = false
+		d.save(a)
+		log.Printf("Player %s left arena %s", p.Username, id)
+	}
+	return a.clone(), nil
+}
+
+// Start starts a scheduled arena before its start time.
+func (d *Director) Start(id string) (*Arena, error) {
+	if !d.here() {
+		return d.forward("arena.start", directorArgs{ID: id})
+	}
+
+	d.mutex.Lock()
+	a, exists := d.arenas[id]
+	if !exists {
+		d.mutex.Unlock()
+		return nil, ErrArenaNotFound
+	}
+	if a.Status != "scheduled" {
+		d.mutex.Unlock()
+		if a.Status == "running" {
+			return nil, ErrAlreadyStarted
+		}
+		return nil, ErrArenaOver
+	}
+
+	notices := d.start(a)
+	d.save(a)
+	view := a.clone()
+	d.mutex.Unlock()
+
+	d.notify(notices)
+	return view, nil
+}
+
+// start sets an arena's clock running; its players are paired from the
+// next pass. Callers must hold mutex.
+func (d *Director) start(a *Arena) []notice {
+	now := time.Now().UTC()
+	ends := now.Add(time.Duration(a.Minutes) * time.Minute)
+	a.Status = "running"
+	a.StartedAt = &now
+	a.EndsAt = &ends
+	a.changed = true
+
+	var notices []notice
+	for _, p := range a.Players {
+		p.waitingSince = time.Now()
+		notices = append(notices, notice{p.Username, "arena_started", map[string]interface{}{
+			"arenaId": a.ID,
+			"name":    a.Name,
+			"endsAt":  ends.UnixMilli(),
+		}})
+	}
+	log.Printf("Arena %s started with %d players, ending at %s", a.ID, len(a.Players), ends.Format(time.RFC3339))
+	return notices
+}
+
+// Cancel ends an arena early without a winner, aborting its games.
+func (d *Director) Cancel(id, reason string) (*Arena, error) {
+	if !d.here() {
+		return d.forward("arena.cancel", directorArgs{ID: id, Reason: reason})
+	}
+
+	d.mutex.Lock()
+	a, exists := d.arenas[id]
+	if !exists {
+		d.mutex.Unlock()
+		return nil, ErrArenaNotFound
+	}
+	if a.Status != "scheduled" && a.Status != "running" {
+		d.mutex.Unlock()
+		return nil, ErrArenaOver
+	}
+
+	now := time.Now().UTC()
+	a.Status = "cancelled"
+	a.Reason = reason
+	a.FinishedAt = &now
+
+	var live []string
+	var notices []notice
+	for _, p := range a.Pairings {
+		if p.Result == "" {
+			live = append(live, p.GameID)
+		}
+	}
+	for _, p := range a.Players {
+		p.GameID = ""
+		notices = append(notices, notice{p.Username, "arena_cancelled", map[string]interface{}{
+			"arenaId": a.ID,
+			"name":    a.Name,
+			"reason":  reason,
+		}})
+	}
+	d.save(a)
+	view := a.clone()
+	d.mutex.Unlock()
+
+	// Their events are ignored now the arena is over
+	for _, gameID := range live {
+		if _, err := d.games.AbortGame(gameID, "Arena cancelled: "+reason); err != nil && err != game.ErrGameNotActive {
+			log.Printf("Failed to abort game %s of arena %s: %v", gameID, id, err)
+		}
+	}
+
+	log.Printf("Arena %s cancelled: %s", id, reason)
+	d.notify(notices)
+	return view, nil
+}
+
+// Get returns an arena.
+func (d *Director) Get(id string) (*Arena, error) {
+	if !d.here() {
+		return d.forward("arena.get", directorArgs{ID: id})
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	a, exists := d.arenas[id]
+	if !exists {
+		return nil, ErrArenaNotFound
+	}
+	return a.clone(), nil
+}
+
+// List returns the arenas with a status, or all of them when status is
+// empty, newest first.
+func (d *Director) List(status string) ([]*Arena, error) {
+	if !d.here() {
+		var reply []*Arena
+		err := d.call("arena.list", directorArgs{Status: status}, &reply)
+		return reply, err
+	}
+
+	d.mutex.Lock()
+	list := []*Arena{}
+	for _, a := range d.arenas {
+		if status == "" || a.Status == status {
+			list = append(list, a.clone())
+		}
+	}
+	d.mutex.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list, nil
+}
+
+func (a *Arena) player(username string) *Player {
+	for _, p := range a.Players {
+		if p.Username == username {
+			return p
+		}
+	}
+	return nil
+}
+
+// clone returns a copy of an arena that can be read without the
+// director's lock.
+func (a *Arena) clone() *Arena {
+	state, _ := json.Marshal(a)
+	copied := &Arena{}
+	json.Unmarshal(state, copied)
+	return copied
+}
+
+// here reports whether arenas are kept on this node.
+func (d *Director) here() bool {
+	return d.cluster == nil || d.cluster.Owns(directorKey)
+}
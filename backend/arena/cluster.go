@@ -0,0 +1,124 @@
+package arena
+
+import (
+	"connect4-backend/game"
+	"encoding/json"
+)
+
+// In cluster mode the node that owns directorKey keeps every arena and
+// hosts their games. Other nodes forward calls to it.
+
+const directorKey = "arenas"
+
+// remoteErrors are the errors forwarded calls can return. They cross the
+// cluster RPC as text, and are matched back up here.
+var remoteErrors = []error{
+	ErrArenaNotFound, ErrNameRequired, ErrInvalidDuration, ErrClockRequired,
+	ErrNotJoined, ErrAlreadyStarted, ErrArenaOver,
+	game.ErrBanned, game.ErrInvalidUsername, game.ErrReservedUsername, game.ErrInvalidTimeControl, game.ErrUnknownVariant,
+}
+
+type directorArgs struct {
+	ID       string    `json:"id,omitempty"`
+	Username string    `json:"username,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	Status   string    `json:"status,omitempty"`
+	Settings *Settings `json:"settings,omitempty"`
+}
+
+// serveCluster registers the calls other nodes make on the director.
+func (d *Director) serveCluster() {
+	d.cluster.Handle("arena.create", d.handleCreate)
+	d.cluster.Handle("arena.join", d.handleJoin)
+	d.cluster.Handle("arena.leave", d.handleLeave)
+	d.cluster.Handle("arena.start", d.handleStart)
+	d.cluster.Handle("arena.cancel", d.handleCancel)
+	d.cluster.Handle("arena.get", d.handleGet)
+	d.cluster.Handle("arena.list", d.handleList)
+}
+
+func readArgs(body json.RawMessage) (directorArgs, error) {
+	var args directorArgs
+	err := json.Unmarshal(body, &args)
+	return args, err
+}
+
+func (d *Director) handleCreate(body json.RawMessage) (interface{}, error) {
+	args, err := readArgs(body)
+	if err != nil {
+		return nil, err
+	}
+	if args.Settings == nil {
+		return nil, ErrNameRequired
+	}
+	return d.Create(*args.Settings, args.Username)
+}
+
+func (d *Director) handleJoin(body json.RawMessage) (interface{}, error) {
+	args, err := readArgs(body)
+	if err != nil {
+		return nil, err
+	}
+	return d.Join(args.ID, args.Username)
+}
+
+func (d *Director) handleLeave(body json.RawMessage) (interface{}, error) {
+	args, err := readArgs(body)
+	if err != nil {
+		return nil, err
+	}
+	return d.Leave(args.ID, args.Username)
+}
+
+func (d *Director) handleStart(body json.RawMessage) (interface{}, error) {
+	args, err := readArgs(body)
+	if err != nil {
+		return nil, err
+	}
+	return d.Start(args.ID)
+}
+
+func (d *Director) handleCancel(body json.RawMessage) (interface{}, error) {
+	args, err := readArgs(body)
+	if err != nil {
+		return nil, err
+	}
+	return d.Cancel(args.ID, args.Reason)
+}
+
+func (d *Director) handleGet(body json.RawMessage) (interface{}, error) {
+	args, err := readArgs(body)
+	if err != nil {
+		return nil, err
+	}
+	return d.Get(args.ID)
+}
+
+func (d *Director) handleList(body json.RawMessage) (interface{}, error) {
+	args, err := readArgs(body)
+	if err != nil {
+		return nil, err
+	}
+	return d.List(args.Status)
+}
+
+// forward makes a call on the node keeping the arenas.
+func (d *Director) forward(method string, args directorArgs) (*Arena, error) {
+	var reply *Arena
+	if err := d.call(method, args, &reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (d *Director) call(method string, args directorArgs, reply interface{}) error {
+	err := d.cluster.Call(d.cluster.Owner(directorKey), method, args, reply)
+	if err != nil {
+		for _, known := range remoteErrors {
+			if err.Error() == known.Error() {
+				return known
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,514 @@
+package arena
+
+import (
+	"connect4-backend/game"
+	"connect4-backend/store"
+	"encoding/json"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// How arenas are played. Everything here runs with the director's lock
+// held, and only collects the notices to send once it is released.
+
+// Points
+const (
+	winPoints    = 2
+	drawPoints   = 1
+	berserkBonus = 1
+
+	// streakLength is how many wins in a row put a player on a streak,
+	// doubling what their games score until they fail to win.
+	streakLength = 2
+)
+
+// run starts scheduled arenas, finishes those whose time is up, pairs the
+// players waiting in the others and sends out changed leaderboards.
+func (d *Director) run() {
+	ticker := time.NewTicker(pairingInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		var notices []notice
+		d.mutex.Lock()
+		for _, a := range d.arenas {
+			if a.Status == "scheduled" && a.StartsAt != nil && !a.StartsAt.After(now) {
+				notices = append(notices, d.start(a)...)
+				d.save(a)
+			}
+			if a.Status != "running" {
+				continue
+			}
+
+			if !a.EndsAt.After(now) {
+				d.finish(a, &notices)
+				d.save(a)
+				continue
+			}
+			if d.pair(a, now, &notices) {
+				d.save(a)
+			}
+			if a.changed {
+				a.changed = false
+				notices = append(notices, leaderboardNotices(a)...)
+			}
+		}
+		d.mutex.Unlock()
+
+		d.notify(notices)
+	}
+}
+
+// pair starts games between the arena's waiting players, longest waiting
+// first. Like the matchmaker, it pairs each with the closest-rated player
+// within both their rating windows, and avoids pairing two players who
+// just met unless they have waited for a while. It reports whether any
+// game was started.
+func (d *Director) pair(a *Arena, now time.Time, notices *[]notice) bool {
+	var waiting []*Player
+	for _, p := range a.Players {
+		if p.Active && p.GameID == "" {
+			waiting = append(waiting, p)
+		}
+	}
+	if len(waiting) < 2 {
+		return false
+	}
+	sort.SliceStable(waiting, func(i, j int) bool {
+		return waiting[i].waitingSince.Before(waiting[j].waitingSince)
+	})
+
+	started := false
+	matched := make(map[*Player]bool)
+	for i, first := range waiting {
+		if matched[first] {
+			continue
+		}
+
+		waited := now.Sub(first.waitingSince)
+		var best *Player
+		bestSpread := 0.0
+		for _, second := range waiting[i+1:] {
+			if matched[second] {
+				continue
+			}
+			spread := math.Abs(first.Rating - second.Rating)
+			if spread > game.RatingWindow(waited) || spread > game.RatingWindow(now.Sub(second.waitingSince)) {
+				continue
+			}
+			if a.justMet(first.Username, second.Username) && now.Sub(second.waitingSince) < rematchWait {
+				continue
+			}
+			if best == nil || spread < bestSpread {
+				best, bestSpread = second, spread
+			}
+		}
+		if best == nil {
+			continue
+		}
+
+		matched[first], matched[best] = true, true
+		if d.play(a, first, best, notices) {
+			started = true
+		}
+	}
+	return started
+}
+
+// play starts a game between two players, the one who has moved first
+// less often moving first. A player who cannot play, such as one banned
+// since joining, is paused.
+func (d *Director) play(a *Arena, one, other *Player, notices *[]notice) bool {
+	first, second := one, other
+	if a.firstMoves(other.Username) < a.firstMoves(one.Username) {
+		first, second = other, one
+	}
+
+	g, err := d.games.CreateMatch(game.Match{
+		Player1: first.Username,
+		Player2: second.Username,
+		Prefs:   a.prefs(),
+		Arena:   a.ID,
+		ShowUp:  showUpTime,
+	})
+	if err != nil {
+		log.Printf("Arena %s: could not start %s vs %s: %v", a.ID, first.Username, second.Username, err)
+		for _, p := range []*Player{first, second} {
+			if d.games.IsBanned(p.Username) {
+				*notices = append(*notices, pause(a, p, "banned"))
+			}
+		}
+		return false
+	}
+
+	first.GameID, second.GameID = g.ID, g.ID
+	a.Pairings = append(a.Pairings, &Pairing{
+		GameID:    g.ID,
+		Player1:   first.Username,
+		Player2:   second.Username,
+		StartedAt: time.Now().UTC(),
+	})
+	a.changed = true
+	*notices = append(*notices, readyNotice(a, g, first.Username), readyNotice(a, g, second.Username))
+	return true
+}
+
+// readyNotice tells a player their next arena game is waiting for them.
+func readyNotice(a *Arena, g *game.Game, username string) notice {
+	player, opponent := game.PLAYER1, g.Player2.Username
+	if g.Player2.Username == username {
+		player, opponent = game.PLAYER2, g.Player1.Username
+	}
+	return notice{username, "arena_game_ready", map[string]interface{}{
+		"arenaId":  a.ID,
+		"name":     a.Name,
+		"gameId":   g.ID,
+		"opponent": opponent,
+		"player":   player,
+		"deadline": g.CreatedAt.Add(showUpTime).UnixMilli(),
+		"endsAt":   a.EndsAt.UnixMilli(),
+	}}
+}
+
+// remind sends a player the game they are playing again.
+func (d *Director) remind(a *Arena, p *Player) []notice {
+	if g, exists := d.games.GetGame(p.GameID); exists && g.Status == "playing" {
+		return []notice{readyNotice(a, g, p.Username)}
+	}
+	return nil
+}
+
+// pause stops pairing a player until they join again, and tells them why.
+func pause(a *Arena, p *Player, reason string) notice {
+	p.Active = false
+	a.changed = true
+	return notice{p.Username, "arena_paused", map[string]interface{}{
+		"arenaId": a.ID,
+		"name":    a.Name,
+		"reason":  reason,
+	}}
+}
+
+// onGameEvent scores arena games as they end and frees their players to
+// be paired again. A player who forfeits by leaving is paused, as they
+// are clearly not at the board.
+func (d *Director) onGameEvent(event game.Event) {
+	var g *game.Game
+	absent, aborted := "", false
+	switch e := event.(type) {
+	case game.GameFinished:
+		g = e.Game
+	case game.GameAborted:
+		g, absent, aborted = e.Game, e.Player, true
+	default:
+		return
+	}
+	if g.Arena == "" || g.Player2 == nil {
+		return
+	}
+
+	d.mutex.Lock()
+	a, exists := d.arenas[g.Arena]
+	if !exists || a.Status != "running" {
+		// Games still being played when an arena ends do not count
+		d.mutex.Unlock()
+		return
+	}
+	pairing := a.pairingFor(g.ID)
+	if pairing == nil {
+		d.mutex.Unlock()
+		return
+	}
+
+	var notices []notice
+	if aborted {
+		pairing.Result = "none"
+	} else {
+		a.score(pairing, winnerOf(g), g.Clock)
+		if g.EndReason == "disconnect" {
+			absent = g.Player1.Username
+			if g.Winner == game.PLAYER1 {
+				absent = g.Player2.Username
+			}
+		}
+	}
+
+	for _, username := range []string{pairing.Player1, pairing.Player2} {
+		p := a.player(username)
+		p.GameID = ""
+		p.waitingSince = time.Now()
+		if username == absent && p.Active {
+			notices = append(notices, pause(a, p, "left a game"))
+		}
+	}
+	a.changed = true
+	d.save(a)
+	d.mutex.Unlock()
+
+	d.notify(notices)
+}
+
+// score records the result of a pairing's game and awards its points.
+func (a *Arena) score(pairing *Pairing, winner string, clock *game.Clock) {
+	pairing.Result = winner
+	if clock != nil {
+		pairing.Berserk1, pairing.Berserk2 = clock.Berserk1, clock.Berserk2
+	}
+	pairing.Points1 = award(a.player(pairing.Player1), winner, pairing.Berserk1)
+	pairing.Points2 = award(a.player(pairing.Player2), winner, pairing.Berserk2)
+}
+
+// award adds the points a game scored to a player's total and returns
+// them. Wins score 2 and draws 1, doubled on a streak; a berserk win
+// scores 1 more.
+func award(p *Player, winner string, berserk bool) int {
+	points := 0
+	switch winner {
+	case p.Username:
+		points = winPoints
+		p.Wins++
+	case "draw":
+		points = drawPoints
+		p.Draws++
+	default:
+		p.Losses++
+	}
+	if p.Streak >= streakLength {
+		points *= 2
+	}
+
+	if winner == p.Username {
+		p.Streak++
+		if berserk {
+			points += berserkBonus
+			p.Berserks++
+		}
+	} else {
+		p.Streak = 0
+	}
+
+	p.Score += points
+	p.Sheet = append(p.Sheet, points)
+	return points
+}
+
+// finish ends an arena whose time is up and tells its players how they
+// did.
+func (d *Director) finish(a *Arena, notices *[]notice) {
+	standings := Standings(a)
+	now := time.Now().UTC()
+	a.Status = "finished"
+	a.FinishedAt = &now
+	if len(standings) > 0 {
+		a.Winner = standings[0].Username
+	}
+	for _, p := range a.Players {
+		p.GameID = ""
+	}
+
+	for _, s := range standings {
+		*notices = append(*notices, notice{s.Username, "arena_finished", map[string]interface{}{
+			"arenaId": a.ID,
+			"name":    a.Name,
+			"winner":  a.Winner,
+			"rank":    s.Rank,
+			"score":   s.Score,
+		}})
+	}
+	log.Printf("Arena %s finished after %d games, won by %q", a.ID, len(a.Pairings), a.Winner)
+}
+
+// leaderboardNotices sends every player in an arena its leaderboard, with
+// their own standing.
+func leaderboardNotices(a *Arena) []notice {
+	standings := Standings(a)
+	top := standings
+	if len(top) > leaderboardSize {
+		top = top[:leaderboardSize]
+	}
+
+	notices := make([]notice, 0, len(standings))
+	for _, s := range standings {
+		notices = append(notices, notice{s.Username, "arena_leaderboard", map[string]interface{}{
+			"arenaId":     a.ID,
+			"name":        a.Name,
+			"endsAt":      a.EndsAt.UnixMilli(),
+			"players":     len(standings),
+			"leaderboard": top,
+			"you":         s,
+		}})
+	}
+	return notices
+}
+
+// justMet reports whether either player's latest game was against the
+// other.
+func (a *Arena) justMet(one, other string) bool {
+	return a.lastOpponent(one) == other || a.lastOpponent(other) == one
+}
+
+func (a *Arena) lastOpponent(username string) string {
+	for i := len(a.Pairings) - 1; i >= 0; i-- {
+		switch p := a.Pairings[i]; username {
+		case p.Player1:
+			return p.Player2
+		case p.Player2:
+			return p.Player1
+		}
+	}
+	return ""
+}
+
+// firstMoves counts the games in which a player moved first.
+func (a *Arena) firstMoves(username string) int {
+	count := 0
+	for _, p := range a.Pairings {
+		if p.Player1 == username {
+			count++
+		}
+	}
+	return count
+}
+
+// pairingFor returns the undecided pairing whose game is gameID.
+func (a *Arena) pairingFor(gameID string) *Pairing {
+	for i := len(a.Pairings) - 1; i >= 0; i-- {
+		if p := a.Pairings[i]; p.GameID == gameID && p.Result == "" {
+			return p
+		}
+	}
+	return nil
+}
+
+func winnerOf(g *game.Game) string {
+	switch g.Winner {
+	case game.PLAYER1:
+		return g.Player1.Username
+	case game.PLAYER2:
+		return g.Player2.Username
+	}
+	return "draw"
+}
+
+// load reads the arenas from the store, before the director is in use.
+// Running arenas pick up where they were: results that came in while the
+// server was down are scored, and players whose game was lost with it are
+// paired again. Arenas whose time ran out meanwhile finish on the first
+// pass.
+func (d *Director) load() {
+	states, err := d.store.Arenas()
+	if err != nil {
+		log.Printf("Failed to load arenas: %v", err)
+		return
+	}
+
+	resumed := 0
+	for _, state := range states {
+		a := &Arena{}
+		if err := json.Unmarshal(state, a); err != nil {
+			log.Printf("Skipping unreadable arena: %v", err)
+			continue
+		}
+		d.arenas[a.ID] = a
+		if a.Status == "running" {
+			d.resume(a)
+			resumed++
+		}
+	}
+
+	if resumed > 0 {
+		log.Printf("Resumed %d arenas", resumed)
+	}
+}
+
+func (d *Director) resume(a *Arena) {
+	now := time.Now()
+	for _, p := range a.Players {
+		p.waitingSince = now
+	}
+
+	for _, pairing := range a.Pairings {
+		if pairing.Result != "" {
+			continue
+		}
+
+		if g, exists := d.games.GetGame(pairing.GameID); exists {
+			switch g.Status {
+			case "playing":
+				continue
+			case "finished":
+				a.score(pairing, winnerOf(g), g.Clock)
+			default:
+				pairing.Result = "none"
+			}
+		} else if record, err := d.games.FinishedGame(pairing.GameID); err == nil {
+			a.score(pairing, record.Winner, nil)
+		} else {
+			pairing.Result = "none"
+		}
+
+		a.player(pairing.Player1).GameID = ""
+		a.player(pairing.Player2).GameID = ""
+	}
+	d.save(a)
+}
+
+// save queues a write of an arena's state. Callers must hold mutex.
+func (d *Director) save(a *Arena) {
+	state, err := json.Marshal(a)
+	if err != nil {
+		log.Printf("Failed to save arena %s: %v", a.ID, err)
+		return
+	}
+	d.saves.queue(a.ID, state)
+}
+
+// saver writes arena states in the background, so a slow store never holds
+// up the director; only each arena's latest state waits.
+type saver struct {
+	store   store.Store
+	mutex   sync.Mutex
+	pending map[string][]byte // Latest unwritten state, keyed by arena ID
+	wake    chan struct{}
+}
+
+func newSaver(st store.Store) *saver {
+	s := &saver{
+		store:   st,
+		pending: make(map[string][]byte),
+		wake:    make(chan struct{}, 1),
+	}
+	go s.run()
+	return s
+}
+
+func (s *saver) queue(id string, state []byte) {
+	s.mutex.Lock()
+	s.pending[id] = state
+	s.mutex.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+		// Already woken; the writer will pick this up too
+	}
+}
+
+func (s *saver) run() {
+	for range s.wake {
+		s.mutex.Lock()
+		pending := s.pending
+		s.pending = make(map[string][]byte)
+		s.mutex.Unlock()
+
+		for id, state := range pending {
+			if err := s.store.SaveArena(id, state); err != nil {
+				log.Printf("Failed to save arena %s: %v", id, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package arena
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAward(t *testing.T) {
+	type result struct {
+		winner  string
+		berserk bool
+	}
+	tests := []struct {
+		name       string
+		games      []result
+		wantSheet  []int
+		wantStreak int
+	}{
+		{
+			name:       "wins and draws",
+			games:      []result{{"me", false}, {"draw", false}, {"them", false}},
+			wantSheet:  []int{2, 1, 0},
+			wantStreak: 0,
+		},
+		{
+			name:       "streak doubles from the third win",
+			games:      []result{{"me", false}, {"me", false}, {"me", false}, {"me", false}},
+			wantSheet:  []int{2, 2, 4, 4},
+			wantStreak: 4,
+		},
+		{
+			name:       "draw on a streak is doubled and ends it",
+			games:      []result{{"me", false}, {"me", false}, {"draw", false}, {"me", false}},
+			wantSheet:  []int{2, 2, 2, 2},
+			wantStreak: 1,
+		},
+		{
+			name:       "loss ends a streak",
+			games:      []result{{"me", false}, {"me", false}, {"them", false}, {"me", false}},
+			wantSheet:  []int{2, 2, 0, 2},
+			wantStreak: 1,
+		},
+		{
+			name:       "berserk win scores a point more, not doubled",
+			games:      []result{{"me", true}, {"me", true}, {"me", true}},
+			wantSheet:  []int{3, 3, 5},
+			wantStreak: 3,
+		},
+		{
+			name:       "berserk only counts for a win",
+			games:      []result{{"draw", true}, {"them", true}},
+			wantSheet:  []int{1, 0},
+			wantStreak: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Player{Username: "me"}
+			score := 0
+			for _, g := range tt.games {
+				score += award(p, g.winner, g.berserk)
+			}
+
+			if !reflect.DeepEqual(p.Sheet, tt.wantSheet) {
+				t.Errorf("sheet = %v, want %v", p.Sheet, tt.wantSheet)
+			}
+			if p.Score != score {
+				t.Errorf("score = %d, want %d", p.Score, score)
+			}
+			if p.Streak != tt.wantStreak {
+				t.Errorf("streak = %d, want %d", p.Streak, tt.wantStreak)
+			}
+		})
+	}
+}
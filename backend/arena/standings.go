@@ -0,0 +1,66 @@
+package arena
+
+import "sort"
+
+// Standing is a player's place in an arena. Players on the same score
+// share a rank.
+type Standing struct {
+	Rank     int     `json:"rank"`
+	Username string  `json:"username"`
+	Rating   float64 `json:"rating"`
+	Score    int     `json:"score"`
+	Games    int     `json:"games"`
+	Wins     int     `json:"wins"`
+	Draws    int     `json:"draws"`
+	Losses   int     `json:"losses"`
+	Streak   bool    `json:"streak"` // Their next game scores double
+	Berserks int     `json:"berserks"`
+	Sheet    []int   `json:"sheet"`
+	Active   bool    `json:"active"`
+	Playing  bool    `json:"playing"`
+}
+
+// Standings ranks an arena's players by score. Ties are listed by wins,
+// then rating.
+func Standings(a *Arena) []Standing {
+	standings := make([]Standing, 0, len(a.Players))
+	for _, p := range a.Players {
+		standings = append(standings, Standing{
+			Username: p.Username,
+			Rating:   p.Rating,
+			Score:    p.Score,
+			Games:    len(p.Sheet),
+			Wins:     p.Wins,
+			Draws:    p.Draws,
+			Losses:   p.Losses,
+			Streak:   p.Streak >= streakLength,
+			Berserks: p.Berserks,
+			Sheet:    append([]int{}, p.Sheet...),
+			Active:   p.Active,
+			Playing:  p.GameID != "",
+		})
+	}
+
+	sort.Slice(standings, func(i, j int) bool {
+		a, b := standings[i], standings[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Wins != b.Wins {
+			return a.Wins > b.Wins
+		}
+		if a.Rating != b.Rating {
+			return a.Rating > b.Rating
+		}
+		return a.Username < b.Username
+	})
+
+	for i := range standings {
+		if i > 0 && standings[i].Score == standings[i-1].Score {
+			standings[i].Rank = standings[i-1].Rank
+		} else {
+			standings[i].Rank = i + 1
+		}
+	}
+	return standings
+}
@@ -1,7 +1,9 @@
 package bot
 
 import (
+	"math"
 	"math/rand"
+	"sync"
 	"time"
 )
 
@@ -13,25 +15,94 @@ const (
 	PLAYER2 = 2
 )
 
+// Strategy chooses a column for player. moves holds the columns played so
+// far, which lets strategies that work from move history (such as external
+// engines) rebuild the position without inspecting the board.
+type Strategy interface {
+	BestMove(board [][]int, moves []int, player int, level int) int
+}
+
+// MaxLevel is the strongest difficulty level. LevelRatings calibrates each
+// level against the rating scale used for players, so the bot can be tuned
+// to a player's estimated skill.
+const MaxLevel = 5
+
+var LevelRatings = [MaxLevel + 1]float64{800, 1000, 1200, 1400, 1600, 1800}
+
+// LevelForRating returns the level whose calibrated rating is closest to rating.
+func LevelForRating(rating float64) int {
+	best := 0
+	for level := 1; level <= MaxLevel; level++ {
+		if math.Abs(LevelRatings[level]-rating) < math.Abs(LevelRatings[best]-rating) {
+			best = level
+		}
+	}
+	return best
+}
+
+// Bot is shared by every bot game, and may be asked for moves in several at
+// once.
 type Bot struct {
-	rand *rand.Rand
+	rand    *rand.Rand // Safe for concurrent use, unlike most rand.Rands
+	workers int
+	weights *Weights
 }
 
 func NewBot() *Bot {
+	return NewBotWithWeights(DefaultWeights())
+}
+
+func NewBotWithWeights(weights *Weights) *Bot {
 	return &Bot{
-		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+		rand:    rand.New(&lockedSource{source: rand.NewSource(time.Now().UnixNano()).(rand.Source64)}),
+		workers: defaultSearchWorkers(),
+		weights: weights,
 	}
 }
 
+// lockedSource lets games searching at the same time share one random
+// source.
+type lockedSource struct {
+	mutex  sync.Mutex
+	source rand.Source64
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.source.Int63()
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.source.Uint64()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.source.Seed(seed)
+}
+
+func (b *Bot) Weights() *Weights {
+	return b.weights
+}
+
+// BestMove searches hard levels with a fresh transposition table. Games that
+// want pondering and a table kept across moves should use a Session instead.
+func (b *Bot) BestMove(board [][]int, moves []int, player int, level int) int {
+	return b.NewSession().BestMove(board, moves, player, level)
+}
+
 func (b *Bot) GetBestMove(board [][]int, player int) int {
 	return b.GetBestMoveWithDifficulty(board, player, 0)
 }
 
-func (b *Bot) GetBestMoveWithDifficulty(board [][]int, player int, playerWins int) int {
-	// Calculate difficulty level based on player's consecutive wins
-	difficultyLevel := playerWins
-	if difficultyLevel > 5 {
-		difficultyLevel = 5 // Cap at level 5
+func (b *Bot) GetBestMoveWithDifficulty(board [][]int, player int, level int) int {
+	difficultyLevel := level
+	if difficultyLevel > MaxLevel {
+		difficultyLevel = MaxLevel
 	}
 	
 	// At higher difficulty levels, bot makes fewer mistakes
@@ -187,12 +258,8 @@ func (b *Bot) evaluatePosition(board [][]int, row, col, player int) int {
 		score += b.evaluateDirection(board, row, col, dir[0], dir[1], player)
 	}
 
-	// Bonus for center column
-	if col == 3 {
-		score += 3
-	} else if col == 2 || col == 4 {
-		score += 2
-	}
+	// Bonus for center columns
+	score += b.weights.Center[col]
 
 	return score
 }
@@ -236,17 +303,17 @@ func (b *Bot) evaluateDirection(board [][]int, row, col, deltaRow, deltaCol, pla
 	}
 
 	// Score based on count and open ends
-	if count >= 4 {
-		return 1000 // Winning move
-	} else if count == 3 && openEnds > 0 {
-		return 50
-	} else if count == 2 && openEnds > 0 {
-		return 10
-	} else if count == 1 && openEnds > 1 {
-		return 1
+	lines := b.weights.Lines
+	switch {
+	case count >= 4:
+		return lines.Win
+	case count == 3:
+		return lines.Three.value(openEnds)
+	case count == 2:
+		return lines.Two.value(openEnds)
+	default:
+		return lines.One.value(openEnds)
 	}
-
-	return 0
 }
 
 func (b *Bot) canDropPiece(board [][]int, col int) bool {
@@ -0,0 +1,372 @@
+package bot
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	engineHandshakeTimeout = 5 * time.Second
+	engineMoveGrace        = 500 * time.Millisecond
+	engineMinBackoff       = 2 * time.Second
+	engineMaxBackoff       = time.Minute
+	defaultEngineProcesses = 2
+)
+
+var (
+	ErrEngineTimeout  = errors.New("engine did not respond in time")
+	ErrEngineExited   = errors.New("engine process exited")
+	ErrEngineBadReply = errors.New("engine sent an invalid reply")
+)
+
+type EngineConfig struct {
+	Path      string
+	Args      []string
+	MoveTime  time.Duration
+	Processes int // Engine processes run side by side, one game each
+}
+
+// Engine drives an external Connect Four engine over a line-based
+// stdin/stdout protocol modelled on UCI. A session looks like this
+// (">" is sent by the server, "<" by the engine):
+//
+//	> c4engine
+//	< id name MyEngine
+//	< id author Someone
+//	< option name Level          (optional, see setoption below)
+//	< c4engineok
+//	> isready
+//	< readyok
+//	> setoption name Level value 3   (only if the engine declared it)
+//	> position startpos moves 4453
+//	> go movetime 1000
+//	< info ...                   (ignored)
+//	< bestmove 4
+//	> quit
+//
+// Columns are written 1-7 from left to right, so "4453" means the first
+// player dropped into the centre column twice, and so on. Every "go" is
+// preceded by a full "position" command, so engines need not keep state
+// between requests.
+//
+// Each process thinks about one game at a time. While every process is
+// busy, moves are taken from the fallback strategy rather than keep games
+// waiting.
+//
+// If the engine fails the handshake, misses its deadline, replies with an
+// illegal move or exits, the process is killed and the move is taken from
+// the fallback strategy instead. The engine is restarted on a later request
+// once its restart backoff has elapsed.
+type Engine struct {
+	config    EngineConfig
+	fallback  Strategy
+	processes []*engineProcess
+	idle      chan *engineProcess
+}
+
+// engineProcess is one running copy of the engine.
+type engineProcess struct {
+	config EngineConfig
+
+	mutex       sync.Mutex
+	cmd         *exec.Cmd
+	stdin       io.WriteCloser
+	lines       chan string
+	name        string
+	hasLevel    bool
+	backoff     time.Duration
+	nextRestart time.Time
+}
+
+// NewEngineFromEnv starts the engine named by BOT_ENGINE_PATH, as many
+// times as BOT_ENGINE_PROCESSES says. It returns nil when no engine is
+// configured, mirroring the other optional services.
+func NewEngineFromEnv(fallback Strategy) (*Engine, error) {
+	path := os.Getenv("BOT_ENGINE_PATH")
+	if path == "" {
+		return nil, nil
+	}
+
+	config := EngineConfig{
+		Path:      path,
+		Args:      strings.Fields(os.Getenv("BOT_ENGINE_ARGS")),
+		MoveTime:  time.Second,
+		Processes: envInt("BOT_ENGINE_PROCESSES", defaultEngineProcesses),
+	}
+	if value := os.Getenv("BOT_ENGINE_MOVETIME"); value != "" {
+		moveTime, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BOT_ENGINE_MOVETIME: %v", err)
+		}
+		config.MoveTime = moveTime
+	}
+
+	return NewEngine(config, fallback)
+}
+
+func NewEngine(config EngineConfig, fallback Strategy) (*Engine, error) {
+	if config.MoveTime <= 0 {
+		config.MoveTime = time.Second
+	}
+	if config.Processes < 1 {
+		config.Processes = 1
+	}
+
+	engine := &Engine{
+		config:   config,
+		fallback: fallback,
+		idle:     make(chan *engineProcess, config.Processes),
+	}
+
+	for i := 0; i < config.Processes; i++ {
+		process := &engineProcess{config: config, backoff: engineMinBackoff}
+		process.mutex.Lock()
+		err := process.start()
+		process.mutex.Unlock()
+		if err != nil {
+			engine.Close()
+			return nil, err
+		}
+
+		engine.processes = append(engine.processes, process)
+		engine.idle <- process
+	}
+
+	log.Printf("External engine %q started %d times (%s)", engine.processes[0].name, config.Processes, config.Path)
+	return engine, nil
+}
+
+func (e *Engine) BestMove(board [][]int, moves []int, player int, level int) int {
+	var process *engineProcess
+	select {
+	case process = <-e.idle:
+		defer func() { e.idle <- process }()
+	default:
+		// Every process is thinking about another game
+		return e.fallback.BestMove(board, moves, player, level)
+	}
+
+	process.mutex.Lock()
+	defer process.mutex.Unlock()
+
+	if process.cmd == nil && time.Now().Before(process.nextRestart) {
+		return e.fallback.BestMove(board, moves, player, level)
+	}
+
+	column, err := process.search(board, moves, level)
+	if err != nil {
+		log.Printf("External engine error: %v (using fallback)", err)
+		process.stop()
+		process.scheduleRestart()
+		return e.fallback.BestMove(board, moves, player, level)
+	}
+
+	process.backoff = engineMinBackoff
+	return column
+}
+
+func (e *Engine) Close() error {
+	for _, process := range e.processes {
+		process.close()
+	}
+	return nil
+}
+
+func (e *engineProcess) close() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.cmd == nil {
+		return
+	}
+
+	e.send("quit")
+	done := make(chan struct{})
+	go func() {
+		e.cmd.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(engineHandshakeTimeout):
+		e.cmd.Process.Kill()
+		<-done
+	}
+
+	e.cmd = nil
+}
+
+func (e *engineProcess) search(board [][]int, moves []int, level int) (int, error) {
+	if e.cmd == nil {
+		if err := e.start(); err != nil {
+			return 0, err
+		}
+		log.Printf("External engine %q restarted", e.name)
+	}
+
+	if e.hasLevel {
+		if err := e.send(fmt.Sprintf("setoption name Level value %d", level)); err != nil {
+			return 0, err
+		}
+	}
+
+	var position strings.Builder
+	position.WriteString("position startpos")
+	if len(moves) > 0 {
+		position.WriteString(" moves ")
+		for _, col := range moves {
+			position.WriteByte(byte('1' + col))
+		}
+	}
+	if err := e.send(position.String()); err != nil {
+		return 0, err
+	}
+	if err := e.send(fmt.Sprintf("go movetime %d", e.config.MoveTime.Milliseconds())); err != nil {
+		return 0, err
+	}
+
+	reply, err := e.waitFor("bestmove", e.config.MoveTime+engineMoveGrace)
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(reply)
+	if len(fields) < 2 {
+		return 0, ErrEngineBadReply
+	}
+	column, err := strconv.Atoi(fields[1])
+	if err != nil || column < 1 || column > COLS || board[0][column-1] != EMPTY {
+		return 0, fmt.Errorf("%w: %q", ErrEngineBadReply, reply)
+	}
+
+	return column - 1, nil
+}
+
+func (e *engineProcess) start() error {
+	cmd := exec.Command(e.config.Path, e.config.Args...)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		stdin.Close()
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	lines := make(chan string, 16)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			lines <- strings.TrimSpace(scanner.Text())
+		}
+		close(lines)
+	}()
+
+	e.cmd = cmd
+	e.stdin = stdin
+	e.lines = lines
+	e.name = e.config.Path
+	e.hasLevel = false
+
+	if err := e.handshake(); err != nil {
+		e.stop()
+		return err
+	}
+
+	return nil
+}
+
+func (e *engineProcess) handshake() error {
+	if err := e.send("c4engine"); err != nil {
+		return err
+	}
+
+	deadline := time.After(engineHandshakeTimeout)
+	for {
+		select {
+		case line, ok := <-e.lines:
+			if !ok {
+				return ErrEngineExited
+			}
+			switch {
+			case strings.HasPrefix(line, "id name "):
+				e.name = strings.TrimPrefix(line, "id name ")
+			case line == "option name Level" || strings.HasPrefix(line, "option name Level "):
+				e.hasLevel = true
+			case line == "c4engineok":
+				if err := e.send("isready"); err != nil {
+					return err
+				}
+				_, err := e.waitFor("readyok", engineHandshakeTimeout)
+				return err
+			}
+		case <-deadline:
+			return ErrEngineTimeout
+		}
+	}
+}
+
+// waitFor reads engine output until a line starting with keyword arrives,
+// discarding anything else (info lines, debug output).
+func (e *engineProcess) waitFor(keyword string, timeout time.Duration) (string, error) {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case line, ok := <-e.lines:
+			if !ok {
+				return "", ErrEngineExited
+			}
+			if line == keyword || strings.HasPrefix(line, keyword+" ") {
+				return line, nil
+			}
+		case <-deadline:
+			return "", ErrEngineTimeout
+		}
+	}
+}
+
+func (e *engineProcess) send(command string) error {
+	if _, err := io.WriteString(e.stdin, command+"\n"); err != nil {
+		return fmt.Errorf("%w: %v", ErrEngineExited, err)
+	}
+	return nil
+}
+
+func (e *engineProcess) stop() {
+	if e.cmd == nil {
+		return
+	}
+
+	e.stdin.Close()
+	e.cmd.Process.Kill()
+	go e.cmd.Wait()
+	go func(lines chan string) {
+		for range lines {
+		}
+	}(e.lines)
+	e.cmd = nil
+}
+
+func (e *engineProcess) scheduleRestart() {
+	e.nextRestart = time.Now().Add(e.backoff)
+	e.backoff *= 2
+	if e.backoff > engineMaxBackoff {
+		e.backoff = engineMaxBackoff
+	}
+}
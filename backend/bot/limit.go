@@ -0,0 +1,142 @@
+package bot
+
+import (
+	"context"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// searchThreads caps the number of goroutines searching at once across all
+// games, so a burst of bot games cannot take every core away from the
+// WebSocket hub. It is sized from BOT_SEARCH_THREADS, defaulting to one
+// less than the number of CPUs.
+//
+// Searches for moves games are waiting on come first: while one waits for
+// a thread, no optional thread is handed out, and pondering gives its
+// threads up.
+var searchThreads = newThreadLimiter(envInt("BOT_SEARCH_THREADS", runtime.NumCPU()-1))
+
+type threadLimiter struct {
+	slots chan struct{}
+
+	mutex   sync.Mutex
+	waiting int                        // Searches games are waiting on, blocked in acquire
+	ponders map[int]context.CancelFunc // Background searches holding a slot
+	nextID  int
+}
+
+func newThreadLimiter(size int) *threadLimiter {
+	if size < 1 {
+		size = 1
+	}
+	return &threadLimiter{
+		slots:   make(chan struct{}, size),
+		ponders: make(map[int]context.CancelFunc),
+	}
+}
+
+// acquire takes a slot for a search a game is waiting on, cancelling a
+// background search to free one if they are all taken.
+func (l *threadLimiter) acquire(ctx context.Context) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	l.mutex.Lock()
+	l.waiting++
+	for id, cancel := range l.ponders {
+		delete(l.ponders, id)
+		cancel()
+		break
+	}
+	l.mutex.Unlock()
+
+	defer func() {
+		l.mutex.Lock()
+		l.waiting--
+		l.mutex.Unlock()
+	}()
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// tryAcquire takes a free slot for an optional thread, such as a search
+// helper, unless a search a game is waiting on needs it.
+func (l *threadLimiter) tryAcquire() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.take()
+}
+
+// tryAcquireBackground is tryAcquire for a background search, which cancel
+// stops when a game's search needs the slot. It returns the function that
+// releases the slot, or nil if none was free.
+func (l *threadLimiter) tryAcquireBackground(cancel context.CancelFunc) func() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if !l.take() {
+		return nil
+	}
+
+	id := l.nextID
+	l.nextID++
+	l.ponders[id] = cancel
+	return func() {
+		l.mutex.Lock()
+		delete(l.ponders, id)
+		l.mutex.Unlock()
+		l.release()
+	}
+}
+
+// take takes a free slot if no search a game is waiting on needs one.
+// Callers must hold mutex.
+func (l *threadLimiter) take() bool {
+	if l.waiting > 0 {
+		return false
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *threadLimiter) release() {
+	<-l.slots
+}
+
+// defaultSearchWorkers is the number of goroutines a single search may use,
+// from BOT_SEARCH_WORKERS.
+func defaultSearchWorkers() int {
+	workers := runtime.NumCPU()
+	if workers > 4 {
+		workers = 4
+	}
+	return envInt("BOT_SEARCH_WORKERS", workers)
+}
+
+func envInt(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		log.Printf("Ignoring invalid %s %q", name, value)
+		return fallback
+	}
+	return n
+}
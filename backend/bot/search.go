@@ -0,0 +1,490 @@
+package bot
+
+import (
+	"context"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The search works on bitboards: each column takes ROWS+1 bits (one spare
+// bit on top so shifts never carry into the next column), bottom row first.
+const (
+	columnHeight = ROWS + 1
+
+	winScore     = 1000000
+	winThreshold = winScore - ROWS*COLS - 1
+	infinity     = winScore + 1
+
+	// Levels at or above searchMinLevel use alpha-beta search instead of the
+	// heuristic move picker.
+	searchMinLevel  = 4
+	searchTimeLimit = time.Second
+
+	// Extra plies searched on the opponent's time, see Session.Ponder.
+	ponderExtraDepth = 6
+)
+
+var (
+	bottomMask uint64
+	boardMask  uint64
+	windows    []uint64
+	moveOrder  = []int{3, 2, 4, 1, 5, 0, 6}
+)
+
+func init() {
+	for col := 0; col < COLS; col++ {
+		bottomMask |= 1 << uint(col*columnHeight)
+	}
+	boardMask = bottomMask * ((1 << ROWS) - 1)
+
+	directions := [][2]int{{1, 0}, {0, 1}, {1, 1}, {1, -1}}
+	for col := 0; col < COLS; col++ {
+		for row := 0; row < ROWS; row++ {
+			for _, dir := range directions {
+				endCol, endRow := col+3*dir[0], row+3*dir[1]
+				if endCol < 0 || endCol >= COLS || endRow < 0 || endRow >= ROWS {
+					continue
+				}
+				var window uint64
+				for i := 0; i < 4; i++ {
+					window |= cellBit(col+i*dir[0], row+i*dir[1])
+				}
+				windows = append(windows, window)
+			}
+		}
+	}
+}
+
+// cellBit returns the bit for a cell, with row 0 at the bottom of the board.
+func cellBit(col, row int) uint64 {
+	return 1 << uint(col*columnHeight+row)
+}
+
+func columnMask(col int) uint64 {
+	return ((1 << ROWS) - 1) << uint(col*columnHeight)
+}
+
+func topCell(col int) uint64 {
+	return cellBit(col, ROWS-1)
+}
+
+func bottomCell(col int) uint64 {
+	return cellBit(col, 0)
+}
+
+type position struct {
+	current uint64 // stones of the side to move
+	mask    uint64 // all stones
+	moves   int
+}
+
+// positionFromBoard converts a game board (row 0 at the top) into a
+// bitboard position with player to move.
+func positionFromBoard(board [][]int, player int) position {
+	var p position
+	for row := 0; row < ROWS; row++ {
+		for col := 0; col < COLS; col++ {
+			if board[row][col] == EMPTY {
+				continue
+			}
+			bit := cellBit(col, ROWS-1-row)
+			p.mask |= bit
+			if board[row][col] == player {
+				p.current |= bit
+			}
+			p.moves++
+		}
+	}
+	return p
+}
+
+func (p position) key() uint64 {
+	return p.current + p.mask
+}
+
+func (p position) canPlay(col int) bool {
+	return p.mask&topCell(col) == 0
+}
+
+func (p position) play(col int) position {
+	return position{
+		current: p.current ^ p.mask,
+		mask:    p.mask | (p.mask + bottomCell(col)),
+		moves:   p.moves + 1,
+	}
+}
+
+func (p position) isFull() bool {
+	return p.moves >= ROWS*COLS
+}
+
+func (p position) isWinningMove(col int) bool {
+	stones := p.current | ((p.mask + bottomCell(col)) & columnMask(col))
+	return hasFour(stones)
+}
+
+func hasFour(stones uint64) bool {
+	for _, shift := range []uint{columnHeight, columnHeight - 1, columnHeight + 1, 1} {
+		m := stones & (stones >> shift)
+		if m&(m>>(2*shift)) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// threats returns the empty cells that would complete four for stones.
+func threats(stones, mask uint64) uint64 {
+	// vertical
+	r := (stones << 1) & (stones << 2) & (stones << 3)
+
+	for _, shift := range []uint{columnHeight, columnHeight - 1, columnHeight + 1} {
+		p := (stones << shift) & (stones << (2 * shift))
+		r |= p & (stones << (3 * shift))
+		r |= p & (stones >> shift)
+		p = (stones >> shift) & (stones >> (2 * shift))
+		r |= p & (stones << shift)
+		r |= p & (stones >> (3 * shift))
+	}
+
+	return r & (boardMask ^ mask)
+}
+
+// evaluate scores a non-terminal position from the side to move's view.
+func (p position) evaluate(w *Weights) int {
+	opponent := p.current ^ p.mask
+	score := 0
+
+	for _, window := range windows {
+		own := bits.OnesCount64(window & p.current)
+		theirs := bits.OnesCount64(window & opponent)
+		if theirs == 0 {
+			score += w.windowScore(own)
+		} else if own == 0 {
+			score -= w.windowScore(theirs)
+		}
+	}
+
+	for col := 0; col < COLS; col++ {
+		column := columnMask(col)
+		score += w.Center[col] * (bits.OnesCount64(p.current&column) - bits.OnesCount64(opponent&column))
+	}
+
+	// The side to move is the first player when an even number of moves
+	// has been played.
+	first, second := p.current, opponent
+	sign := 1
+	if p.moves%2 == 1 {
+		first, second = opponent, p.current
+		sign = -1
+	}
+	odd, even := oddRowsMask(), evenRowsMask()
+	parity := w.Threats.Odd*bits.OnesCount64(threats(first, p.mask)&odd) -
+		w.Threats.Even*bits.OnesCount64(threats(second, p.mask)&even)
+
+	return score + sign*parity
+}
+
+// oddRowsMask selects rows 1, 3 and 5 counted from the bottom starting at 1.
+func oddRowsMask() uint64 {
+	return bottomMask * 0x15
+}
+
+func evenRowsMask() uint64 {
+	return bottomMask * 0x2a
+}
+
+const (
+	boundExact = iota
+	boundLower
+	boundUpper
+)
+
+type ttEntry struct {
+	key   uint64
+	score int32
+	depth int8
+	bound uint8
+	move  int8
+}
+
+// transpositionTable is shared by all workers of a parallel search without
+// locking. Each slot stores the entry's packed data and the key XORed with
+// that data, so a slot torn by concurrent writers fails the key check and
+// reads as a miss instead of returning a corrupt entry.
+type transpositionTable struct {
+	slots []ttSlot
+}
+
+type ttSlot struct {
+	check atomic.Uint64
+	data  atomic.Uint64
+}
+
+const ttValid = 1 << 56
+
+func newTranspositionTable(size int) *transpositionTable {
+	return &transpositionTable{slots: make([]ttSlot, size)}
+}
+
+func (t *transpositionTable) get(key uint64) (ttEntry, bool) {
+	slot := &t.slots[key%uint64(len(t.slots))]
+	data := slot.data.Load()
+	if data&ttValid == 0 || slot.check.Load()^data != key {
+		return ttEntry{}, false
+	}
+
+	return ttEntry{
+		key:   key,
+		score: int32(uint32(data)),
+		depth: int8(uint8(data >> 32)),
+		bound: uint8(data >> 40),
+		move:  int8(uint8(data >> 48)),
+	}, true
+}
+
+func (t *transpositionTable) put(entry ttEntry) {
+	data := uint64(uint32(entry.score)) |
+		uint64(uint8(entry.depth))<<32 |
+		uint64(entry.bound)<<40 |
+		uint64(uint8(entry.move))<<48 |
+		ttValid
+
+	slot := &t.slots[entry.key%uint64(len(t.slots))]
+	slot.data.Store(data)
+	slot.check.Store(entry.key ^ data)
+}
+
+// searchOptions describes how a search runs: the table it shares, the
+// weights it evaluates with and how many workers it may use. Background
+// searches give up instead of waiting for a free search thread.
+type searchOptions struct {
+	tt         *transpositionTable
+	weights    *Weights
+	workers    int
+	background bool
+}
+
+type searcher struct {
+	ctx     context.Context
+	tt      *transpositionTable
+	weights *Weights
+	id      int
+	nodes   int
+	aborted bool
+}
+
+// search runs an iterative deepening alpha-beta search up to maxDepth plies
+// and returns the best column with the depth that was fully searched. It
+// returns a depth of 0 if ctx ended before the first iteration finished.
+//
+// Up to workers goroutines search the same position (lazy SMP), sharing tt
+// so that helpers fill it with results the main worker can reuse. Only the
+// main worker's answer is returned. Every worker counts against the global
+// search thread limit. Background searches use a single worker, and are cut
+// short when a game's search needs their thread; they then return what
+// they had found.
+func search(ctx context.Context, options searchOptions, p position, maxDepth int) (int, int) {
+	for _, col := range moveOrder {
+		if p.canPlay(col) && p.isWinningMove(col) {
+			return col, maxDepth
+		}
+	}
+
+	workers := options.workers
+	if options.background {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+
+		release := searchThreads.tryAcquireBackground(cancel)
+		if release == nil {
+			return -1, 0
+		}
+		defer release()
+		workers = 1
+	} else {
+		if !searchThreads.acquire(ctx) {
+			return -1, 0
+		}
+		defer searchThreads.release()
+	}
+
+	helperCtx, cancelHelpers := context.WithCancel(ctx)
+	var helpers sync.WaitGroup
+	for id := 1; id < workers && searchThreads.tryAcquire(); id++ {
+		helpers.Add(1)
+		go func(id int) {
+			defer helpers.Done()
+			defer searchThreads.release()
+			helper := &searcher{ctx: helperCtx, tt: options.tt, weights: options.weights, id: id}
+			helper.iterate(p, maxDepth)
+		}(id)
+	}
+
+	main := &searcher{ctx: ctx, tt: options.tt, weights: options.weights}
+	bestCol, reached := main.iterate(p, maxDepth)
+
+	cancelHelpers()
+	helpers.Wait()
+
+	return bestCol, reached
+}
+
+func (s *searcher) iterate(p position, maxDepth int) (int, int) {
+	bestCol, reached := -1, 0
+
+	// Helpers start on alternating depths so they are not all working on
+	// the same iteration as the main worker.
+	for depth := 1 + s.id%2; depth <= maxDepth; depth++ {
+		col, score := s.root(p, depth)
+		if s.aborted || col < 0 {
+			break
+		}
+		bestCol, reached = col, depth
+		if score > winThreshold || score < -winThreshold {
+			break
+		}
+	}
+
+	return bestCol, reached
+}
+
+func (s *searcher) root(p position, depth int) (int, int) {
+	alpha, beta := -infinity, infinity
+	bestCol := -1
+
+	for _, col := range s.orderedMoves(p) {
+		score := -s.negamax(p.play(col), depth-1, -beta, -alpha, 1)
+		if s.aborted {
+			return -1, 0
+		}
+		if bestCol < 0 || score > alpha {
+			alpha = score
+			bestCol = col
+		}
+	}
+
+	s.tt.put(ttEntry{key: p.key(), score: int32(alpha), depth: int8(depth), bound: boundExact, move: int8(bestCol)})
+	return bestCol, alpha
+}
+
+func (s *searcher) negamax(p position, depth, alpha, beta, ply int) int {
+	s.nodes++
+	if s.nodes&4095 == 0 && s.ctx.Err() != nil {
+		s.aborted = true
+	}
+	if s.aborted {
+		return 0
+	}
+
+	for col := 0; col < COLS; col++ {
+		if p.canPlay(col) && p.isWinningMove(col) {
+			return winScore - ply
+		}
+	}
+	if p.moves >= ROWS*COLS-1 {
+		return 0
+	}
+	if depth == 0 {
+		return p.evaluate(s.weights)
+	}
+
+	key := p.key()
+	originalAlpha := alpha
+	if entry, ok := s.tt.get(key); ok && int(entry.depth) >= depth {
+		score := scoreFromTT(int(entry.score), ply)
+		switch entry.bound {
+		case boundExact:
+			return score
+		case boundLower:
+			if score > alpha {
+				alpha = score
+			}
+		case boundUpper:
+			if score < beta {
+				beta = score
+			}
+		}
+		if alpha >= beta {
+			return score
+		}
+	}
+
+	best, bestCol := -infinity, -1
+	for _, col := range s.orderedMoves(p) {
+		score := -s.negamax(p.play(col), depth-1, -beta, -alpha, ply+1)
+		if s.aborted {
+			return 0
+		}
+		if score > best {
+			best, bestCol = score, col
+		}
+		if score > alpha {
+			alpha = score
+		}
+		if alpha >= beta {
+			break
+		}
+	}
+
+	bound := uint8(boundExact)
+	if best <= originalAlpha {
+		bound = boundUpper
+	} else if best >= beta {
+		bound = boundLower
+	}
+	s.tt.put(ttEntry{key: key, score: int32(scoreToTT(best, ply)), depth: int8(depth), bound: bound, move: int8(bestCol)})
+
+	return best
+}
+
+// orderedMoves lists playable columns, trying the transposition table's
+// best move first and then working outwards from the centre.
+func (s *searcher) orderedMoves(p position) []int {
+	moves := make([]int, 0, COLS)
+	first := -1
+	if entry, ok := s.tt.get(p.key()); ok && entry.move >= 0 && p.canPlay(int(entry.move)) {
+		first = int(entry.move)
+		moves = append(moves, first)
+	}
+	for i := range moveOrder {
+		// Helpers rotate the order so workers explore different subtrees
+		col := moveOrder[(i+s.id)%len(moveOrder)]
+		if col != first && p.canPlay(col) {
+			moves = append(moves, col)
+		}
+	}
+	return moves
+}
+
+// Win scores depend on the distance from the root; the table stores them
+// relative to the node instead so entries stay valid at any ply.
+func scoreToTT(score, ply int) int {
+	if score > winThreshold {
+		return score + ply
+	}
+	if score < -winThreshold {
+		return score - ply
+	}
+	return score
+}
+
+func scoreFromTT(score, ply int) int {
+	if score > winThreshold {
+		return score - ply
+	}
+	if score < -winThreshold {
+		return score + ply
+	}
+	return score
+}
+
+func levelDepth(level int) int {
+	if level >= MaxLevel {
+		return 12
+	}
+	return 8
+}
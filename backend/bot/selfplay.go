@@ -0,0 +1,89 @@
+package bot
+
+import (
+	"context"
+	"math/rand"
+)
+
+// openingPlies is the number of random moves played at the start of each
+// self-play game so that matches between deterministic searchers do not
+// repeat the same game.
+const openingPlies = 4
+
+// SelfPlay plays games between two weight sets using fixed-depth search and
+// returns a's score as a fraction: 1 for a win, 0.5 for a draw. Each
+// opening is played twice with colours swapped, so games should be even.
+func SelfPlay(a, b *Weights, games, depth int, rng *rand.Rand) float64 {
+	score := 0.0
+	var opening []int
+
+	for game := 0; game < games; game++ {
+		if game%2 == 0 {
+			opening = randomOpening(rng)
+		}
+
+		// a moves first in even games
+		first, second := a, b
+		if game%2 == 1 {
+			first, second = b, a
+		}
+
+		switch playGame(first, second, opening, depth) {
+		case 0:
+			score += 0.5
+		case 1:
+			if game%2 == 0 {
+				score++
+			}
+		case 2:
+			if game%2 == 1 {
+				score++
+			}
+		}
+	}
+
+	return score / float64(games)
+}
+
+func randomOpening(rng *rand.Rand) []int {
+	var p position
+	opening := make([]int, 0, openingPlies)
+	for len(opening) < openingPlies {
+		col := rng.Intn(COLS)
+		if !p.canPlay(col) || p.isWinningMove(col) {
+			continue
+		}
+		p = p.play(col)
+		opening = append(opening, col)
+	}
+	return opening
+}
+
+// playGame returns 1 if first wins, 2 if second wins and 0 for a draw.
+func playGame(first, second *Weights, opening []int, depth int) int {
+	var p position
+	for _, col := range opening {
+		p = p.play(col)
+	}
+
+	tables := [2]*transpositionTable{
+		newTranspositionTable(sessionTableSize),
+		newTranspositionTable(sessionTableSize),
+	}
+	weights := [2]*Weights{first, second}
+
+	for !p.isFull() {
+		side := p.moves % 2
+		options := searchOptions{tt: tables[side], weights: weights[side], workers: 1}
+		col, _ := search(context.Background(), options, p, depth)
+		if col < 0 {
+			return 0
+		}
+		if p.isWinningMove(col) {
+			return side + 1
+		}
+		p = p.play(col)
+	}
+
+	return 0
+}
@@ -0,0 +1,181 @@
+package bot
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	sessionTableSize = 1 << 16
+	ponderRetryDelay = 50 * time.Millisecond
+)
+
+// Ponderer is implemented by strategies that keep per-game search state and
+// can think during the opponent's turn.
+type Ponderer interface {
+	Strategy
+	NewSession() *Session
+}
+
+// Session holds the search state for one game: a transposition table that
+// stays warm from move to move, and the answers found while pondering.
+type Session struct {
+	bot *Bot
+	tt  *transpositionTable
+
+	mutex    sync.Mutex
+	cancel   context.CancelFunc
+	done     chan struct{}
+	pondered map[uint64]ponderResult
+}
+
+type ponderResult struct {
+	column int
+	depth  int
+}
+
+func (b *Bot) NewSession() *Session {
+	return &Session{
+		bot:      b,
+		tt:       newTranspositionTable(sessionTableSize),
+		pondered: make(map[uint64]ponderResult),
+	}
+}
+
+// BestMove stops any pondering and returns the move for player. If the
+// opponent played a reply that was pondered deeply enough, the precomputed
+// answer is used without searching again.
+func (s *Session) BestMove(board [][]int, moves []int, player int, level int) int {
+	s.Stop()
+
+	if level < searchMinLevel {
+		return s.bot.GetBestMoveWithDifficulty(board, player, level)
+	}
+
+	p := positionFromBoard(board, player)
+	depth := levelDepth(level)
+
+	s.mutex.Lock()
+	result, found := s.pondered[p.key()]
+	s.pondered = make(map[uint64]ponderResult)
+	s.mutex.Unlock()
+
+	if found && result.depth >= depth {
+		return result.column
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), searchTimeLimit)
+	defer cancel()
+
+	column, _ := search(ctx, s.options(false), p, depth)
+	if column < 0 {
+		return s.bot.GetBestMoveWithDifficulty(board, player, level)
+	}
+	return column
+}
+
+func (s *Session) options(background bool) searchOptions {
+	return searchOptions{
+		tt:         s.tt,
+		weights:    s.bot.weights,
+		workers:    s.bot.workers,
+		background: background,
+	}
+}
+
+// Ponder starts searching, in the background, the bot's answers to the
+// opponent's most likely replies. opponent is the player about to move.
+func (s *Session) Ponder(board [][]int, opponent int, level int) {
+	if level < searchMinLevel {
+		return
+	}
+
+	s.Stop()
+
+	p := positionFromBoard(board, opponent)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	s.mutex.Lock()
+	s.cancel = cancel
+	s.done = done
+	s.mutex.Unlock()
+
+	go func() {
+		defer close(done)
+		s.ponder(ctx, p, levelDepth(level)+ponderExtraDepth)
+	}()
+}
+
+// Stop cancels pondering and waits for it to finish.
+func (s *Session) Stop() {
+	s.mutex.Lock()
+	cancel, done := s.cancel, s.done
+	s.cancel, s.done = nil, nil
+	s.mutex.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+func (s *Session) ponder(ctx context.Context, p position, maxDepth int) {
+	replies := s.likelyReplies(ctx, p)
+
+	// Deepen all replies together so the likeliest ones are never starved
+	// by a single expensive line.
+	for depth := 1; depth <= maxDepth; depth++ {
+		for _, col := range replies {
+			next := p.play(col)
+			column, reached := search(ctx, s.options(true), next, depth)
+			for column < 0 && ctx.Err() == nil && !next.isFull() {
+				// Every search thread is busy; wait for one to free up
+				select {
+				case <-ctx.Done():
+				case <-time.After(ponderRetryDelay):
+				}
+				column, reached = search(ctx, s.options(true), next, depth)
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			if column >= 0 {
+				s.mutex.Lock()
+				s.pondered[next.key()] = ponderResult{column: column, depth: reached}
+				s.mutex.Unlock()
+			}
+		}
+	}
+}
+
+// likelyReplies orders the opponent's legal, non-winning moves by how good
+// a shallow search thinks they are for the opponent.
+func (s *Session) likelyReplies(ctx context.Context, p position) []int {
+	type scored struct {
+		col   int
+		score int
+	}
+
+	searcher := &searcher{ctx: ctx, tt: s.tt, weights: s.bot.weights}
+	var candidates []scored
+	for _, col := range moveOrder {
+		if !p.canPlay(col) || p.isWinningMove(col) {
+			continue
+		}
+		score := -searcher.negamax(p.play(col), 3, -infinity, infinity, 1)
+		candidates = append(candidates, scored{col: col, score: score})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+
+	replies := make([]int, len(candidates))
+	for i, candidate := range candidates {
+		replies[i] = candidate.col
+	}
+	return replies
+}
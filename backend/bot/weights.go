@@ -0,0 +1,125 @@
+package bot
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// WeightsVersion is the weights file format understood by this build.
+const WeightsVersion = 1
+
+// Weights are the heuristic values used to score positions. The line
+// weights and centre table drive the move picker used at lower levels; the
+// alpha-beta search scores windows of four with the same line scores, the
+// centre table per stone, and adds the threat parity bonuses.
+type Weights struct {
+	Version     int           `json:"version"`
+	Name        string        `json:"name"`
+	Description string        `json:"description,omitempty"`
+	Lines       LineWeights   `json:"lines"`
+	Center      [COLS]int     `json:"center"`
+	Threats     ThreatWeights `json:"threats"`
+}
+
+type LineWeights struct {
+	Win   int        `json:"win"`
+	Three LineWeight `json:"three"`
+	Two   LineWeight `json:"two"`
+	One   LineWeight `json:"one"`
+}
+
+// LineWeight scores a line of stones. OpenEnds multiplies Score by the
+// number of open ends the line has (0, 1 or 2).
+type LineWeight struct {
+	Score    int        `json:"score"`
+	OpenEnds [3]float64 `json:"openEnds"`
+}
+
+// ThreatWeights reward threats on rows whose parity favours their owner:
+// odd rows (counted from the bottom, starting at 1) for the first player,
+// even rows for the second.
+type ThreatWeights struct {
+	Odd  int `json:"odd"`
+	Even int `json:"even"`
+}
+
+//go:embed weights/default.json
+var defaultWeightsJSON []byte
+
+// DefaultWeights returns the built-in weight set.
+func DefaultWeights() *Weights {
+	weights, err := ParseWeights(defaultWeightsJSON)
+	if err != nil {
+		panic(fmt.Sprintf("invalid built-in weights: %v", err))
+	}
+	return weights
+}
+
+// LoadWeightsFromEnv loads the weights file named by BOT_WEIGHTS. It returns
+// nil when no file is configured.
+func LoadWeightsFromEnv() (*Weights, error) {
+	path := os.Getenv("BOT_WEIGHTS")
+	if path == "" {
+		return nil, nil
+	}
+	return LoadWeights(path)
+}
+
+func LoadWeights(path string) (*Weights, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	weights, err := ParseWeights(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return weights, nil
+}
+
+func ParseWeights(data []byte) (*Weights, error) {
+	var weights Weights
+	if err := json.Unmarshal(data, &weights); err != nil {
+		return nil, err
+	}
+
+	if weights.Version != WeightsVersion {
+		return nil, fmt.Errorf("unsupported weights version %d (want %d)", weights.Version, WeightsVersion)
+	}
+	if weights.Name == "" {
+		return nil, fmt.Errorf("weights must have a name")
+	}
+	// A move can make a three in all four directions at once; that must
+	// still be worth less than winning.
+	if weights.Lines.Win <= weights.Lines.Three.Score*4 {
+		return nil, fmt.Errorf("win score must be greater than four threes")
+	}
+
+	return &weights, nil
+}
+
+func (w *Weights) Save(path string) error {
+	data, err := json.MarshalIndent(w, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func (w LineWeight) value(openEnds int) int {
+	return int(float64(w.Score) * w.OpenEnds[openEnds])
+}
+
+// windowScore scores a window of four holding stones of one side only.
+func (w *Weights) windowScore(stones int) int {
+	switch stones {
+	case 3:
+		return w.Lines.Three.Score
+	case 2:
+		return w.Lines.Two.Score
+	}
+	return 0
+}
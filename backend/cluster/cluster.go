@@ -0,0 +1,290 @@
+// Package cluster lets several game servers share the games. Every node is
+// told about every other node in CLUSTER_NODES; each game belongs to the
+// node its ID hashes to on a consistent hash ring, and nodes call each
+// other over a small JSON-over-HTTP RPC, served on an internal listener of
+// its own rather than alongside the public API.
+package cluster
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// RPCPath is where nodes serve each other's calls; the method name
+	// follows it.
+	RPCPath = "/internal/cluster/"
+
+	secretHeader = "X-Cluster-Secret"
+	callTimeout  = 5 * time.Second
+)
+
+var (
+	ErrUnknownMethod  = errors.New("unknown cluster method")
+	ErrSecretRequired = errors.New("CLUSTER_SECRET is required in cluster mode")
+)
+
+// Node is one game server. URL is where the other nodes reach its internal
+// listener.
+type Node struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+// Handler serves one RPC method. The reply is sent back as JSON.
+type Handler func(args json.RawMessage) (interface{}, error)
+
+// Cluster is this node's view of the cluster. Membership is fixed at
+// startup: changing it moves games between owners, so every node must be
+// restarted with the same CLUSTER_NODES.
+type Cluster struct {
+	self     Node
+	nodes    []Node
+	ring     *Ring
+	secret   string
+	listen   string
+	client   *http.Client
+	mutex    sync.RWMutex
+	handlers map[string]Handler
+}
+
+type response struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// FromEnv builds the cluster described by NODE_ID, CLUSTER_NODES (a list of
+// id=url pairs such as "a=http://10.0.0.1:9090,b=http://10.0.0.2:9090"),
+// CLUSTER_SECRET and CLUSTER_LISTEN, the address this node serves cluster
+// calls on (default: the port of its own URL). It returns nil when
+// CLUSTER_NODES is not set, which means the server runs on its own.
+func FromEnv() (*Cluster, error) {
+	value := os.Getenv("CLUSTER_NODES")
+	if value == "" {
+		return nil, nil
+	}
+
+	var nodes []Node
+	for _, entry := range strings.Split(value, ",") {
+		id, url, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found || id == "" || url == "" {
+			return nil, fmt.Errorf("invalid CLUSTER_NODES entry %q", entry)
+		}
+		nodes = append(nodes, Node{ID: id, URL: strings.TrimRight(url, "/")})
+	}
+
+	c, err := New(os.Getenv("NODE_ID"), nodes, os.Getenv("CLUSTER_SECRET"))
+	if err != nil {
+		return nil, err
+	}
+	if listen := os.Getenv("CLUSTER_LISTEN"); listen != "" {
+		c.listen = listen
+	}
+	return c, nil
+}
+
+// New returns the cluster of nodes as seen from the node with ID self.
+// Nodes must share a secret, as cluster calls change games and ban players
+// without the admin API's checks.
+func New(self string, nodes []Node, secret string) (*Cluster, error) {
+	if secret == "" {
+		return nil, ErrSecretRequired
+	}
+
+	c := &Cluster{
+		nodes:    nodes,
+		ring:     NewRing(nodes),
+		secret:   secret,
+		client:   &http.Client{Timeout: callTimeout},
+		handlers: make(map[string]Handler),
+	}
+
+	seen := make(map[string]bool)
+	for _, node := range nodes {
+		if seen[node.ID] {
+			return nil, fmt.Errorf("node %q is listed twice", node.ID)
+		}
+		seen[node.ID] = true
+		if node.ID == self {
+			c.self = node
+		}
+	}
+	if c.self.ID == "" {
+		return nil, fmt.Errorf("NODE_ID %q is not one of the cluster's nodes", self)
+	}
+
+	parsed, err := url.Parse(c.self.URL)
+	if err != nil || parsed.Port() == "" {
+		return nil, fmt.Errorf("node %q needs a URL with a port, not %q", self, c.self.URL)
+	}
+	c.listen = net.JoinHostPort("", parsed.Port())
+	return c, nil
+}
+
+// ListenAddr returns the address to serve cluster calls on. It must not be
+// reachable by the public.
+func (c *Cluster) ListenAddr() string {
+	return c.listen
+}
+
+// Self returns this node.
+func (c *Cluster) Self() Node {
+	return c.self
+}
+
+// Nodes returns every node in the cluster, this one included.
+func (c *Cluster) Nodes() []Node {
+	return c.nodes
+}
+
+// Owner returns the node responsible for key, such as a game ID.
+func (c *Cluster) Owner(key string) Node {
+	return c.ring.Owner(key)
+}
+
+// Owns reports whether this node is responsible for key.
+func (c *Cluster) Owns(key string) bool {
+	return c.ring.Owner(key).ID == c.self.ID
+}
+
+// Node returns the node with the given ID.
+func (c *Cluster) Node(id string) (Node, bool) {
+	for _, node := range c.nodes {
+		if node.ID == id {
+			return node, true
+		}
+	}
+	return Node{}, false
+}
+
+// Handle registers the handler for an RPC method.
+func (c *Cluster) Handle(method string, handler Handler) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.handlers[method] = handler
+}
+
+// Call runs method on node with args and decodes its reply into reply,
+// which may be nil. Calls to this node skip the network.
+func (c *Cluster) Call(node Node, method string, args, reply interface{}) error {
+	body, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	var result json.RawMessage
+	if node.ID == c.self.ID {
+		result, err = c.serve(method, body)
+	} else {
+		result, err = c.post(node, method, body)
+	}
+	if err != nil {
+		return err
+	}
+
+	if reply == nil || len(result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(result, reply)
+}
+
+// Broadcast runs method on every other node, logging the nodes that fail.
+func (c *Cluster) Broadcast(method string, args interface{}) {
+	for _, node := range c.nodes {
+		if node.ID == c.self.ID {
+			continue
+		}
+		if err := c.Call(node, method, args, nil); err != nil {
+			log.Printf("Cluster call %s to node %s failed: %v", method, node.ID, err)
+		}
+	}
+}
+
+func (c *Cluster) post(node Node, method string, body []byte) (json.RawMessage, error) {
+	request, err := http.NewRequest(http.MethodPost, node.URL+RPCPath+method, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(secretHeader, c.secret)
+
+	resp, err := c.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("node %s: %w", node.ID, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("node %s: %s: %s", node.ID, resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	var decoded response
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("node %s: %w", node.ID, err)
+	}
+	if decoded.Error != "" {
+		return nil, errors.New(decoded.Error)
+	}
+	return decoded.Result, nil
+}
+
+func (c *Cluster) serve(method string, args json.RawMessage) (json.RawMessage, error) {
+	c.mutex.RLock()
+	handler, exists := c.handlers[method]
+	c.mutex.RUnlock()
+	if !exists {
+		return nil, ErrUnknownMethod
+	}
+
+	result, err := handler(args)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(result)
+}
+
+// ServeHTTP answers RPC calls from the other nodes.
+func (c *Cluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, RPCPath) {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(secretHeader)), []byte(c.secret)) != 1 {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request", http.StatusBadRequest)
+		return
+	}
+
+	var decoded response
+	result, err := c.serve(strings.TrimPrefix(r.URL.Path, RPCPath), body)
+	if err != nil {
+		decoded.Error = err.Error()
+	} else {
+		decoded.Result = result
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(decoded)
+}
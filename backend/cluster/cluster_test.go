@@ -0,0 +1,107 @@
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestCluster(t *testing.T, self string, nodes []Node, secret string) *Cluster {
+	c, err := New(self, nodes, secret)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	c.Handle("echo", func(args json.RawMessage) (interface{}, error) {
+		var text string
+		err := json.Unmarshal(args, &text)
+		return text, err
+	})
+	return c
+}
+
+func TestNewRequiresSecret(t *testing.T) {
+	if _, err := New("a", testNodes("a"), ""); !errors.Is(err, ErrSecretRequired) {
+		t.Errorf("New without a secret: err = %v, want ErrSecretRequired", err)
+	}
+}
+
+func TestServeHTTPChecksSecret(t *testing.T) {
+	c := newTestCluster(t, "a", testNodes("a", "b"), "s3cret")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		secret string
+		want   int
+	}{
+		{"right secret", http.MethodPost, RPCPath + "echo", "s3cret", http.StatusOK},
+		{"no secret", http.MethodPost, RPCPath + "echo", "", http.StatusForbidden},
+		{"wrong secret", http.MethodPost, RPCPath + "echo", "guess", http.StatusForbidden},
+		{"secret prefix", http.MethodPost, RPCPath + "echo", "s3cre", http.StatusForbidden},
+		{"not a POST", http.MethodGet, RPCPath + "echo", "s3cret", http.StatusMethodNotAllowed},
+		{"outside the RPC path", http.MethodPost, "/api/echo", "s3cret", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, tt.path, strings.NewReader(`"hello"`))
+			if tt.secret != "" {
+				request.Header.Set(secretHeader, tt.secret)
+			}
+			recorder := httptest.NewRecorder()
+			c.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.want {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.want)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			var decoded response
+			if err := json.NewDecoder(recorder.Body).Decode(&decoded); err != nil || string(decoded.Result) != `"hello"` {
+				t.Errorf("response = %+v, %v; want result \"hello\"", decoded, err)
+			}
+		})
+	}
+}
+
+func TestCallAcrossNodes(t *testing.T) {
+	var remote http.Handler
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	nodes := []Node{{ID: "a", URL: "http://127.0.0.1:9090"}, {ID: "b", URL: server.URL}}
+
+	tests := []struct {
+		name         string
+		callerSecret string
+		wantErr      bool
+	}{
+		{"same secret", "s3cret", false},
+		{"different secret", "other", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remote = newTestCluster(t, "b", nodes, "s3cret")
+			caller := newTestCluster(t, "a", nodes, tt.callerSecret)
+
+			var reply string
+			err := caller.Call(nodes[1], "echo", "hello", &reply)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "403") {
+					t.Errorf("Call: err = %v, want 403 Forbidden", err)
+				}
+				return
+			}
+			if err != nil || reply != "hello" {
+				t.Errorf("Call = %q, %v; want \"hello\"", reply, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package cluster
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// replicas is how many points each node has on the ring. More points
+// spread keys more evenly between nodes.
+const replicas = 128
+
+// Ring is a consistent hash ring. A key belongs to the first node point at
+// or after the key's hash, so adding or removing a node only moves the keys
+// next to its points.
+type Ring struct {
+	points []uint32
+	owners map[uint32]Node
+}
+
+func NewRing(nodes []Node) *Ring {
+	r := &Ring{owners: make(map[uint32]Node)}
+	for _, node := range nodes {
+		for i := 0; i < replicas; i++ {
+			point := crc32.ChecksumIEEE([]byte(node.ID + "#" + strconv.Itoa(i)))
+			if _, taken := r.owners[point]; taken {
+				continue
+			}
+			r.owners[point] = node
+			r.points = append(r.points, point)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// Owner returns the node that key belongs to.
+func (r *Ring) Owner(key string) Node {
+	if len(r.points) == 0 {
+		return Node{}
+	}
+
+	hash := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
@@ -0,0 +1,99 @@
+package cluster
+
+import (
+	"strconv"
+	"testing"
+)
+
+func testNodes(ids ...string) []Node {
+	nodes := make([]Node, len(ids))
+	for i, id := range ids {
+		nodes[i] = Node{ID: id, URL: "http://" + id + ":9090"}
+	}
+	return nodes
+}
+
+func testKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = "game-" + strconv.Itoa(i)
+	}
+	return keys
+}
+
+func TestRingOwnerIsStable(t *testing.T) {
+	nodes := testNodes("a", "b", "c")
+	first, second := NewRing(nodes), NewRing([]Node{nodes[2], nodes[0], nodes[1]})
+	for _, key := range testKeys(1000) {
+		owner := first.Owner(key)
+		if again := first.Owner(key); again != owner {
+			t.Fatalf("Owner(%q) = %s, then %s", key, owner.ID, again.ID)
+		}
+		if other := second.Owner(key); other != owner {
+			t.Fatalf("Owner(%q) = %s, but %s with the nodes in another order", key, owner.ID, other.ID)
+		}
+	}
+}
+
+func TestRingMembershipChanges(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after []string
+	}{
+		{"node added", []string{"a", "b", "c"}, []string{"a", "b", "c", "d"}},
+		{"node removed", []string{"a", "b", "c", "d"}, []string{"a", "b", "c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, after := NewRing(testNodes(tt.before...)), NewRing(testNodes(tt.after...))
+			moved := 0
+			keys := testKeys(10000)
+			for _, key := range keys {
+				from, to := before.Owner(key), after.Owner(key)
+				if from == to {
+					continue
+				}
+				moved++
+				// Only keys of the node that left, or to the node that
+				// joined, may move
+				if from.ID != "d" && to.ID != "d" {
+					t.Fatalf("Owner(%q) moved from %s to %s", key, from.ID, to.ID)
+				}
+			}
+			if moved == 0 || moved > len(keys)/2 {
+				t.Errorf("%d of %d keys moved", moved, len(keys))
+			}
+		})
+	}
+}
+
+func TestRingDistribution(t *testing.T) {
+	for _, size := range []int{2, 3, 5} {
+		ids := make([]string, size)
+		for i := range ids {
+			ids[i] = "node" + strconv.Itoa(i+1)
+		}
+		ring := NewRing(testNodes(ids...))
+
+		keys := testKeys(20000)
+		counts := make(map[string]int)
+		for _, key := range keys {
+			counts[ring.Owner(key).ID]++
+		}
+
+		// Every node within half of an even share either way
+		share := len(keys) / size
+		for _, id := range ids {
+			if counts[id] < share/2 || counts[id] > share*3/2 {
+				t.Errorf("%d nodes: %s owns %d of %d keys, want about %d", size, id, counts[id], len(keys), share)
+			}
+		}
+	}
+}
+
+func TestRingEmpty(t *testing.T) {
+	if owner := NewRing(nil).Owner("game"); owner != (Node{}) {
+		t.Errorf("Owner on an empty ring = %+v, want none", owner)
+	}
+}
@@ -0,0 +1,281 @@
+// Command clustercheck plays games across a running cluster, with every
+// player and spectator connected to a different node, to check that nodes
+// share games and the matchmaking queue. Start the nodes first, e.g. with
+// scripts/start-cluster.sh.
+//
+//	go run ./cmd/clustercheck -nodes http://127.0.0.1:8081,http://127.0.0.1:8082,http://127.0.0.1:8083
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const timeout = 15 * time.Second
+
+type message struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+type gameState struct {
+	ID         string `json:"id"`
+	Status     string `json:"status"`
+	Winner     int    `json:"winner"`
+	Moves      []int  `json:"moves"`
+	InviteCode string `json:"inviteCode"`
+	Player1    *struct {
+		Username string `json:"username"`
+	} `json:"player1"`
+}
+
+type client struct {
+	name     string
+	node     string
+	conn     *websocket.Conn
+	messages chan message
+}
+
+func dial(node, name string) (*client, error) {
+	url := "ws" + strings.TrimPrefix(node, "http") + "/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", node, err)
+	}
+
+	c := &client{name: name, node: node, conn: conn, messages: make(chan message, 256)}
+	go func() {
+		defer close(c.messages)
+		for {
+			var msg message
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			c.messages <- msg
+		}
+	}()
+	return c, nil
+}
+
+func (c *client) send(messageType string, data map[string]interface{}) error {
+	return c.conn.WriteJSON(map[string]interface{}{"type": messageType, "data": data})
+}
+
+// expect reads messages until one of type messageType whose game passes
+// check arrives, and returns that game.
+func (c *client) expect(messageType string, check func(*gameState) bool) (*gameState, error) {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case msg, ok := <-c.messages:
+			if !ok {
+				return nil, fmt.Errorf("%s: connection to %s closed", c.name, c.node)
+			}
+			if msg.Type == "error" {
+				return nil, fmt.Errorf("%s: error from %s: %s", c.name, c.node, msg.Data)
+			}
+			if msg.Type != messageType {
+				continue
+			}
+
+			// The game is either the data or its "game" field
+			var wrapped struct {
+				Game *gameState `json:"game"`
+			}
+			state := &gameState{}
+			if json.Unmarshal(msg.Data, &wrapped) == nil && wrapped.Game != nil {
+				state = wrapped.Game
+			} else if err := json.Unmarshal(msg.Data, state); err != nil {
+				return nil, err
+			}
+			if check == nil || check(state) {
+				return state, nil
+			}
+		case <-deadline:
+			return nil, fmt.Errorf("%s: no %s from %s within %v", c.name, messageType, c.node, timeout)
+		}
+	}
+}
+
+func playing(state *gameState) bool {
+	return state.Status == "playing"
+}
+
+func main() {
+	nodesFlag := flag.String("nodes", "http://127.0.0.1:8081,http://127.0.0.1:8082,http://127.0.0.1:8083", "comma-separated node URLs")
+	flag.Parse()
+
+	nodes := strings.Split(*nodesFlag, ",")
+	if len(nodes) < 2 {
+		log.Fatal("Need at least two nodes")
+	}
+	node := func(i int) string { return nodes[i%len(nodes)] }
+
+	suffix := fmt.Sprintf("%d", time.Now().UnixNano()%100000)
+	checks := []struct {
+		name string
+		run  func() error
+	}{
+		{"matchmaking, moves and spectating across nodes", func() error {
+			return checkMatch(node(0), node(1), node(2), suffix)
+		}},
+		{"private game and reconnect across nodes", func() error {
+			return checkPrivate(node(1), node(2), node(0), suffix)
+		}},
+	}
+
+	failed := false
+	for _, check := range checks {
+		if err := check.run(); err != nil {
+			log.Printf("FAIL %s: %v", check.name, err)
+			failed = true
+			continue
+		}
+		log.Printf("PASS %s", check.name)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// checkMatch queues two players on different nodes, has a third node's
+// client watch their game, and plays it to a win.
+func checkMatch(nodeA, nodeB, nodeC, suffix string) error {
+	a, err := dial(nodeA, "alice"+suffix)
+	if err != nil {
+		return err
+	}
+	defer a.conn.Close()
+	b, err := dial(nodeB, "bob"+suffix)
+	if err != nil {
+		return err
+	}
+	defer b.conn.Close()
+
+	// Casual and human-only, so neither a bot nor the players' ratings
+	// get in the way
+	for _, c := range []*client{a, b} {
+		if err := c.send("join_game", map[string]interface{}{"username": c.name, "rated": false, "humanOnly": true}); err != nil {
+			return err
+		}
+	}
+
+	gameA, err := a.expect("game_started", playing)
+	if err != nil {
+		return err
+	}
+	gameB, err := b.expect("game_started", playing)
+	if err != nil {
+		return err
+	}
+	if gameA.ID != gameB.ID {
+		return fmt.Errorf("players were started in different games: %s and %s", gameA.ID, gameB.ID)
+	}
+
+	watcher, err := dial(nodeC, "carol"+suffix)
+	if err != nil {
+		return err
+	}
+	defer watcher.conn.Close()
+	if err := watcher.send("spectate", map[string]interface{}{"gameId": gameA.ID}); err != nil {
+		return err
+	}
+	if _, err := watcher.expect("spectating", nil); err != nil {
+		return err
+	}
+
+	first, second := a, b
+	if gameA.Player1.Username != a.name {
+		first, second = b, a
+	}
+	return playToWin(gameA.ID, first, second, watcher)
+}
+
+// playToWin has first stack four discs in column 0 while second plays
+// column 1, and checks everyone sees every move.
+func playToWin(gameID string, first, second *client, watchers ...*client) error {
+	columns := []int{0, 1, 0, 1, 0, 1, 0}
+	for i, column := range columns {
+		mover := first
+		if i%2 == 1 {
+			mover = second
+		}
+		if err := mover.send("make_move", map[string]interface{}{"column": column}); err != nil {
+			return err
+		}
+
+		ply := i + 1
+		for _, c := range append([]*client{first, second}, watchers...) {
+			state, err := c.expect("move_made", func(state *gameState) bool {
+				return state.ID == gameID && len(state.Moves) == ply
+			})
+			if err != nil {
+				return err
+			}
+			if ply == len(columns) && (state.Status != "finished" || state.Winner != 1) {
+				return fmt.Errorf("%s saw the game end %s with winner %d", c.name, state.Status, state.Winner)
+			}
+		}
+	}
+	return nil
+}
+
+// checkPrivate creates a private game on one node, joins it by invite code
+// from another and has its host reconnect through a third.
+func checkPrivate(nodeA, nodeB, nodeC, suffix string) error {
+	host, err := dial(nodeA, "dave"+suffix)
+	if err != nil {
+		return err
+	}
+	defer host.conn.Close()
+	guest, err := dial(nodeB, "erin"+suffix)
+	if err != nil {
+		return err
+	}
+	defer guest.conn.Close()
+
+	if err := host.send("create_private_game", map[string]interface{}{"username": host.name, "rated": false}); err != nil {
+		return err
+	}
+	created, err := host.expect("game_joined", nil)
+	if err != nil {
+		return err
+	}
+	if created.InviteCode == "" {
+		return errors.New("private game has no invite code")
+	}
+
+	if err := guest.send("join_game", map[string]interface{}{"username": guest.name, "inviteCode": created.InviteCode}); err != nil {
+		return err
+	}
+	if _, err := guest.expect("game_started", playing); err != nil {
+		return err
+	}
+	if _, err := host.expect("game_started", playing); err != nil {
+		return err
+	}
+
+	// The host drops and comes back through another node
+	host.conn.Close()
+	back, err := dial(nodeC, host.name)
+	if err != nil {
+		return err
+	}
+	defer back.conn.Close()
+	if err := back.send("reconnect", map[string]interface{}{"gameId": created.ID, "username": host.name}); err != nil {
+		return err
+	}
+	if _, err := back.expect("game_reconnected", playing); err != nil {
+		return err
+	}
+
+	return playToWin(created.ID, back, guest)
+}
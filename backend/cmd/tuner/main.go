@@ -0,0 +1,124 @@
+// Command tuner improves bot evaluation weights by self-play. Starting from
+// a weight set, it repeatedly mutates a few values, plays the candidate
+// against a fixed baseline and keeps the candidate when it scores better
+// than the current best. Improvements are written to the output file as
+// they are found, so the run can be stopped at any time.
+//
+// Only the values used by the alpha-beta search are tuned: window scores
+// for twos and threes, the centre table and the threat parity bonuses.
+//
+//	go run ./cmd/tuner -games 40 -depth 6 -iterations 100 -out tuned.json
+package main
+
+import (
+	"connect4-backend/bot"
+	"flag"
+	"log"
+	"math/rand"
+	"time"
+)
+
+func main() {
+	baselinePath := flag.String("baseline", "", "weights file to play against (default: built-in weights)")
+	startPath := flag.String("start", "", "weights file to start tuning from (default: the baseline)")
+	outPath := flag.String("out", "weights-tuned.json", "where to write the best weights found")
+	name := flag.String("name", "tuned", "name for the tuned weight set")
+	iterations := flag.Int("iterations", 50, "number of candidates to try")
+	games := flag.Int("games", 20, "games per candidate match (rounded up to even)")
+	depth := flag.Int("depth", 6, "search depth used for self-play")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "random seed")
+	flag.Parse()
+
+	baseline := bot.DefaultWeights()
+	if *baselinePath != "" {
+		weights, err := bot.LoadWeights(*baselinePath)
+		if err != nil {
+			log.Fatalf("Failed to load baseline: %v", err)
+		}
+		baseline = weights
+	}
+
+	current := copyWeights(baseline)
+	if *startPath != "" {
+		weights, err := bot.LoadWeights(*startPath)
+		if err != nil {
+			log.Fatalf("Failed to load start weights: %v", err)
+		}
+		current = weights
+	}
+	current.Name = *name
+
+	if *games%2 == 1 {
+		*games++
+	}
+
+	rng := rand.New(rand.NewSource(*seed))
+	bestScore := bot.SelfPlay(current, baseline, *games, *depth, rng)
+	log.Printf("Starting score against %q: %.3f", baseline.Name, bestScore)
+
+	for i := 1; i <= *iterations; i++ {
+		candidate := mutate(current, rng)
+		score := bot.SelfPlay(candidate, baseline, *games, *depth, rng)
+		log.Printf("Iteration %d: %.3f (best %.3f)", i, score, bestScore)
+
+		if score <= bestScore {
+			continue
+		}
+
+		current, bestScore = candidate, score
+		if err := current.Save(*outPath); err != nil {
+			log.Fatalf("Failed to save weights: %v", err)
+		}
+		log.Printf("New best weights saved to %s", *outPath)
+	}
+
+	log.Printf("Done. Best score against %q: %.3f", baseline.Name, bestScore)
+}
+
+func copyWeights(w *bot.Weights) *bot.Weights {
+	c := *w
+	return &c
+}
+
+// mutate returns a copy of w with one to three tunable values scaled by up
+// to 25% either way. The centre table stays symmetric.
+func mutate(w *bot.Weights, rng *rand.Rand) *bot.Weights {
+	c := copyWeights(w)
+
+	changes := 1 + rng.Intn(3)
+	for i := 0; i < changes; i++ {
+		switch rng.Intn(5) {
+		case 0:
+			c.Lines.Three.Score = scale(c.Lines.Three.Score, rng)
+			if limit := (c.Lines.Win - 1) / 4; c.Lines.Three.Score > limit {
+				c.Lines.Three.Score = limit
+			}
+		case 1:
+			c.Lines.Two.Score = scale(c.Lines.Two.Score, rng)
+		case 2:
+			col := rng.Intn(bot.COLS/2 + 1)
+			c.Center[col] = scale(c.Center[col], rng)
+			c.Center[bot.COLS-1-col] = c.Center[col]
+		case 3:
+			c.Threats.Odd = scale(c.Threats.Odd, rng)
+		case 4:
+			c.Threats.Even = scale(c.Threats.Even, rng)
+		}
+	}
+
+	return c
+}
+
+func scale(value int, rng *rand.Rand) int {
+	factor := 0.75 + rng.Float64()*0.5
+	scaled := int(float64(value)*factor + 0.5)
+
+	// Let zero values move too
+	if scaled == value {
+		scaled += rng.Intn(3) - 1
+	}
+	if scaled < 0 {
+		scaled = 0
+	}
+	return scaled
+}
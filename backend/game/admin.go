@@ -0,0 +1,379 @@
+package game
+
+import (
+	"connect4-backend/store"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// What the admin API does to games and players. In cluster mode a game is
+// changed on the node hosting it, and bans are kept on every node.
+
+// adminEndReason is the EndReason of games an admin finished or aborted.
+const adminEndReason = "admin"
+
+// remoteErrors are the errors admin calls to other nodes can return. They
+// cross the cluster RPC as text, and are matched back up here.
+var remoteErrors = []error{ErrGameNotFound, ErrGameNotActive, ErrPlayerNotFound}
+
+type adminGameArgs struct {
+	GameID string `json:"gameId"`
+	Winner string `json:"winner,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// Admit cleans the username of a player taking a seat, and turns banned
+// players away.
+func (m *Manager) Admit(username string) (string, error) {
+	username, err := cleanUsername(username)
+	if err != nil {
+		return "", err
+	}
+	if m.IsBanned(username) {
+		return "", ErrBanned
+	}
+	return username, nil
+}
+
+// ActiveGames returns every game that is waiting for a player or being
+// played, on any node, oldest first.
+func (m *Manager) ActiveGames() []*Game {
+	if m.cluster == nil {
+		return m.localActiveGames()
+	}
+
+	var games []*Game
+	for _, node := range m.cluster.Nodes() {
+		var hosted []*Game
+		if err := m.cluster.Call(node, "games.active", nil, &hosted); err != nil {
+			log.Printf("Failed to list games on node %s: %v", node.ID, err)
+			continue
+		}
+		games = append(games, hosted...)
+	}
+	sortByAge(games)
+	return games
+}
+
+func (m *Manager) localActiveGames() []*Game {
+	var games []*Game
+	for _, hosted := range m.hostedGames() {
+		hosted.mutex.Lock()
+		if hosted.game.Status == "waiting" || hosted.game.Status == "playing" {
+			games = append(games, hosted.game.snapshot())
+		}
+		hosted.mutex.Unlock()
+	}
+	sortByAge(games)
+	return games
+}
+
+func sortByAge(games []*Game) {
+	sort.Slice(games, func(i, j int) bool {
+		if !games[i].CreatedAt.Equal(games[j].CreatedAt) {
+			return games[i].CreatedAt.Before(games[j].CreatedAt)
+		}
+		return games[i].ID < games[j].ID
+	})
+}
+
+// FindGame returns a hosted game, on whichever node hosts it.
+func (m *Manager) FindGame(gameID string) (*Game, error) {
+	if m.cluster != nil && !m.cluster.Owns(gameID) {
+		return m.callGameNode("game.find", adminGameArgs{GameID: gameID})
+	}
+
+	game, exists := m.GetGame(gameID)
+	if !exists {
+		return nil, ErrGameNotFound
+	}
+	return game, nil
+}
+
+// EndGame ends a game being played as a win for the player named winner,
+// or as a draw when winner is "draw". Its players are told reason.
+func (m *Manager) EndGame(gameID, winner, reason string) (*Game, error) {
+	if m.cluster != nil && !m.cluster.Owns(gameID) {
+		return m.callGameNode("game.end", adminGameArgs{GameID: gameID, Winner: winner, Reason: reason})
+	}
+
+	hosted := m.lookup(gameID)
+	if hosted == nil {
+		return nil, ErrGameNotFound
+	}
+
+	hosted.mutex.Lock()
+	defer hosted.mutex.Unlock()
+
+	game := hosted.game
+	if game.Status != "playing" {
+		return nil, ErrGameNotActive
+	}
+
+	switch {
+	case winner == "draw":
+		game.Winner = 0
+	case winner == game.Player1.Username:
+		game.Winner = PLAYER1
+	case winner == game.Player2.Username:
+		game.Winner = PLAYER2
+	default:
+		return nil, ErrPlayerNotFound
+	}
+
+	game.Status = "finished"
+	game.EndReason = adminEndReason
+	game.LastMove = time.Now()
+	m.finishGame(hosted, reason)
+	m.checkpoint(game)
+
+	log.Printf("Game %s ended by an admin, winner %d: %s", gameID, game.Winner, reason)
+	return game.snapshot(), nil
+}
+
+// AbortGame ends a game that is waiting or being played without a result.
+// Its players are told reason.
+func (m *Manager) AbortGame(gameID, reason string) (*Game, error) {
+	if m.cluster != nil && !m.cluster.Owns(gameID) {
+		return m.callGameNode("game.abort", adminGameArgs{GameID: gameID, Reason: reason})
+	}
+
+	hosted := m.lookup(gameID)
+	if hosted == nil {
+		return nil, ErrGameNotFound
+	}
+
+	hosted.mutex.Lock()
+	game := hosted.game
+	if game.Status != "waiting" && game.Status != "playing" {
+		hosted.mutex.Unlock()
+		return nil, ErrGameNotActive
+	}
+
+	waiting := game.Status == "waiting"
+	game.Status = "aborted"
+	game.EndReason = adminEndReason
+	game.LastMove = time.Now()
+	hosted.closeBotSession()
+	m.releaseInviteCode(game)
+	hosted.cancelDisconnectTimers()
+	hosted.stopClock()
+
+	snapshot := game.snapshot()
+	m.events.Publish(GameAborted{Game: snapshot, Reason: reason})
+	m.checkpoint(game)
+	hosted.mutex.Unlock()
+
+	// Its host is no longer looking for an opponent
+	if waiting {
+		m.dropQueueEntry(gameID)
+	}
+
+	log.Printf("Game %s aborted by an admin: %s", gameID, reason)
+	return snapshot, nil
+}
+
+// callGameNode runs an admin call on the node hosting a game.
+func (m *Manager) callGameNode(method string, args adminGameArgs) (*Game, error) {
+	var reply gameReply
+	if err := m.cluster.Call(m.cluster.Owner(args.GameID), method, args, &reply); err != nil {
+		for _, known := range remoteErrors {
+			if err.Error() == known.Error() {
+				return nil, known
+			}
+		}
+		return nil, err
+	}
+	return reply.Game, nil
+}
+
+// loadBans reads the bans from the store.
+func (m *Manager) loadBans() {
+	bans, err := m.store.Bans()
+	if err != nil {
+		log.Printf("Failed to load bans: %v", err)
+		return
+	}
+
+	m.banMutex.Lock()
+	for _, ban := range bans {
+		m.bans[ban.Username] = ban
+	}
+	m.banMutex.Unlock()
+
+	if len(bans) > 0 {
+		log.Printf("Loaded %d bans", len(bans))
+	}
+}
+
+// IsBanned reports whether a player is banned.
+func (m *Manager) IsBanned(username string) bool {
+	m.banMutex.RLock()
+	defer m.banMutex.RUnlock()
+	_, banned := m.bans[strings.TrimSpace(username)]
+	return banned
+}
+
+// Bans returns every ban, by username.
+func (m *Manager) Bans() []store.Ban {
+	m.banMutex.RLock()
+	bans := make([]store.Ban, 0, len(m.bans))
+	for _, ban := range m.bans {
+		bans = append(bans, ban)
+	}
+	m.banMutex.RUnlock()
+
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].Username < bans[j].Username
+	})
+	return bans
+}
+
+// Ban keeps a player out of new games and takes them out of the
+// matchmaking queue. Games they are playing go on; disconnecting them
+// forfeits those once the grace period is up.
+func (m *Manager) Ban(username, reason, admin string) (store.Ban, error) {
+	username, err := cleanUsername(username)
+	if err != nil {
+		return store.Ban{}, err
+	}
+
+	ban := store.Ban{
+		Username:  username,
+		Reason:    reason,
+		Admin:     admin,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := m.store.SaveBan(ban); err != nil {
+		return store.Ban{}, err
+	}
+
+	m.setBan(ban)
+	if m.cluster != nil {
+		m.cluster.Broadcast("players.ban", ban)
+	}
+
+	if err := m.LeaveQueue(username); err == nil {
+		log.Printf("Took banned player %s out of the queue", username)
+	}
+	log.Printf("Player %s banned by %s: %s", username, admin, reason)
+	return ban, nil
+}
+
+// Unban lets a banned player play again.
+func (m *Manager) Unban(username string) error {
+	username, err := cleanUsername(username)
+	if err != nil {
+		return err
+	}
+	if !m.IsBanned(username) {
+		return ErrPlayerNotFound
+	}
+
+	if err := m.store.DeleteBan(username); err != nil {
+		return err
+	}
+
+	m.clearBan(username)
+	if m.cluster != nil {
+		m.cluster.Broadcast("players.unban", username)
+	}
+
+	log.Printf("Player %s unbanned", username)
+	return nil
+}
+
+func (m *Manager) setBan(ban store.Ban) {
+	m.banMutex.Lock()
+	m.bans[ban.Username] = ban
+	m.banMutex.Unlock()
+}
+
+func (m *Manager) clearBan(username string) {
+	m.banMutex.Lock()
+	delete(m.bans, username)
+	m.banMutex.Unlock()
+}
+
+// serveAdmin registers the admin calls other nodes make on this one.
+func (m *Manager) serveAdmin() {
+	m.cluster.Handle("games.active", m.handleGamesActive)
+	m.cluster.Handle("game.find", m.handleGameFind)
+	m.cluster.Handle("game.end", m.handleGameEnd)
+	m.cluster.Handle("game.abort", m.handleGameAbort)
+	m.cluster.Handle("players.ban", m.handlePlayersBan)
+	m.cluster.Handle("players.unban", m.handlePlayersUnban)
+}
+
+func (m *Manager) handleGamesActive(body json.RawMessage) (interface{}, error) {
+	return m.localActiveGames(), nil
+}
+
+func (m *Manager) handleGameFind(body json.RawMessage) (interface{}, error) {
+	var args adminGameArgs
+	if err := json.Unmarshal(body, &args); err != nil {
+		return nil, err
+	}
+
+	game, exists := m.GetGame(args.GameID)
+	if !exists {
+		return nil, ErrGameNotFound
+	}
+	return gameReply{Game: game}, nil
+}
+
+func (m *Manager) handleGameEnd(body json.RawMessage) (interface{}, error) {
+	var args adminGameArgs
+	if err := json.Unmarshal(body, &args); err != nil {
+		return nil, err
+	}
+	if !m.cluster.Owns(args.GameID) {
+		return nil, errors.New("game " + args.GameID + " is not hosted here")
+	}
+
+	game, err := m.EndGame(args.GameID, args.Winner, args.Reason)
+	if err != nil {
+		return nil, err
+	}
+	return gameReply{Game: game}, nil
+}
+
+func (m *Manager) handleGameAbort(body json.RawMessage) (interface{}, error) {
+	var args adminGameArgs
+	if err := json.Unmarshal(body, &args); err != nil {
+		return nil, err
+	}
+	if !m.cluster.Owns(args.GameID) {
+		return nil, errors.New("game " + args.GameID + " is not hosted here")
+	}
+
+	game, err := m.AbortGame(args.GameID, args.Reason)
+	if err != nil {
+		return nil, err
+	}
+	return gameReply{Game: game}, nil
+}
+
+func (m *Manager) handlePlayersBan(body json.RawMessage) (interface{}, error) {
+	var ban store.Ban
+	if err := json.Unmarshal(body, &ban); err != nil {
+		return nil, err
+	}
+
+	m.setBan(ban)
+	return nil, nil
+}
+
+func (m *Manager) handlePlayersUnban(body json.RawMessage) (interface{}, error) {
+	var username string
+	if err := json.Unmarshal(body, &username); err != nil {
+		return nil, err
+	}
+
+	m.clearBan(username)
+	return nil, nil
+}
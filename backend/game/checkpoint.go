@@ -0,0 +1,133 @@
+package game
+
+import (
+	"connect4-backend/store"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// Every game in progress is checkpointed to the store, so that a restart
+// does not end them. Checkpoints are written in the background so a slow
+// store never holds up a game; only each game's latest state waits.
+
+// checkpointer writes queued checkpoints one at a time.
+type checkpointer struct {
+	store   store.Store
+	mutex   sync.Mutex
+	pending map[string]*Game // Latest unwritten state, keyed by game ID
+	wake    chan struct{}
+}
+
+func newCheckpointer(st store.Store) *checkpointer {
+	c := &checkpointer{
+		store:   st,
+		pending: make(map[string]*Game),
+		wake:    make(chan struct{}, 1),
+	}
+	go c.run()
+	return c
+}
+
+// checkpoint queues a save of a game in progress, or the removal of the
+// checkpoint of one that has ended. Callers must hold the game's mutex.
+func (m *Manager) checkpoint(game *Game) {
+	m.checkpoints.queue(game.snapshot())
+}
+
+func (c *checkpointer) queue(game *Game) {
+	c.mutex.Lock()
+	c.pending[game.ID] = game
+	c.mutex.Unlock()
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+		// Already woken; the writer will pick this up too
+	}
+}
+
+func (c *checkpointer) run() {
+	for range c.wake {
+		c.mutex.Lock()
+		pending := c.pending
+		c.pending = make(map[string]*Game)
+		c.mutex.Unlock()
+
+		for _, game := range pending {
+			c.write(game)
+		}
+	}
+}
+
+func (c *checkpointer) write(game *Game) {
+	var err error
+	if game.Status == "playing" {
+		var state []byte
+		state, err = json.Marshal(game)
+		if err == nil {
+			err = c.store.SaveActiveGame(game.ID, state)
+		}
+	} else {
+		err = c.store.DeleteActiveGame(game.ID)
+	}
+	if err != nil {
+		log.Printf("Failed to checkpoint game %s: %v", game.ID, err)
+	}
+}
+
+// restoreGames loads the games that were in progress when the server last
+// stopped. Their players get the usual grace period to reconnect, the time
+// the server was down is not taken off the clock, and the bot plays on in
+// games where it was to move.
+func (m *Manager) restoreGames() {
+	states, err := m.store.ActiveGames()
+	if err != nil {
+		log.Printf("Failed to load game checkpoints: %v", err)
+		return
+	}
+
+	absent := make(map[string][]string)
+	for _, state := range states {
+		game := &Game{}
+		if err := json.Unmarshal(state, game); err != nil {
+			log.Printf("Skipping unreadable game checkpoint: %v", err)
+			continue
+		}
+		if game.Status != "playing" {
+			m.store.DeleteActiveGame(game.ID)
+			continue
+		}
+		if m.cluster != nil && !m.cluster.Owns(game.ID) {
+			// Another node's game
+			continue
+		}
+		game.LastMove = time.Now()
+		hosted := m.host(game)
+		hosted.mutex.Lock()
+		m.runClock(hosted)
+		botToMove := game.IsBot && game.CurrentTurn == PLAYER2
+		hosted.mutex.Unlock()
+		absent[game.ID] = seatedPlayers(game)
+
+		// The server stopped while the bot was thinking
+		if botToMove {
+			go func(gameID string) {
+				if _, _, err := m.MakeBotMove(gameID); err != nil {
+					log.Printf("Bot move error in restored game %s: %v", gameID, err)
+				}
+			}(game.ID)
+		}
+	}
+
+	for gameID, usernames := range absent {
+		for _, username := range usernames {
+			m.SeatVacated(gameID, username)
+		}
+	}
+
+	if len(absent) > 0 {
+		log.Printf("Restored %d games in progress", len(absent))
+	}
+}
@@ -0,0 +1,80 @@
+package game_test
+
+import (
+	"connect4-backend/game"
+	"connect4-backend/store"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// waitFor polls until done reports true, failing t after a few seconds.
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// checkpointedMoves returns how many moves the store's checkpoint of a game
+// has, or -1 without one.
+func checkpointedMoves(st store.Store, gameID string) int {
+	states, _ := st.ActiveGames()
+	for _, state := range states {
+		var g game.Game
+		if json.Unmarshal(state, &g) == nil && g.ID == gameID {
+			return len(g.Moves)
+		}
+	}
+	return -1
+}
+
+func TestRestoreGames(t *testing.T) {
+	tests := []struct {
+		name       string
+		humanMoves []int
+		wantMoves  int // Once restored, after any bot move
+	}{
+		{"player to move", nil, 0},
+		{"bot to move", []int{3}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := store.NewMemory()
+			before := game.NewManager(st, nil, nil)
+			started, _, err := before.PlayBot("alice", game.QueuePreferences{Rated: true})
+			if err != nil {
+				t.Fatalf("PlayBot: %v", err)
+			}
+			for _, column := range tt.humanMoves {
+				if _, _, err := before.MakeMove(started.ID, column, "alice"); err != nil {
+					t.Fatalf("MakeMove: %v", err)
+				}
+			}
+			waitFor(t, "the checkpoint", func() bool {
+				return checkpointedMoves(st, started.ID) == len(tt.humanMoves)
+			})
+
+			// A new manager on the same store stands in for a restart
+			after := game.NewManager(st, nil, nil)
+			var restored *game.Game
+			waitFor(t, "the restored game", func() bool {
+				restored, _ = after.GetGame(started.ID)
+				return restored != nil && len(restored.Moves) == tt.wantMoves
+			})
+
+			if restored.Status != "playing" || !restored.IsBot || restored.Player1.Username != "alice" {
+				t.Errorf("restored game = status %q, bot %v, player %q; want a playing bot game of alice's",
+					restored.Status, restored.IsBot, restored.Player1.Username)
+			}
+			if restored.CurrentTurn != game.PLAYER1 {
+				t.Errorf("restored game has player %d to move, want alice", restored.CurrentTurn)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package game
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Games with a time control have a clock. A player's time runs down while
+// it is their turn, from the first move of the game on, and the increment
+// is added after each of their moves. A player whose time runs out loses
+// the game on time.
+
+// Clock is the time each player had left, in milliseconds, when the last
+// move was made. In arena games a player who berserks halves their time.
+type Clock struct {
+	Player1   int64 `json:"player1"`
+	Player2   int64 `json:"player2"`
+	Increment int64 `json:"increment"`
+	Berserk1  bool  `json:"berserk1,omitempty"`
+	Berserk2  bool  `json:"berserk2,omitempty"`
+}
+
+// newClock returns the clock a game starts with, or nil for an unlimited
+// time control.
+func newClock(timeControl string) *Clock {
+	var minutes, increment int64
+	if _, err := fmt.Sscanf(timeControl, "%d+%d", &minutes, &increment); err != nil {
+		return nil
+	}
+	initial := minutes * int64(time.Minute/time.Millisecond)
+	return &Clock{
+		Player1:   initial,
+		Player2:   initial,
+		Increment: increment * int64(time.Second/time.Millisecond),
+	}
+}
+
+// left returns where a player's time is kept.
+func (c *Clock) left(player int) *int64 {
+	if player == PLAYER2 {
+		return &c.Player2
+	}
+	return &c.Player1
+}
+
+// berserked reports whether a player berserked.
+func (c *Clock) berserked(player int) bool {
+	if player == PLAYER2 {
+		return c.Berserk2
+	}
+	return c.Berserk1
+}
+
+// clockRunning reports whether the player to move is using up their time.
+func (g *Game) clockRunning() bool {
+	return g.Clock != nil && g.Status == "playing" && len(g.Moves) > 0
+}
+
+// TimeLeft returns how much time a player has left at now, or -1 for a
+// game without a clock.
+func (g *Game) TimeLeft(player int, now time.Time) time.Duration {
+	if g.Clock == nil {
+		return -1
+	}
+
+	left := time.Duration(*g.Clock.left(player)) * time.Millisecond
+	if g.clockRunning() && g.CurrentTurn == player {
+		left -= now.Sub(g.LastMove)
+	}
+	if left < 0 {
+		left = 0
+	}
+	return left
+}
+
+// punchClock charges player for the move they made at now, which has
+// already been added to Moves, and gives them the increment.
+func (g *Game) punchClock(player int, now time.Time) {
+	if g.Clock == nil {
+		return
+	}
+
+	left := g.Clock.left(player)
+	if len(g.Moves) > 1 {
+		*left -= now.Sub(g.LastMove).Milliseconds()
+	}
+	*left += g.Clock.Increment
+}
+
+// runClock sets the timer that ends the game once the player to move runs
+// out of time, replacing any earlier one. Callers must hold mutex.
+func (m *Manager) runClock(hosted *hostedGame) {
+	hosted.stopClock()
+
+	game := hosted.game
+	if !game.clockRunning() {
+		return
+	}
+
+	gameID, moves := game.ID, len(game.Moves)
+	hosted.flag = time.AfterFunc(game.TimeLeft(game.CurrentTurn, time.Now()), func() {
+		m.flagPlayer(gameID, moves)
+	})
+}
+
+// stopClock stops the timer of a game that has ended. Callers must hold
+// mutex.
+func (h *hostedGame) stopClock() {
+	if h.flag != nil {
+		h.flag.Stop()
+		h.flag = nil
+	}
+}
+
+// flagPlayer ends a game on time, unless a move was made since the timer
+// was set.
+func (m *Manager) flagPlayer(gameID string, moves int) {
+	hosted := m.lookup(gameID)
+	if hosted == nil {
+		return
+	}
+
+	hosted.mutex.Lock()
+	defer hosted.mutex.Unlock()
+	if len(hosted.game.Moves) == moves {
+		m.timeOut(hosted, time.Now())
+	}
+}
+
+// timeOut ends the game if the player to move has run out of time at now,
+// reporting whether it did. Callers must hold the game's mutex.
+func (m *Manager) timeOut(hosted *hostedGame, now time.Time) bool {
+	game := hosted.game
+	if !game.clockRunning() || game.TimeLeft(game.CurrentTurn, now) > 0 {
+		return false
+	}
+
+	loser := game.CurrentTurn
+	*game.Clock.left(loser) = 0
+	game.Status = "finished"
+	game.Winner = PLAYER1
+	if loser == PLAYER1 {
+		game.Winner = PLAYER2
+	}
+	game.EndReason = "timeout"
+	game.LastMove = now
+	m.finishGame(hosted, "")
+	m.checkpoint(game)
+
+	log.Printf("Player %d ran out of time in game %s", loser, game.ID)
+	return true
+}
+
+// Berserk halves a player's time in an arena game, for an extra point if
+// they win. It can only be done before the player's first move.
+func (m *Manager) Berserk(gameID, username string) (*Game, error) {
+	hosted := m.lookup(gameID)
+	if hosted == nil {
+		return nil, ErrGameNotFound
+	}
+
+	hosted.mutex.Lock()
+	defer hosted.mutex.Unlock()
+
+	game := hosted.game
+	player := playerNumber(game, username)
+	if player == 0 {
+		return nil, ErrPlayerNotFound
+	}
+	if game.Status != "playing" {
+		return nil, ErrGameNotActive
+	}
+	if game.Arena == "" || game.Clock == nil {
+		return nil, ErrBerserkNotAllowed
+	}
+	if game.Clock.berserked(player) {
+		return game.snapshot(), nil
+	}
+	if len(game.Moves) >= player {
+		return nil, ErrBerserkTooLate
+	}
+
+	left := game.Clock.left(player)
+	if game.clockRunning() && game.CurrentTurn == player {
+		// Their time is running: halve what is left of it now
+		*left += time.Since(game.LastMove).Milliseconds()
+	}
+	*left /= 2
+	if player == PLAYER1 {
+		game.Clock.Berserk1 = true
+	} else {
+		game.Clock.Berserk2 = true
+	}
+	m.runClock(hosted)
+	m.checkpoint(game)
+
+	snapshot := game.snapshot()
+	m.events.Publish(PlayerBerserked{Game: snapshot, Player: username})
+	log.Printf("Player %s berserked in game %s", username, gameID)
+	return snapshot, nil
+}
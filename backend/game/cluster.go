@@ -0,0 +1,535 @@
+package game
+
+import (
+	"connect4-backend/cluster"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// In cluster mode every node hosts the games whose IDs hash to it, and the
+// node that owns matchmakingKey keeps the matchmaking queue for the whole
+// cluster. Other nodes reach the queue, and the matchmaker reaches games
+// hosted elsewhere, over the cluster RPC. No call is made while a game is
+// locked.
+
+const matchmakingKey = "matchmaking"
+
+// InviteKey is the cluster key of a private game's invite code. Its node
+// hosts the game.
+func InviteKey(code string) string {
+	return "invite/" + strings.ToUpper(strings.TrimSpace(code))
+}
+
+type queueJoinArgs struct {
+	Username string           `json:"username"`
+	GameID   string           `json:"gameId"`
+	Prefs    QueuePreferences `json:"prefs"`
+	Rating   float64          `json:"rating"`
+	Node     string           `json:"node"`
+}
+
+type queueEntryReply struct {
+	Found    bool      `json:"found"`
+	GameID   string    `json:"gameId"`
+	JoinedAt time.Time `json:"joinedAt"`
+}
+
+type queueStatusReply struct {
+	Status QueueStatus `json:"status"`
+	Queued bool        `json:"queued"`
+}
+
+type gameArgs struct {
+	GameID string        `json:"gameId"`
+	Wait   time.Duration `json:"wait,omitempty"`
+}
+
+type gameReply struct {
+	Game *Game `json:"game"`
+}
+
+type seatArgs struct {
+	GameID string        `json:"gameId"`
+	Player *Player       `json:"player"`
+	Queue  string        `json:"queue"`
+	Wait   time.Duration `json:"wait"`
+	Spread float64       `json:"spread"`
+}
+
+type matchedArgs struct {
+	PreviousGameID string  `json:"previousGameId"`
+	Game           *Game   `json:"game"`
+	Player         *Player `json:"player"`
+}
+
+type queueStatusArgs struct {
+	GameID string      `json:"gameId"`
+	Status QueueStatus `json:"status"`
+}
+
+// Cluster returns the cluster the manager is part of, or nil.
+func (m *Manager) Cluster() *cluster.Cluster {
+	return m.cluster
+}
+
+// claimGameID gives a new game an ID that hashes to this node, so any node
+// can find the game from its ID alone.
+func (m *Manager) claimGameID(game *Game) {
+	for m.cluster != nil && !m.cluster.Owns(game.ID) {
+		game.ID = uuid.New().String()
+	}
+}
+
+// ownsInviteCode reports whether a private game created here may use code.
+func (m *Manager) ownsInviteCode(code string) bool {
+	return m.cluster == nil || m.cluster.Owns(InviteKey(code))
+}
+
+func (m *Manager) queueNode() cluster.Node {
+	return m.cluster.Owner(matchmakingKey)
+}
+
+// dequeue takes a player out of the matchmaking queue, wherever it is kept.
+// It returns nil if they were not queued.
+func (m *Manager) dequeue(username string) *queueEntry {
+	if m.cluster == nil {
+		m.queueMutex.Lock()
+		defer m.queueMutex.Unlock()
+		return m.removeQueueEntry(func(e *queueEntry) bool {
+			return e.player.Username == username
+		})
+	}
+
+	var reply queueEntryReply
+	if err := m.cluster.Call(m.queueNode(), "queue.remove", username, &reply); err != nil {
+		log.Printf("Failed to take %s out of the queue: %v", username, err)
+		return nil
+	}
+	if !reply.Found {
+		return nil
+	}
+	return &queueEntry{
+		player:   &Player{ID: username, Username: username},
+		gameID:   reply.GameID,
+		joinedAt: reply.JoinedAt,
+	}
+}
+
+// discardWaitingGame drops a game nobody joined, on whichever node hosts it.
+func (m *Manager) discardWaitingGame(gameID string) {
+	if m.cluster != nil && !m.cluster.Owns(gameID) {
+		if err := m.cluster.Call(m.cluster.Owner(gameID), "game.discard", gameArgs{GameID: gameID}, nil); err != nil {
+			log.Printf("Failed to discard game %s: %v", gameID, err)
+		}
+		return
+	}
+
+	if hosted := m.lookup(gameID); hosted != nil {
+		hosted.mutex.Lock()
+		if hosted.game.Status == "waiting" {
+			m.unhost(gameID)
+		}
+		hosted.mutex.Unlock()
+	}
+}
+
+// enqueueInCluster hosts a waiting game here and queues the player on the
+// matchmaking node. A player already queued from this node gets their
+// existing game back; one queued from another node moves their search here.
+func (m *Manager) enqueueInCluster(player *Player, prefs QueuePreferences, rating float64) (*Game, *Player, error) {
+	game := NewGame(player)
+	game.Variant = prefs.Variant
+	game.TimeControl = prefs.TimeControl
+	game.Rated = prefs.Rated
+	m.claimGameID(game)
+
+	snapshot := game.snapshot()
+	m.events.Publish(GameCreated{Game: snapshot})
+	m.host(game)
+
+	var reply queueEntryReply
+	err := m.cluster.Call(m.queueNode(), "queue.join", queueJoinArgs{
+		Username: player.Username,
+		GameID:   game.ID,
+		Prefs:    prefs,
+		Rating:   rating,
+		Node:     m.cluster.Self().ID,
+	}, &reply)
+	if err != nil {
+		m.unhost(game.ID)
+		return nil, nil, err
+	}
+
+	if reply.GameID != game.ID {
+		m.unhost(game.ID)
+		if existing, exists := m.GetGame(reply.GameID); exists {
+			return existing, existing.Player1, nil
+		}
+		return nil, nil, ErrGameNotFound
+	}
+
+	log.Printf("Player %s (%.0f) queued for %s in game %s", player.Username, rating, prefs.key(), game.ID)
+
+	return snapshot, snapshot.Player1, nil
+}
+
+// dropQueueEntry stops the matchmaker pairing the host of a game, on
+// whichever node keeps the queue. It must not be called with queueMutex
+// or a game's lock held.
+func (m *Manager) dropQueueEntry(gameID string) {
+	if m.cluster == nil {
+		m.queueMutex.Lock()
+		defer m.queueMutex.Unlock()
+		m.removeQueueEntry(func(e *queueEntry) bool {
+			return e.gameID == gameID
+		})
+		return
+	}
+
+	if err := m.cluster.Call(m.queueNode(), "queue.drop", gameArgs{GameID: gameID}, nil); err != nil {
+		log.Printf("Failed to take game %s out of the queue: %v", gameID, err)
+	}
+}
+
+// requeue puts an entry back at the front of the queue.
+func (m *Manager) requeue(entry *queueEntry) {
+	m.queueMutex.Lock()
+	m.queue = append([]*queueEntry{entry}, m.queue...)
+	m.queueMutex.Unlock()
+}
+
+// startMatchAcross is startMatch for games that may be hosted on other
+// nodes. The second player's waiting game is taken off its node first, so
+// nobody can join it while they are being seated, and put back if the first
+// player could not be reached or their game was taken in the meantime.
+func (m *Manager) startMatchAcross(pair queuePair) {
+	first, second := pair.first, pair.second
+	secondNode := m.cluster.Owner(second.gameID)
+
+	var retired gameReply
+	err := m.cluster.Call(secondNode, "game.retire", gameArgs{GameID: second.gameID}, &retired)
+	if err != nil || retired.Game == nil {
+		if err != nil {
+			log.Printf("Failed to retire game %s: %v", second.gameID, err)
+		}
+		m.requeue(first)
+		return
+	}
+
+	var seated gameReply
+	err = m.cluster.Call(m.cluster.Owner(first.gameID), "game.seat", seatArgs{
+		GameID: first.gameID,
+		Player: second.player,
+		Queue:  first.prefs.key(),
+		Wait:   pair.wait,
+		Spread: pair.spread,
+	}, &seated)
+	if err != nil || seated.Game == nil {
+		if err != nil {
+			// Their game was not taken, so they are still waiting
+			log.Printf("Failed to seat %s in game %s: %v", second.player.Username, first.gameID, err)
+			m.requeue(first)
+		}
+		if err := m.cluster.Call(secondNode, "game.restore", retired, nil); err != nil {
+			log.Printf("Failed to restore game %s: %v", second.gameID, err)
+			return
+		}
+		m.requeue(second)
+		return
+	}
+
+	log.Printf("Matched players: %s vs %s in game %s (rating spread %.0f)",
+		seated.Game.Player1.Username, seated.Game.Player2.Username, seated.Game.ID, pair.spread)
+
+	// Move the second player's clients over
+	err = m.cluster.Call(secondNode, "game.matched", matchedArgs{
+		PreviousGameID: second.gameID,
+		Game:           seated.Game,
+		Player:         second.player,
+	}, nil)
+	if err != nil {
+		log.Printf("Failed to move %s to game %s: %v", second.player.Username, seated.Game.ID, err)
+	}
+}
+
+// seatQueuedBot seats a bot in a queued player's game, on whichever node
+// hosts it.
+func (m *Manager) seatQueuedBot(entry *queueEntry, wait time.Duration) {
+	if m.cluster != nil && !m.cluster.Owns(entry.gameID) {
+		err := m.cluster.Call(m.cluster.Owner(entry.gameID), "game.seatBot", gameArgs{GameID: entry.gameID, Wait: wait}, nil)
+		if err != nil {
+			log.Printf("Failed to seat a bot in game %s: %v", entry.gameID, err)
+		}
+		return
+	}
+
+	if hosted := m.lookup(entry.gameID); hosted != nil {
+		m.seatBot(hosted, wait)
+	}
+}
+
+// sendQueueStatus tells a queued player's clients where they stand, through
+// the node that hosts their game.
+func (m *Manager) sendQueueStatus(gameID string, status QueueStatus) {
+	if m.cluster != nil && !m.cluster.Owns(gameID) {
+		err := m.cluster.Call(m.cluster.Owner(gameID), "game.queueStatus", queueStatusArgs{GameID: gameID, Status: status}, nil)
+		if err != nil {
+			log.Printf("Failed to send queue status for game %s: %v", gameID, err)
+		}
+		return
+	}
+
+	if m.onQueueStatus != nil {
+		m.onQueueStatus(gameID, status)
+	}
+}
+
+// forgetPlayers tells the other nodes to reload the ratings of a
+// game's players, which this node has just changed in the store.
+func (m *Manager) forgetPlayers(game *Game) {
+	if m.cluster != nil {
+		m.cluster.Broadcast("players.forget", seatedPlayers(game))
+	}
+}
+
+// serveCluster registers the calls other nodes make on this one.
+func (m *Manager) serveCluster() {
+	// The matchmaking queue, on its node
+	m.cluster.Handle("queue.join", m.handleQueueJoin)
+	m.cluster.Handle("queue.remove", m.handleQueueRemove)
+	m.cluster.Handle("queue.drop", m.handleQueueDrop)
+	m.cluster.Handle("queue.status", m.handleQueueStatus)
+	m.cluster.Handle("queue.metrics", m.handleQueueMetrics)
+
+	// Games hosted here
+	m.cluster.Handle("game.retire", m.handleGameRetire)
+	m.cluster.Handle("game.restore", m.handleGameRestore)
+	m.cluster.Handle("game.seat", m.handleGameSeat)
+	m.cluster.Handle("game.matched", m.handleGameMatched)
+	m.cluster.Handle("game.seatBot", m.handleGameSeatBot)
+	m.cluster.Handle("game.discard", m.handleGameDiscard)
+	m.cluster.Handle("game.queueStatus", m.handleGameQueueStatus)
+
+	m.cluster.Handle("players.forget", m.handlePlayersForget)
+}
+
+func (m *Manager) handleQueueJoin(body json.RawMessage) (interface{}, error) {
+	var args queueJoinArgs
+	if err := json.Unmarshal(body, &args); err != nil {
+		return nil, err
+	}
+	return m.joinQueue(args), nil
+}
+
+func (m *Manager) handleQueueRemove(body json.RawMessage) (interface{}, error) {
+	var username string
+	if err := json.Unmarshal(body, &username); err != nil {
+		return nil, err
+	}
+
+	m.queueMutex.Lock()
+	defer m.queueMutex.Unlock()
+
+	entry := m.removeQueueEntry(func(e *queueEntry) bool {
+		return e.player.Username == username
+	})
+	if entry == nil {
+		return queueEntryReply{}, nil
+	}
+	return queueEntryReply{Found: true, GameID: entry.gameID, JoinedAt: entry.joinedAt}, nil
+}
+
+func (m *Manager) handleQueueDrop(body json.RawMessage) (interface{}, error) {
+	var args gameArgs
+	if err := json.Unmarshal(body, &args); err != nil {
+		return nil, err
+	}
+
+	m.queueMutex.Lock()
+	defer m.queueMutex.Unlock()
+
+	m.removeQueueEntry(func(e *queueEntry) bool {
+		return e.gameID == args.GameID
+	})
+	return nil, nil
+}
+
+func (m *Manager) handleQueueStatus(body json.RawMessage) (interface{}, error) {
+	var username string
+	if err := json.Unmarshal(body, &username); err != nil {
+		return nil, err
+	}
+
+	status, queued := m.localQueueStatus(username)
+	return queueStatusReply{Status: status, Queued: queued}, nil
+}
+
+func (m *Manager) handleQueueMetrics(body json.RawMessage) (interface{}, error) {
+	return m.localMatchmakingMetrics(), nil
+}
+
+func (m *Manager) handleGameRetire(body json.RawMessage) (interface{}, error) {
+	var args gameArgs
+	if err := json.Unmarshal(body, &args); err != nil {
+		return nil, err
+	}
+
+	hosted := m.lookup(args.GameID)
+	if hosted == nil {
+		return gameReply{}, nil
+	}
+
+	hosted.mutex.Lock()
+	defer hosted.mutex.Unlock()
+
+	if hosted.game.Status != "waiting" {
+		return gameReply{}, nil
+	}
+	m.unhost(args.GameID)
+	return gameReply{Game: hosted.game.snapshot()}, nil
+}
+
+func (m *Manager) handleGameRestore(body json.RawMessage) (interface{}, error) {
+	var args gameReply
+	if err := json.Unmarshal(body, &args); err != nil {
+		return nil, err
+	}
+
+	if args.Game != nil && args.Game.Status == "waiting" {
+		m.host(args.Game)
+	}
+	return nil, nil
+}
+
+func (m *Manager) handleGameSeat(body json.RawMessage) (interface{}, error) {
+	var args seatArgs
+	if err := json.Unmarshal(body, &args); err != nil {
+		return nil, err
+	}
+	return gameReply{Game: m.seatQueuedPlayer(args)}, nil
+}
+
+func (m *Manager) handleGameMatched(body json.RawMessage) (interface{}, error) {
+	var args matchedArgs
+	if err := json.Unmarshal(body, &args); err != nil {
+		return nil, err
+	}
+
+	if m.onPlayerMatched != nil {
+		m.onPlayerMatched(args.PreviousGameID, args.Game, args.Player)
+	}
+	return nil, nil
+}
+
+func (m *Manager) handleGameSeatBot(body json.RawMessage) (interface{}, error) {
+	var args gameArgs
+	if err := json.Unmarshal(body, &args); err != nil {
+		return nil, err
+	}
+
+	if hosted := m.lookup(args.GameID); hosted != nil {
+		m.seatBot(hosted, args.Wait)
+	}
+	return nil, nil
+}
+
+func (m *Manager) handleGameDiscard(body json.RawMessage) (interface{}, error) {
+	var args gameArgs
+	if err := json.Unmarshal(body, &args); err != nil {
+		return nil, err
+	}
+
+	m.discardWaitingGame(args.GameID)
+	return nil, nil
+}
+
+func (m *Manager) handleGameQueueStatus(body json.RawMessage) (interface{}, error) {
+	var args queueStatusArgs
+	if err := json.Unmarshal(body, &args); err != nil {
+		return nil, err
+	}
+
+	if m.onQueueStatus != nil {
+		m.onQueueStatus(args.GameID, args.Status)
+	}
+	return nil, nil
+}
+
+func (m *Manager) handlePlayersForget(body json.RawMessage) (interface{}, error) {
+	var usernames []string
+	if err := json.Unmarshal(body, &usernames); err != nil {
+		return nil, err
+	}
+
+	m.ratings.Forget(usernames)
+	m.leaderboards.clear()
+	return nil, nil
+}
+
+// joinQueue adds a player to the queue kept on this node, replacing an
+// entry they have from another node. The replaced entry's waiting game is
+// discarded on the node hosting it.
+func (m *Manager) joinQueue(args queueJoinArgs) queueEntryReply {
+	m.queueMutex.Lock()
+	defer m.queueMutex.Unlock()
+
+	for _, entry := range m.queue {
+		if entry.player.Username == args.Username && m.cluster.Owner(entry.gameID).ID == args.Node {
+			return queueEntryReply{Found: true, GameID: entry.gameID, JoinedAt: entry.joinedAt}
+		}
+	}
+	replaced := m.removeQueueEntry(func(e *queueEntry) bool {
+		return e.player.Username == args.Username
+	})
+	if replaced != nil {
+		// Not under queueMutex: the game may be hosted on another node
+		go m.discardWaitingGame(replaced.gameID)
+	}
+
+	entry := &queueEntry{
+		player: &Player{
+			ID:       args.Username,
+			Username: args.Username,
+			IsBot:    false,
+		},
+		gameID:   args.GameID,
+		prefs:    args.Prefs,
+		rating:   args.Rating,
+		joinedAt: time.Now(),
+	}
+	m.queue = append(m.queue, entry)
+	return queueEntryReply{Found: true, GameID: entry.gameID, JoinedAt: entry.joinedAt}
+}
+
+// seatQueuedPlayer seats a player the matchmaker paired in a waiting game
+// hosted here. It returns nil if the game was taken in the meantime.
+func (m *Manager) seatQueuedPlayer(args seatArgs) *Game {
+	hosted := m.lookup(args.GameID)
+	if hosted == nil {
+		return nil
+	}
+
+	hosted.mutex.Lock()
+	defer hosted.mutex.Unlock()
+
+	game := hosted.game
+	if game.Status != "waiting" {
+		return nil
+	}
+
+	game.AddPlayer2(args.Player)
+	m.checkpoint(game)
+	snapshot := game.snapshot()
+	m.events.Publish(PlayerJoined{
+		Game:         snapshot,
+		Player:       args.Player,
+		Queue:        args.Queue,
+		Wait:         args.Wait,
+		RatingSpread: args.Spread,
+	})
+	return snapshot
+}
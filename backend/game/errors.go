@@ -3,11 +3,26 @@ package game
 import "errors"
 
 var (
-	ErrGameNotActive  = errors.New("game is not active")
-	ErrNotYourTurn    = errors.New("not your turn")
-	ErrInvalidColumn  = errors.New("invalid column")
-	ErrColumnFull     = errors.New("column is full")
-	ErrGameNotFound   = errors.New("game not found")
-	ErrPlayerNotFound = errors.New("player not found")
-	ErrGameFull       = errors.New("game is full")
-)
\ No newline at end of file
+	ErrGameNotActive      = errors.New("game is not active")
+	ErrNotYourTurn        = errors.New("not your turn")
+	ErrInvalidColumn      = errors.New("invalid column")
+	ErrColumnFull         = errors.New("column is full")
+	ErrGameNotFound       = errors.New("game not found")
+	ErrPlayerNotFound     = errors.New("player not found")
+	ErrGameFull           = errors.New("game is full")
+	ErrInvalidUsername    = errors.New("valid username is required")
+	ErrReservedUsername   = errors.New("username is reserved")
+	ErrUnknownVariant     = errors.New("unknown variant")
+	ErrInvalidTimeControl = errors.New("invalid time control")
+	ErrNotInQueue         = errors.New("not in matchmaking queue")
+	ErrInviteNotFound     = errors.New("invite code not found or expired")
+	ErrWrongPassword      = errors.New("wrong password")
+	ErrPrivateGame        = errors.New("private games are joined with their invite code")
+	ErrInvalidPly         = errors.New("ply is outside the game")
+	ErrCorruptRecord      = errors.New("recorded moves do not form a legal game")
+	ErrBanned             = errors.New("player is banned")
+	ErrSamePlayer         = errors.New("a player cannot play themselves")
+	ErrOutOfTime          = errors.New("out of time")
+	ErrBerserkNotAllowed  = errors.New("berserk is only allowed in arena games with a clock")
+	ErrBerserkTooLate     = errors.New("berserk is only allowed before your first move")
+)
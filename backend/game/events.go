@@ -0,0 +1,249 @@
+package game
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultEventBuffer = 256
+
+	// A lossless subscriber this far behind is logged, each time its queue
+	// grows by as much again.
+	backlogWarning = 4096
+)
+
+// Event is something that happened to a game. Events carry a snapshot of
+// the game taken when they were published, so subscribers can read it
+// without any of the manager's locks.
+type Event interface {
+	GameID() string
+}
+
+// GameCreated is published when a player opens a new game and waits for an
+// opponent.
+type GameCreated struct {
+	Game *Game
+}
+
+// PlayerJoined is published when Player takes the second seat and the game
+// begins. Queue, Wait and RatingSpread are set for games the matchmaker
+// paired; RatingSpread only when both players are human.
+type PlayerJoined struct {
+	Game         *Game
+	Player       *Player
+	Queue        string
+	Wait         time.Duration
+	RatingSpread float64
+}
+
+// MoveMade is published after every move, by a player or the bot.
+type MoveMade struct {
+	Game *Game
+	Move *Move
+}
+
+// PlayerBerserked is published when Player halves their clock in an arena
+// game.
+type PlayerBerserked struct {
+	Game   *Game
+	Player string
+}
+
+// GameFinished is published when a game ends with a result, on the board,
+// by forfeit or by an admin (see Game.EndReason). Reason is the admin's
+// explanation, for the players.
+type GameFinished struct {
+	Game     *Game
+	Duration time.Duration
+	Reason   string
+}
+
+// GameAborted is published when a game ends without a result, because
+// Player left before a move was made or an admin aborted it giving Reason.
+type GameAborted struct {
+	Game   *Game
+	Player string
+	Reason string
+}
+
+// GameExpired is published when a private game nobody joined is closed.
+type GameExpired struct {
+	Game *Game
+}
+
+// RatingsUpdated is published once the ratings of a finished rated game's
+// players have been updated. Duration is the game's, as in GameFinished.
+type RatingsUpdated struct {
+	Game     *Game
+	Changes  map[string]RatingChange // By username
+	Duration time.Duration
+}
+
+func (e GameCreated) GameID() string     { return e.Game.ID }
+func (e PlayerJoined) GameID() string    { return e.Game.ID }
+func (e MoveMade) GameID() string        { return e.Game.ID }
+func (e PlayerBerserked) GameID() string { return e.Game.ID }
+func (e GameFinished) GameID() string    { return e.Game.ID }
+func (e GameAborted) GameID() string     { return e.Game.ID }
+func (e GameExpired) GameID() string     { return e.Game.ID }
+func (e RatingsUpdated) GameID() string  { return e.Game.ID }
+
+// Bus delivers game events to any number of subscribers. Each subscriber
+// has its own queue and goroutine, so a slow or failing subscriber cannot
+// hold up games or the other subscribers.
+type Bus struct {
+	mutex       sync.RWMutex
+	subscribers []*subscriber
+}
+
+type subscriber struct {
+	name    string
+	events  chan Event
+	handler func(Event)
+	dropped atomic.Int64
+
+	// Lossless subscribers keep every event in queue instead, however far
+	// behind they fall
+	lossless bool
+	mutex    sync.Mutex
+	queue    []Event
+	ready    chan struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe calls handler with every event published from now on, in
+// order. Up to buffer events wait while the handler is busy; past that the
+// subscriber misses events rather than block the publisher, which suits
+// subscribers such as analytics and live updates. A handler that panics is
+// logged and goes on receiving events.
+func (b *Bus) Subscribe(name string, buffer int, handler func(Event)) {
+	b.add(&subscriber{
+		name:    name,
+		events:  make(chan Event, buffer),
+		handler: handler,
+	})
+}
+
+// SubscribeLossless is Subscribe for subscribers that must see every event,
+// such as those recording results: however far behind the handler falls,
+// events are queued for it rather than dropped.
+func (b *Bus) SubscribeLossless(name string, handler func(Event)) {
+	b.add(&subscriber{
+		name:     name,
+		handler:  handler,
+		lossless: true,
+		ready:    make(chan struct{}, 1),
+	})
+}
+
+func (b *Bus) add(s *subscriber) {
+	b.mutex.Lock()
+	b.subscribers = append(b.subscribers, s)
+	b.mutex.Unlock()
+
+	go s.run()
+}
+
+// Publish queues event for every subscriber. It never blocks, so it may be
+// called with the manager's locks held.
+func (b *Bus) Publish(event Event) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for _, s := range b.subscribers {
+		if s.lossless {
+			s.enqueue(event)
+			continue
+		}
+		select {
+		case s.events <- event:
+		default:
+			log.Printf("Event subscriber %s is falling behind: dropped %T for game %s (%d dropped so far)",
+				s.name, event, event.GameID(), s.dropped.Add(1))
+		}
+	}
+}
+
+func (s *subscriber) enqueue(event Event) {
+	s.mutex.Lock()
+	s.queue = append(s.queue, event)
+	backlog := len(s.queue)
+	s.mutex.Unlock()
+
+	if backlog%backlogWarning == 0 {
+		log.Printf("Event subscriber %s is falling behind: %d events queued", s.name, backlog)
+	}
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscriber) run() {
+	if !s.lossless {
+		for event := range s.events {
+			s.handle(event)
+		}
+		return
+	}
+
+	for range s.ready {
+		for {
+			s.mutex.Lock()
+			queued := s.queue
+			s.queue = nil
+			s.mutex.Unlock()
+			if len(queued) == 0 {
+				break
+			}
+			for _, event := range queued {
+				s.handle(event)
+			}
+		}
+	}
+}
+
+func (s *subscriber) handle(event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Event subscriber %s failed on %T for game %s: %v", s.name, event, event.GameID(), r)
+		}
+	}()
+	s.handler(event)
+}
+
+// snapshot returns a copy of the game that later changes to it do not
+// affect. Callers must hold the game's mutex.
+func (g *Game) snapshot() *Game {
+	copied := *g
+	copied.Board = make([][]int, len(g.Board))
+	for i, row := range g.Board {
+		copied.Board[i] = append([]int(nil), row...)
+	}
+	copied.Moves = append([]int{}, g.Moves...)
+	if g.Player1 != nil {
+		player := *g.Player1
+		copied.Player1 = &player
+	}
+	if g.Player2 != nil {
+		player := *g.Player2
+		copied.Player2 = &player
+	}
+	if g.Clock != nil {
+		clock := *g.Clock
+		copied.Clock = &clock
+	}
+	if g.RatingChanges != nil {
+		copied.RatingChanges = make(map[string]RatingChange, len(g.RatingChanges))
+		for username, change := range g.RatingChanges {
+			copied.RatingChanges[username] = change
+		}
+	}
+	return &copied
+}
@@ -16,16 +16,28 @@ const (
 )
 
 type Game struct {
-	ID          string     `json:"id"`
-	Board       [][]int    `json:"board"`
-	CurrentTurn int        `json:"currentTurn"`
-	Status      string     `json:"status"` // "waiting", "playing", "finished"
-	Winner      int        `json:"winner"`
-	Player1     *Player    `json:"player1"`
-	Player2     *Player    `json:"player2"`
-	CreatedAt   time.Time  `json:"createdAt"`
-	LastMove    time.Time  `json:"lastMove"`
-	IsBot       bool       `json:"isBot"`
+	ID            string                  `json:"id"`
+	Board         [][]int                 `json:"board"`
+	CurrentTurn   int                     `json:"currentTurn"`
+	Status        string                  `json:"status"` // "waiting", "playing", "finished", "aborted", "expired"
+	Winner        int                     `json:"winner"`
+	Player1       *Player                 `json:"player1"`
+	Player2       *Player                 `json:"player2"`
+	CreatedAt     time.Time               `json:"createdAt"`
+	LastMove      time.Time               `json:"lastMove"`
+	IsBot         bool                    `json:"isBot"`
+	BotLevel      int                     `json:"botLevel,omitempty"`
+	Variant       string                  `json:"variant"`
+	TimeControl   string                  `json:"timeControl"`
+	Rated         bool                    `json:"rated"`
+	Moves         []int                   `json:"moves"` // Columns played, in order
+	Private       bool                    `json:"private"`
+	InviteCode    string                  `json:"inviteCode,omitempty"`
+	Tournament    string                  `json:"tournament,omitempty"`    // ID of the tournament the game is part of
+	Arena         string                  `json:"arena,omitempty"`         // ID of the arena the game is part of
+	Clock         *Clock                  `json:"clock,omitempty"`         // nil for games without a time control
+	EndReason     string                  `json:"endReason,omitempty"`     // Set when a game ends other than on the board
+	RatingChanges map[string]RatingChange `json:"ratingChanges,omitempty"` // By username, once a rated game ends
 }
 
 type Player struct {
@@ -60,6 +72,7 @@ func NewGame(player1 *Player) *Game {
 		Winner:      0,
 		Player1:     player1,
 		CreatedAt:   time.Now(),
+		Moves:       []int{},
 		LastMove:    time.Now(),
 	}
 }
@@ -68,6 +81,7 @@ func (g *Game) AddPlayer2(player *Player) {
 	g.Player2 = player
 	g.Status = "playing"
 	g.IsBot = player.IsBot
+	g.Clock = newClock(g.TimeControl)
 }
 
 func (g *Game) MakeMove(column int, player int) (*Move, error) {
@@ -97,8 +111,11 @@ func (g *Game) MakeMove(column int, player int) (*Move, error) {
 	}
 
 	// Place the piece
+	now := time.Now()
 	g.Board[row][column] = player
-	g.LastMove = time.Now()
+	g.Moves = append(g.Moves, column)
+	g.punchClock(player, now)
+	g.LastMove = now
 
 	move := &Move{
 		GameID: g.ID,
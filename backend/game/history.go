@@ -0,0 +1,296 @@
+package game
+
+import (
+	"connect4-backend/store"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultGamesLimit = 20
+	maxGamesLimit     = 100
+)
+
+var gameResults = map[string]bool{"win": true, "loss": true, "draw": true, "decisive": true}
+
+// GameRecord is a finished game as it was recorded, along with how it
+// moved its players' ratings.
+type GameRecord struct {
+	store.Game
+	RatingChanges map[string]RatingChange `json:"ratingChanges,omitempty"` // Only filled in by FinishedGame
+}
+
+// storedGame is how a game that finished after duration seconds is
+// recorded.
+func storedGame(game *Game, duration float64) store.Game {
+	result := store.Game{
+		ID:          game.ID,
+		Player1:     game.Player1.Username,
+		Winner:      "draw",
+		IsBot:       game.IsBot,
+		Variant:     game.Variant,
+		TimeControl: game.TimeControl,
+		Rated:       game.Rated,
+		Duration:    duration,
+		CreatedAt:   game.CreatedAt,
+		Moves:       append([]int{}, game.Moves...),
+		EndReason:   game.EndReason,
+	}
+	if game.Player2 != nil {
+		result.Player2 = game.Player2.Username
+	}
+	if game.Winner == PLAYER1 {
+		result.Winner = result.Player1
+	} else if game.Winner == PLAYER2 {
+		result.Winner = result.Player2
+	}
+	if result.Variant == "" {
+		result.Variant = "standard"
+	}
+	if result.TimeControl == "" {
+		result.TimeControl = "unlimited"
+	}
+	return result
+}
+
+// humans returns the usernames of the human players in the game.
+func (r *GameRecord) humans() []string {
+	if r.IsBot || r.Player2 == "" {
+		return []string{r.Player1}
+	}
+	return []string{r.Player1, r.Player2}
+}
+
+// resultFilter narrows the recorded games a query looks at.
+type resultFilter = store.GameFilter
+
+// loadResults returns every recorded game matching filter, oldest first.
+func (m *Manager) loadResults(filter resultFilter) ([]GameRecord, error) {
+	results, err := m.queryRecords(filter)
+	for i, j := 0, len(results)-1; i < j; i, j = i+1, j-1 {
+		results[i], results[j] = results[j], results[i]
+	}
+	return results, err
+}
+
+// queryRecords returns up to filter.Limit of the recorded games matching
+// filter, newest first. A zero Limit returns all of them.
+func (m *Manager) queryRecords(filter resultFilter) ([]GameRecord, error) {
+	games, err := m.store.Games(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]GameRecord, len(games))
+	for i, game := range games {
+		results[i] = GameRecord{Game: game}
+	}
+	return results, nil
+}
+
+// encodeGameCursor encodes the position of the last game on a page of
+// search results.
+func encodeGameCursor(c store.Cursor) string {
+	raw := strconv.FormatInt(c.CreatedAt.UnixNano(), 10) + ":" + c.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeGameCursor(cursor string) (*store.Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	nanos, id, found := strings.Cut(string(raw), ":")
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if !found || err != nil {
+		return nil, errInvalidCursor
+	}
+	return &store.Cursor{CreatedAt: time.Unix(0, n), ID: id}, nil
+}
+
+// parseGameQuery reads a game search from URL parameters. Dates may be
+// given as RFC 3339 times or as YYYY-MM-DD, which covers the whole day.
+func parseGameQuery(values url.Values) (resultFilter, error) {
+	filter := resultFilter{
+		Mode:    values.Get("mode"),
+		Variant: values.Get("variant"),
+		Result:  values.Get("result"),
+		Limit:   defaultGamesLimit,
+	}
+
+	var err error
+	if player := values.Get("player"); player != "" {
+		if filter.Player, err = cleanUsername(player); err != nil {
+			return filter, err
+		}
+	}
+	if opponent := values.Get("opponent"); opponent != "" {
+		if filter.Player == "" {
+			return filter, errors.New("opponent requires player")
+		}
+		if filter.Opponent, err = cleanUsername(opponent); err != nil {
+			return filter, err
+		}
+	}
+
+	if filter.Mode != "" && !leaderboardModes[filter.Mode] {
+		return filter, errors.New("unknown mode " + strconv.Quote(filter.Mode))
+	}
+	if filter.Variant != "" && !variants[filter.Variant] {
+		return filter, ErrUnknownVariant
+	}
+	if filter.Result != "" && !gameResults[filter.Result] {
+		return filter, errors.New("unknown result " + strconv.Quote(filter.Result))
+	}
+	if (filter.Result == "win" || filter.Result == "loss") && filter.Player == "" {
+		return filter, errors.New("result " + filter.Result + " requires player")
+	}
+
+	if from := values.Get("from"); from != "" {
+		if filter.Since, err = parseDate(from, false); err != nil {
+			return filter, err
+		}
+	}
+	if to := values.Get("to"); to != "" {
+		if filter.Until, err = parseDate(to, true); err != nil {
+			return filter, err
+		}
+	}
+
+	if minMoves := values.Get("minMoves"); minMoves != "" {
+		if filter.MinMoves, err = strconv.Atoi(minMoves); err != nil || filter.MinMoves < 0 {
+			return filter, errors.New("minMoves must be a number")
+		}
+	}
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return filter, errors.New("limit must be a positive number")
+		}
+		if n > maxGamesLimit {
+			n = maxGamesLimit
+		}
+		filter.Limit = n
+	}
+	if cursor := values.Get("cursor"); cursor != "" {
+		if filter.After, err = decodeGameCursor(cursor); err != nil {
+			return filter, err
+		}
+	}
+	return filter, nil
+}
+
+// parseDate parses a search date. A bare date used as an upper bound
+// includes that whole day.
+func parseDate(value string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return t, errors.New("invalid date " + strconv.Quote(value))
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// GamePage is one page of game search results.
+type GamePage struct {
+	Games      []GameRecord `json:"games"` // Newest first
+	NextCursor string       `json:"nextCursor,omitempty"`
+}
+
+// SearchGames returns a page of the recorded games matching filter.
+func (m *Manager) SearchGames(filter resultFilter) (*GamePage, error) {
+	// Fetch one extra game to tell whether there is another page
+	limit := filter.Limit
+	filter.Limit++
+	games, err := m.queryRecords(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &GamePage{Games: games}
+	if len(games) > limit {
+		page.Games = games[:limit]
+		last := &page.Games[limit-1]
+		page.NextCursor = encodeGameCursor(store.Cursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+	if page.Games == nil {
+		page.Games = []GameRecord{}
+	}
+	return page, nil
+}
+
+// FinishedGame returns a recorded game with its moves and rating changes.
+func (m *Manager) FinishedGame(gameID string) (*GameRecord, error) {
+	game, err := m.store.Game(gameID)
+	if err == store.ErrNotFound {
+		return nil, ErrGameNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	updates, err := m.store.RatingUpdates(gameID)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &GameRecord{Game: game}
+	for _, update := range updates {
+		if record.RatingChanges == nil {
+			record.RatingChanges = make(map[string]RatingChange)
+		}
+		record.RatingChanges[update.Username] = newRatingChange(update)
+	}
+	return record, nil
+}
+
+// GetGames serves /api/games. Parameters: player, opponent, mode ("human"
+// or "bot"), result, from, to, minMoves, variant, limit and cursor.
+func (m *Manager) GetGames(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseGameQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := m.SearchGames(filter)
+	if err != nil {
+		log.Printf("Failed to search games: %v", err)
+		http.Error(w, "Failed to search games", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// GetGameRecord serves /api/games/{id}.
+func (m *Manager) GetGameRecord(w http.ResponseWriter, r *http.Request) {
+	record, err := m.FinishedGame(mux.Vars(r)["id"])
+	if err == ErrGameNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to fetch game: %v", err)
+		http.Error(w, "Failed to fetch game", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(record)
+}
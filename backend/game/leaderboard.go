@@ -0,0 +1,390 @@
+package game
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLeaderboardLimit = 10
+	maxLeaderboardLimit     = 100
+	neighborhoodRadius      = 5
+
+	// Leaderboards kept at once; past that the cache starts over
+	maxCachedLeaderboards = 256
+)
+
+var (
+	leaderboardSorts   = map[string]bool{"rating": true, "wins": true, "winRate": true, "streak": true, "fastestWin": true}
+	leaderboardWindows = map[string]bool{"all": true, "season": true, "month": true, "week": true, "day": true}
+	leaderboardModes   = map[string]bool{"all": true, "human": true, "bot": true}
+
+	errInvalidCursor = errors.New("invalid cursor")
+)
+
+// LeaderboardQuery selects and orders a leaderboard.
+type LeaderboardQuery struct {
+	Sort    string `json:"sort"`   // "rating", "wins", "winRate", "streak" or "fastestWin"
+	Window  string `json:"window"` // "all", "season", "month", "week" or "day"
+	Mode    string `json:"mode"`   // "all", "human" or "bot"
+	Variant string `json:"variant,omitempty"`
+	Limit   int    `json:"limit"`
+	Offset  int    `json:"-"`
+	Player  string `json:"player,omitempty"` // Whose neighborhood to include
+}
+
+// parseLeaderboardQuery reads a LeaderboardQuery from URL parameters,
+// filling in defaults for those that are missing.
+func parseLeaderboardQuery(values url.Values) (LeaderboardQuery, error) {
+	q := LeaderboardQuery{
+		Sort:    values.Get("sort"),
+		Window:  values.Get("window"),
+		Mode:    values.Get("mode"),
+		Variant: values.Get("variant"),
+		Limit:   defaultLeaderboardLimit,
+	}
+	if player := values.Get("player"); player != "" {
+		q.Player, _ = cleanUsername(player)
+	}
+	if q.Sort == "" {
+		q.Sort = "rating"
+	}
+	if q.Window == "" {
+		q.Window = "all"
+	}
+	if q.Mode == "" {
+		q.Mode = "all"
+	}
+
+	if !leaderboardSorts[q.Sort] {
+		return q, errors.New("unknown sort " + strconv.Quote(q.Sort))
+	}
+	if !leaderboardWindows[q.Window] {
+		return q, errors.New("unknown window " + strconv.Quote(q.Window))
+	}
+	if !leaderboardModes[q.Mode] {
+		return q, errors.New("unknown mode " + strconv.Quote(q.Mode))
+	}
+	if q.Variant != "" && !variants[q.Variant] {
+		return q, ErrUnknownVariant
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return q, errors.New("limit must be a positive number")
+		}
+		if n > maxLeaderboardLimit {
+			n = maxLeaderboardLimit
+		}
+		q.Limit = n
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		offset, err := decodeCursor(cursor)
+		if err != nil {
+			return q, err
+		}
+		q.Offset = offset
+	}
+	return q, nil
+}
+
+// encodeCursor and decodeCursor turn a position in a listing into an opaque
+// token for fetching the next page.
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errInvalidCursor
+	}
+	offset, err := strconv.Atoi(string(raw))
+	if err != nil || offset < 0 {
+		return 0, errInvalidCursor
+	}
+	return offset, nil
+}
+
+// windowStart returns when the given leaderboard window began. Windows
+// follow the UTC calendar; a season is a calendar quarter.
+func windowStart(window string, now time.Time) time.Time {
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch window {
+	case "day":
+		return today
+	case "week":
+		// Weeks start on Monday
+		return today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+	case "month":
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case "season":
+		quarter := (now.Month() - 1) / 3
+		return time.Date(now.Year(), quarter*3+1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Time{}
+}
+
+// LeaderboardEntry is a player's standing on a leaderboard. Counts cover
+// the games in the leaderboard's window and mode; the rating is the
+// player's current one.
+type LeaderboardEntry struct {
+	Rank        int     `json:"rank"`
+	Username    string  `json:"username"`
+	Rating      float64 `json:"rating"`
+	Deviation   float64 `json:"deviation"`
+	Provisional bool    `json:"provisional"`
+	Wins        int     `json:"wins"`
+	GamesPlayed int     `json:"gamesPlayed"`
+	WinRate     float64 `json:"winRate"`
+	Streak      int     `json:"streak"`               // Consecutive wins up to the player's latest game
+	FastestWin  float64 `json:"fastestWin,omitempty"` // Seconds
+}
+
+// Leaderboard is one page of a leaderboard.
+type Leaderboard struct {
+	LeaderboardQuery
+	Entries      []LeaderboardEntry `json:"entries"`
+	Total        int                `json:"total"`
+	NextCursor   string             `json:"nextCursor,omitempty"`
+	Neighborhood []LeaderboardEntry `json:"neighborhood,omitempty"` // Entries around Player
+}
+
+// Leaderboard ranks the players who played in the query's window and mode.
+func (m *Manager) Leaderboard(q LeaderboardQuery) (*Leaderboard, error) {
+	entries, err := m.rankedPlayers(q)
+	if err != nil {
+		return nil, err
+	}
+
+	board := &Leaderboard{
+		LeaderboardQuery: q,
+		Entries:          []LeaderboardEntry{},
+		Total:            len(entries),
+	}
+	if q.Offset < len(entries) {
+		end := q.Offset + q.Limit
+		if end < len(entries) {
+			board.NextCursor = encodeCursor(end)
+		} else {
+			end = len(entries)
+		}
+		board.Entries = entries[q.Offset:end]
+	}
+
+	if q.Player != "" {
+		for i := range entries {
+			if entries[i].Username != q.Player {
+				continue
+			}
+			start, end := i-neighborhoodRadius, i+neighborhoodRadius+1
+			if start < 0 {
+				start = 0
+			}
+			if end > len(entries) {
+				end = len(entries)
+			}
+			board.Neighborhood = entries[start:end]
+			break
+		}
+	}
+	return board, nil
+}
+
+// leaderboardCache keeps ranked leaderboards, so requests for them do not
+// each load every game in their window. It is cleared whenever a game's
+// result or ratings are recorded, here or on another node.
+type leaderboardCache struct {
+	mutex      sync.Mutex
+	entries    map[leaderboardKey][]LeaderboardEntry
+	generation int // Times cleared
+}
+
+type leaderboardKey struct {
+	sort, mode, variant string
+	since               time.Time
+}
+
+func newLeaderboardCache() *leaderboardCache {
+	return &leaderboardCache{entries: make(map[leaderboardKey][]LeaderboardEntry)}
+}
+
+func (c *leaderboardCache) get(key leaderboardKey) ([]LeaderboardEntry, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entries, found := c.entries[key]
+	return entries, found
+}
+
+// put keeps entries for key unless the cache was cleared since generation,
+// when they may already be out of date.
+func (c *leaderboardCache) put(key leaderboardKey, entries []LeaderboardEntry, generation int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if len(c.entries) >= maxCachedLeaderboards {
+		c.entries = make(map[leaderboardKey][]LeaderboardEntry)
+	}
+	c.entries[key] = entries
+}
+
+func (c *leaderboardCache) current() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.generation
+}
+
+func (c *leaderboardCache) clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries = make(map[leaderboardKey][]LeaderboardEntry)
+	c.generation++
+}
+
+// rankedPlayers returns every ranked player on the query's leaderboard,
+// from the cache when it can. The entries are shared, so must not be
+// changed.
+func (m *Manager) rankedPlayers(q LeaderboardQuery) ([]LeaderboardEntry, error) {
+	key := leaderboardKey{
+		sort:    q.Sort,
+		mode:    q.Mode,
+		variant: q.Variant,
+		since:   windowStart(q.Window, time.Now()),
+	}
+	if entries, found := m.leaderboards.get(key); found {
+		return entries, nil
+	}
+
+	generation := m.leaderboards.current()
+	results, err := m.loadResults(resultFilter{
+		Since:   key.since,
+		Mode:    q.Mode,
+		Variant: q.Variant,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := m.rankPlayers(results, q.Sort)
+	m.leaderboards.put(key, entries, generation)
+	return entries, nil
+}
+
+// rankPlayers totals results, which must be oldest first, by human player
+// and orders them by the given sort.
+func (m *Manager) rankPlayers(results []GameRecord, by string) []LeaderboardEntry {
+	stats := make(map[string]*LeaderboardEntry)
+	var usernames []string
+
+	for i := range results {
+		result := &results[i]
+		for _, username := range result.humans() {
+			entry, exists := stats[username]
+			if !exists {
+				entry = &LeaderboardEntry{Username: username}
+				stats[username] = entry
+				usernames = append(usernames, username)
+			}
+
+			entry.GamesPlayed++
+			if result.Winner != username {
+				entry.Streak = 0
+				continue
+			}
+			entry.Wins++
+			entry.Streak++
+			if entry.FastestWin == 0 || result.Duration < entry.FastestWin {
+				entry.FastestWin = result.Duration
+			}
+		}
+	}
+
+	ratings := m.ratings.Ratings(usernames)
+
+	entries := make([]LeaderboardEntry, 0, len(stats))
+	for _, entry := range stats {
+		// Only players with a win have a fastest one to rank
+		if by == "fastestWin" && entry.Wins == 0 {
+			continue
+		}
+		rating := ratings[entry.Username]
+		entry.Rating = math.Round(rating.Rating)
+		entry.Deviation = math.Round(rating.Deviation)
+		entry.Provisional = rating.Provisional()
+		entry.WinRate = float64(entry.Wins) / float64(entry.GamesPlayed) * 100
+		entries = append(entries, *entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := &entries[i], &entries[j]
+		switch by {
+		case "wins":
+			if a.Wins != b.Wins {
+				return a.Wins > b.Wins
+			}
+		case "winRate":
+			if a.WinRate != b.WinRate {
+				return a.WinRate > b.WinRate
+			}
+			if a.GamesPlayed != b.GamesPlayed {
+				return a.GamesPlayed > b.GamesPlayed
+			}
+		case "streak":
+			if a.Streak != b.Streak {
+				return a.Streak > b.Streak
+			}
+		case "fastestWin":
+			if a.FastestWin != b.FastestWin {
+				return a.FastestWin < b.FastestWin
+			}
+		}
+		// Established ratings rank ahead of provisional ones
+		if a.Provisional != b.Provisional {
+			return !a.Provisional
+		}
+		if a.Rating != b.Rating {
+			return a.Rating > b.Rating
+		}
+		return a.Username < b.Username
+	})
+
+	for i := range entries {
+		entries[i].Rank = i + 1
+	}
+	return entries
+}
+
+// GetLeaderboard serves /api/leaderboard. See LeaderboardQuery for the
+// parameters; cursor continues from a previous page's nextCursor.
+func (m *Manager) GetLeaderboard(w http.ResponseWriter, r *http.Request) {
+	q, err := parseLeaderboardQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	board, err := m.Leaderboard(q)
+	if err != nil {
+		log.Printf("Failed to fetch leaderboard: %v", err)
+		http.Error(w, "Failed to fetch leaderboard", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(board)
+}
@@ -2,191 +2,153 @@ package game
 
 import (
 	"connect4-backend/bot"
-	"connect4-backend/database"
+	"connect4-backend/cluster"
 	"connect4-backend/kafka"
+	"connect4-backend/store"
 	"encoding/json"
 	"log"
 	"net/http"
-	"strings"
 	"sync"
 	"time"
 )
 
+// Manager hosts games. Each game has its own mutex, so games never wait on
+// each other; mutex only guards the registry of games and invite codes.
+// Locks are taken in the order queueMutex, a game's mutex, mutex, and no
+// store or Kafka I/O happens while any of them is held.
 type Manager struct {
-	games         map[string]*Game
-	waitingPlayer *Player
-	mutex         sync.RWMutex
-	db            *database.DB
-	kafka         *kafka.Producer
-	bot           *bot.Bot
-	onGameUpdate  func(gameID string, game *Game)
-	leaderboard   map[string]*PlayerStats
-	playerWins    map[string]int // Track consecutive wins for difficulty scaling
+	games           map[string]*hostedGame
+	rooms           map[string]*privateRoom // Keyed by invite code, guarded by mutex
+	mutex           sync.RWMutex
+	queue           []*queueEntry
+	queueMetrics    map[string]*queueMetrics // Keyed by QueuePreferences.key
+	queueMutex      sync.Mutex               // Taken before any other lock
+	maxQueueWait    time.Duration
+	store           store.Store
+	kafka           *kafka.Producer
+	bot             bot.Strategy
+	events          *Bus
+	checkpoints     *checkpointer
+	cluster         *cluster.Cluster // nil when running on a single node
+	onQueueStatus   func(gameID string, status QueueStatus)
+	onPlayerMatched func(previousGameID string, game *Game, player *Player)
+	isConnected     func(gameID, username string) bool
+	onSeatVacated   func(gameID, username string, deadline time.Time)
+	onSeatReturned  func(gameID, username string)
+	gracePeriod     time.Duration
+	ratings         *RatingTracker
+	leaderboards    *leaderboardCache
+	bans            map[string]store.Ban // Keyed by username, guarded by banMutex
+	banMutex        sync.RWMutex
 }
 
-type PlayerStats struct {
-	Username     string  `json:"username"`
-	Wins         int     `json:"wins"`
-	GamesPlayed  int     `json:"gamesPlayed"`
-	WinRate      float64 `json:"winRate"`
-	BestTime     float64 `json:"bestTime,omitempty"`
-	TotalTime    float64 `json:"totalTime"`
+// hostedGame is a game with what the manager keeps alongside it. All of it
+// is guarded by mutex.
+type hostedGame struct {
+	mutex       sync.Mutex
+	game        *Game
+	botSession  *bot.Session
+	disconnects map[string]*time.Timer // Keyed by username
+	flag        *time.Timer            // Ends the game when the player to move runs out of time
 }
 
-type LeaderboardEntry struct {
-	Username   string `json:"username"`
-	Wins       int    `json:"wins"`
-	GamesPlayed int   `json:"gamesPlayed"`
-	WinRate    float64 `json:"winRate"`
-}
-
-func NewManager(db *database.DB, kafkaProducer *kafka.Producer) *Manager {
-	manager := &Manager{
-		games:       make(map[string]*Game),
-		db:          db,
-		kafka:       kafkaProducer,
-		bot:         bot.NewBot(),
-		leaderboard: make(map[string]*PlayerStats),
-		playerWins:  make(map[string]int),
+func newHostedGame(game *Game) *hostedGame {
+	return &hostedGame{
+		game:        game,
+		disconnects: make(map[string]*time.Timer),
 	}
-	
-	// Start cleanup routine for old games
-	go manager.cleanupOldGames()
-	
-	return manager
 }
 
-func (m *Manager) SetGameUpdateCallback(callback func(gameID string, game *Game)) {
-	m.onGameUpdate = callback
-}
-
-func (m *Manager) FindOrCreateGame(username string) (*Game, *Player, bool) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
-
-	// Validate username
-	if len(strings.TrimSpace(username)) == 0 {
-		return nil, nil, false
+// NewManager returns a manager for a single server when node is nil, or
+// for one node of a cluster.
+func NewManager(st store.Store, kafkaProducer *kafka.Producer, node *cluster.Cluster) *Manager {
+	manager := &Manager{
+		games:        make(map[string]*hostedGame),
+		rooms:        make(map[string]*privateRoom),
+		gracePeriod:  gracePeriodFromEnv(),
+		queueMetrics: make(map[string]*queueMetrics),
+		maxQueueWait: maxQueueWaitFromEnv(),
+		store:        st,
+		kafka:        kafkaProducer,
+		events:       NewBus(),
+		bot:          bot.NewBot(),
+		ratings:      NewRatingTracker(st),
+		leaderboards: newLeaderboardCache(),
+		checkpoints:  newCheckpointer(st),
+		cluster:      node,
+		bans:         make(map[string]store.Ban),
 	}
 	
-	username = strings.TrimSpace(username)
-	if len(username) > 20 {
-		username = username[:20]
+	// Record results, rate players and report to analytics as games end
+	manager.events.SubscribeLossless("persistence", manager.recordResult)
+	manager.events.SubscribeLossless("ratings", manager.rateGame)
+	if kafkaProducer != nil {
+		manager.events.Subscribe("kafka", defaultEventBuffer, manager.sendEventToKafka)
 	}
 
-	player := &Player{
-		ID:       username,
-		Username: username,
-		IsBot:    false,
+	if node != nil {
+		manager.serveCluster()
+		manager.serveAdmin()
 	}
 
-	// Check if there's a waiting player (different from current player)
-	if m.waitingPlayer != nil && m.waitingPlayer.Username != username {
-		// Find the waiting game
-		var waitingGame *Game
-		for _, game := range m.games {
-			if game.Status == "waiting" && game.Player1.Username == m.waitingPlayer.Username {
-				waitingGame = game
-				break
-			}
-		}
+	// Pick up games interrupted by a restart
+	manager.loadBans()
+	manager.restoreGames()
 
-		if waitingGame != nil {
-			// Match found! Add player 2 to the waiting game
-			waitingGame.AddPlayer2(player)
-			m.waitingPlayer = nil
-			
-			log.Printf("Matched players: %s vs %s in game %s", 
-				waitingGame.Player1.Username, player.Username, waitingGame.ID)
-			
-			// Send game start event to Kafka
-			m.sendKafkaEvent("game_started", map[string]interface{}{
-				"gameId":  waitingGame.ID,
-				"player1": waitingGame.Player1.Username,
-				"player2": waitingGame.Player2.Username,
-				"isBot":   false,
-			})
-			
-			// Notify WebSocket clients that game started
-			if m.onGameUpdate != nil {
-				m.onGameUpdate(waitingGame.ID, waitingGame)
-			}
-			
-			return waitingGame, player, false
-		}
-	}
+	// Start cleanup routine for old games
+	go manager.cleanupOldGames()
 
-	// Check if this player is already waiting (reconnection case)
-	if m.waitingPlayer != nil && m.waitingPlayer.Username == username {
-		// Find their existing waiting game
-		for _, game := range m.games {
-			if game.Status == "waiting" && game.Player1.Username == username {
-				return game, player, true
-			}
-		}
-		// If we can't find their game, clear the waiting player
-		m.waitingPlayer = nil
-	}
+	// Start pairing queued players
+	go manager.runMatchmaking()
 
-	// Create new game and wait for opponent
-	game := NewGame(player)
-	m.games[game.ID] = game
-	m.waitingPlayer = player
+	// Expire unused invite codes
+	go manager.expireRooms()
+	
+	return manager
+}
 
-	log.Printf("Player %s created new game %s and is waiting for opponent", username, game.ID)
+// Events returns the bus that game events are published on.
+func (m *Manager) Events() *Bus {
+	return m.events
+}
 
-	// Start timeout for bot opponent
-	go m.startBotTimeout(game.ID, username)
+func (m *Manager) SetQueueStatusCallback(callback func(gameID string, status QueueStatus)) {
+	m.onQueueStatus = callback
+}
 
-	return game, player, true
+// SetPlayerMatchedCallback is called when the matchmaker moves a queued
+// player from their own waiting game into their opponent's.
+func (m *Manager) SetPlayerMatchedCallback(callback func(previousGameID string, game *Game, player *Player)) {
+	m.onPlayerMatched = callback
 }
 
-func (m *Manager) startBotTimeout(gameID, username string) {
-	time.Sleep(10 * time.Second)
+// SetPresenceCallbacks tells the manager how to check whether a player has
+// a client in a game, and who to tell when a player's seat loses its last
+// client (with the time they must be back by) and when they return in time.
+func (m *Manager) SetPresenceCallbacks(connected func(gameID, username string) bool,
+	vacated func(gameID, username string, deadline time.Time), returned func(gameID, username string)) {
+	m.isConnected = connected
+	m.onSeatVacated = vacated
+	m.onSeatReturned = returned
+}
 
+// SetBotStrategy replaces the built-in bot, e.g. with an external engine.
+func (m *Manager) SetBotStrategy(strategy bot.Strategy) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
-
-	game, exists := m.games[gameID]
-	if !exists || game.Status != "waiting" {
-		return
-	}
-
-	// Add bot as player 2
-	botPlayer := &Player{
-		ID:       "bot",
-		Username: "Smart Bot",
-		IsBot:    true,
-	}
-
-	game.AddPlayer2(botPlayer)
-	m.waitingPlayer = nil
-
-	// Send game start event to Kafka
-	m.sendKafkaEvent("game_started", map[string]interface{}{
-		"gameId":  game.ID,
-		"player1": game.Player1.Username,
-		"player2": "Bot Luffy",
-		"isBot":   true,
-	})
-
-	// Notify WebSocket clients
-	if m.onGameUpdate != nil {
-		m.onGameUpdate(gameID, game)
-	}
-
-	log.Printf("Bot joined game %s with player %s", gameID, username)
+	m.bot = strategy
 }
 
 func (m *Manager) MakeMove(gameID string, column int, playerUsername string) (*Move, *Game, error) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
-
-	game, exists := m.games[gameID]
-	if !exists {
+	hosted := m.lookup(gameID)
+	if hosted == nil {
 		return nil, nil, ErrGameNotFound
 	}
 
+	hosted.mutex.Lock()
+	defer hosted.mutex.Unlock()
+	game := hosted.game
+
 	// Determine player number
 	var playerNum int
 	if game.Player1.Username == playerUsername {
@@ -197,84 +159,144 @@ func (m *Manager) MakeMove(gameID string, column int, playerUsername string) (*M
 		return nil, nil, ErrPlayerNotFound
 	}
 
+	// Time may have run out before the timer fired
+	if game.CurrentTurn == playerNum && m.timeOut(hosted, time.Now()) {
+		return nil, nil, ErrOutOfTime
+	}
+
 	move, err := game.MakeMove(column, playerNum)
 	if err != nil {
 		return nil, nil, err
 	}
+	m.runClock(hosted)
 
-	// Send move event to Kafka
-	m.sendKafkaEvent("move_made", map[string]interface{}{
-		"gameId":   gameID,
-		"player":   playerUsername,
-		"column":   column,
-		"row":      move.Row,
-		"isBot":    false,
-	})
+	snapshot := game.snapshot()
+	m.events.Publish(MoveMade{Game: snapshot, Move: move})
 
 	// If game finished, save to database
 	if game.Status == "finished" {
-		m.saveGameResult(game)
-		
-		m.sendKafkaEvent("game_finished", map[string]interface{}{
-			"gameId":   gameID,
-			"winner":   game.Winner,
-			"duration": time.Since(game.CreatedAt).Seconds(),
-		})
+		m.finishGame(hosted, "")
 	}
+	m.checkpoint(game)
 
-	return move, game, nil
+	return move, snapshot, nil
 }
 
 func (m *Manager) MakeBotMove(gameID string) (*Move, *Game, error) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
-
-	game, exists := m.games[gameID]
-	if !exists {
+	hosted := m.lookup(gameID)
+	if hosted == nil {
 		return nil, nil, ErrGameNotFound
 	}
 
+	hosted.mutex.Lock()
+	game := hosted.game
 	if !game.IsBot || game.CurrentTurn != PLAYER2 || game.Status != "playing" {
-		return nil, game, nil
+		snapshot := game.snapshot()
+		hosted.mutex.Unlock()
+		return nil, snapshot, nil
+	}
+
+	// Get bot move at the level chosen for this player. The search runs
+	// without the game's lock, so the position is checked again after.
+	position := game.snapshot()
+	strategy := m.botStrategy()
+	if session := m.botSession(hosted); session != nil {
+		strategy = session
+	}
+	hosted.mutex.Unlock()
+
+	column := strategy.BestMove(position.Board, position.Moves, PLAYER2, position.BotLevel)
+
+	hosted.mutex.Lock()
+	defer hosted.mutex.Unlock()
+
+	if game.Status != "playing" || len(game.Moves) != len(position.Moves) {
+		// The game ended or another bot move landed while searching
+		return nil, game.snapshot(), nil
+	}
+	if m.timeOut(hosted, time.Now()) {
+		return nil, game.snapshot(), nil
 	}
 
-	// Get player's consecutive wins for difficulty scaling
-	playerWins := m.playerWins[game.Player1.Username]
-	
-	// Get bot move with difficulty scaling
-	column := m.bot.GetBestMoveWithDifficulty(game.Board, PLAYER2, playerWins)
-	
 	move, err := game.MakeMove(column, PLAYER2)
 	if err != nil {
 		return nil, nil, err
 	}
+	m.runClock(hosted)
 
-	// Send bot move event to Kafka
-	m.sendKafkaEvent("move_made", map[string]interface{}{
-		"gameId":   gameID,
-		"player":   "Smart Bot",
-		"column":   column,
-		"row":      move.Row,
-		"isBot":    true,
-	})
+	// Think about the player's reply while they do
+	if session := hosted.botSession; session != nil && game.Status == "playing" {
+		session.Ponder(game.Board, PLAYER1, game.BotLevel)
+	}
+
+	snapshot := game.snapshot()
+	m.events.Publish(MoveMade{Game: snapshot, Move: move})
 
 	// If game finished, save to database
 	if game.Status == "finished" {
-		m.saveGameResult(game)
-		
-		m.sendKafkaEvent("game_finished", map[string]interface{}{
-			"gameId":   gameID,
-			"winner":   game.Winner,
-			"duration": time.Since(game.CreatedAt).Seconds(),
-		})
+		m.finishGame(hosted, "")
 	}
+	m.checkpoint(game)
 
-	return move, game, nil
+	return move, snapshot, nil
+}
+
+// finishGame releases what a game that has just finished held, and
+// announces the result. reason is set when an admin ended the game. Callers
+// must hold the game's mutex.
+func (m *Manager) finishGame(hosted *hostedGame, reason string) {
+	game := hosted.game
+	hosted.closeBotSession()
+	m.releaseInviteCode(game)
+	hosted.cancelDisconnectTimers()
+	hosted.stopClock()
+
+	m.events.Publish(GameFinished{
+		Game:     game.snapshot(),
+		Duration: time.Since(game.CreatedAt),
+		Reason:   reason,
+	})
+}
+
+func (m *Manager) botStrategy() bot.Strategy {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.bot
+}
+
+// botSession returns the search session for a bot game, creating it on
+// first use. It returns nil when the bot strategy cannot ponder. Callers
+// must hold the game's mutex.
+func (m *Manager) botSession(hosted *hostedGame) *bot.Session {
+	if hosted.botSession != nil {
+		return hosted.botSession
+	}
+
+	ponderer, ok := m.botStrategy().(bot.Ponderer)
+	if !ok {
+		return nil
+	}
+
+	hosted.botSession = ponderer.NewSession()
+	return hosted.botSession
+}
+
+// closeBotSession stops the game's bot search, if it has one. Callers must
+// hold mutex.
+func (h *hostedGame) closeBotSession() {
+	if h.botSession != nil {
+		h.botSession.Stop()
+		h.botSession = nil
+	}
 }
 
 func (m *Manager) JoinSpecificGame(username, gameID string) (*Game, *Player, error) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+	if m.IsBanned(username) {
+		return nil, nil, ErrBanned
+	}
+
+	m.queueMutex.Lock()
+	defer m.queueMutex.Unlock()
 
 	player := &Player{
 		ID:       username,
@@ -282,11 +304,15 @@ func (m *Manager) JoinSpecificGame(username, gameID string) (*Game, *Player, err
 		IsBot:    false,
 	}
 
-	game, exists := m.games[gameID]
-	if !exists {
+	hosted := m.lookup(gameID)
+	if hosted == nil {
 		return nil, nil, ErrGameNotFound
 	}
 
+	hosted.mutex.Lock()
+	defer hosted.mutex.Unlock()
+	game := hosted.game
+
 	// Check if game is waiting for a player
 	if game.Status != "waiting" {
 		return nil, nil, ErrGameNotActive
@@ -294,7 +320,11 @@ func (m *Manager) JoinSpecificGame(username, gameID string) (*Game, *Player, err
 
 	// Check if player is already in this game
 	if game.Player1.Username == username {
-		return game, player, nil
+		return game.snapshot(), player, nil
+	}
+
+	if game.Private {
+		return nil, nil, ErrPrivateGame
 	}
 
 	// Check if game already has 2 players
@@ -304,211 +334,122 @@ func (m *Manager) JoinSpecificGame(username, gameID string) (*Game, *Player, err
 
 	// Add player 2 to the game
 	game.AddPlayer2(player)
-	
-	// Clear waiting player if this was the waiting game
-	if m.waitingPlayer != nil && m.waitingPlayer.Username == game.Player1.Username {
-		m.waitingPlayer = nil
+	m.checkpoint(game)
+
+	// The host is no longer looking for an opponent
+	if m.cluster == nil {
+		m.removeQueueEntry(func(e *queueEntry) bool {
+			return e.gameID == game.ID
+		})
+	} else {
+		go m.dropQueueEntry(game.ID)
 	}
 
 	log.Printf("Player %s joined specific game %s with %s", username, gameID, game.Player1.Username)
 
-	// Send game start event to Kafka
-	m.sendKafkaEvent("game_started", map[string]interface{}{
-		"gameId":  game.ID,
-		"player1": game.Player1.Username,
-		"player2": game.Player2.Username,
-		"isBot":   false,
-	})
+	snapshot := game.snapshot()
+	m.events.Publish(PlayerJoined{Game: snapshot, Player: player})
 
-	// Notify WebSocket clients that game started
-	if m.onGameUpdate != nil {
-		m.onGameUpdate(game.ID, game)
+	return snapshot, player, nil
+}
+
+// SpectateGame returns a game for watching. Private games can only be
+// watched through their invite code.
+func (m *Manager) SpectateGame(gameID string) (*Game, error) {
+	hosted := m.lookup(gameID)
+	if hosted == nil {
+		return nil, ErrGameNotFound
 	}
 
-	return game, player, nil
+	hosted.mutex.Lock()
+	defer hosted.mutex.Unlock()
+
+	if hosted.game.Private {
+		return nil, ErrPrivateGame
+	}
+	return hosted.game.snapshot(), nil
 }
 
+// GetGame returns a copy of a game as it stands.
 func (m *Manager) GetGame(gameID string) (*Game, bool) {
+	hosted := m.lookup(gameID)
+	if hosted == nil {
+		return nil, false
+	}
+
+	hosted.mutex.Lock()
+	defer hosted.mutex.Unlock()
+	return hosted.game.snapshot(), true
+}
+
+// lookup returns the game with the given ID from the registry, or nil.
+func (m *Manager) lookup(gameID string) *hostedGame {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
-	
-	game, exists := m.games[gameID]
-	return game, exists
+	return m.games[gameID]
 }
 
-func (m *Manager) saveGameResult(game *Game) {
-	duration := time.Since(game.CreatedAt).Seconds()
-	
-	log.Printf("Saving game result - Winner: %d, Player1: %s, Player2: %s, Duration: %.2f", 
-		game.Winner, game.Player1.Username, 
-		func() string { if game.Player2 != nil { return game.Player2.Username } else { return "nil" } }(), 
-		duration)
-	
-	// Update in-memory leaderboard
-	m.updateLeaderboard(game, duration)
-	
-	// Also save to database if available
-	if m.db == nil {
-		return
-	}
-
-	var winner string
-	if game.Winner == PLAYER1 {
-		winner = game.Player1.Username
-	} else if game.Winner == PLAYER2 {
-		winner = game.Player2.Username
-	} else {
-		winner = "draw"
-	}
-
-	_, err := m.db.Exec(`
-		INSERT INTO games (id, player1, player2, winner, duration, is_bot, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-	`, game.ID, game.Player1.Username, game.Player2.Username, winner, 
-		duration, game.IsBot, game.CreatedAt)
+// host adds a new game to the registry.
+func (m *Manager) host(game *Game) *hostedGame {
+	hosted := newHostedGame(game)
+	m.mutex.Lock()
+	m.games[game.ID] = hosted
+	m.mutex.Unlock()
+	return hosted
+}
 
-	if err != nil {
-		log.Printf("Failed to save game result: %v", err)
-	}
+// unhost removes a game from the registry.
+func (m *Manager) unhost(gameID string) {
+	m.mutex.Lock()
+	delete(m.games, gameID)
+	m.mutex.Unlock()
 }
 
-func (m *Manager) updateLeaderboard(game *Game, duration float64) {
-	log.Printf("Updating leaderboard for %s - Winner: %d", game.Player1.Username, game.Winner)
-	
-	// Update player 1 stats (always human)
-	if stats, exists := m.leaderboard[game.Player1.Username]; exists {
-		stats.GamesPlayed++
-		stats.TotalTime += duration
-		if game.Winner == PLAYER1 {
-			stats.Wins++
-			// Track consecutive wins for difficulty scaling
-			m.playerWins[game.Player1.Username]++
-		} else {
-			// Reset consecutive wins on loss
-			m.playerWins[game.Player1.Username] = 0
-		}
-		if stats.BestTime == 0 || (game.Winner == PLAYER1 && duration < stats.BestTime) {
-			stats.BestTime = duration
-		}
-		stats.WinRate = float64(stats.Wins) / float64(stats.GamesPlayed) * 100
-	} else {
-		stats := &PlayerStats{
-			Username:    game.Player1.Username,
-			GamesPlayed: 1,
-			TotalTime:   duration,
-		}
-		if game.Winner == PLAYER1 {
-			stats.Wins = 1
-			stats.BestTime = duration
-			m.playerWins[game.Player1.Username] = 1
-		} else {
-			m.playerWins[game.Player1.Username] = 0
-		}
-		stats.WinRate = float64(stats.Wins) / float64(stats.GamesPlayed) * 100
-		m.leaderboard[game.Player1.Username] = stats
-	}
-	
-	// Update player 2 stats (only if human, not bot)
-	if game.Player2 != nil && !game.Player2.IsBot {
-		if stats, exists := m.leaderboard[game.Player2.Username]; exists {
-			stats.GamesPlayed++
-			stats.TotalTime += duration
-			if game.Winner == PLAYER2 {
-				stats.Wins++
-				m.playerWins[game.Player2.Username]++
-			} else {
-				m.playerWins[game.Player2.Username] = 0
-			}
-			if stats.BestTime == 0 || (game.Winner == PLAYER2 && duration < stats.BestTime) {
-				stats.BestTime = duration
-			}
-			stats.WinRate = float64(stats.Wins) / float64(stats.GamesPlayed) * 100
-		} else {
-			stats := &PlayerStats{
-				Username:    game.Player2.Username,
-				GamesPlayed: 1,
-				TotalTime:   duration,
-			}
-			if game.Winner == PLAYER2 {
-				stats.Wins = 1
-				stats.BestTime = duration
-				m.playerWins[game.Player2.Username] = 1
-			} else {
-				m.playerWins[game.Player2.Username] = 0
-			}
-			stats.WinRate = float64(stats.Wins) / float64(stats.GamesPlayed) * 100
-			m.leaderboard[game.Player2.Username] = stats
-		}
+// hostedGames returns every game in the registry, so they can be gone
+// through without holding mutex.
+func (m *Manager) hostedGames() []*hostedGame {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	games := make([]*hostedGame, 0, len(m.games))
+	for _, hosted := range m.games {
+		games = append(games, hosted)
 	}
+	return games
 }
 
-func (m *Manager) GetLeaderboard(w http.ResponseWriter, r *http.Request) {
+func (m *Manager) gameCount() int {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
+	return len(m.games)
+}
+
+func (m *Manager) saveGameResult(game *Game, duration float64) {
+	log.Printf("Saving game result - Winner: %d, Player1: %s, Player2: %s, Duration: %.2f", 
+		game.Winner, game.Player1.Username, 
+		func() string { if game.Player2 != nil { return game.Player2.Username } else { return "nil" } }(), 
+		duration)
 	
-	// Convert map to slice and sort
-	var leaderboard []*PlayerStats
-	for _, stats := range m.leaderboard {
-		leaderboard = append(leaderboard, stats)
-	}
-	
-	// Sort by best time (ascending) - fastest wins first
-	for i := 0; i < len(leaderboard)-1; i++ {
-		for j := i + 1; j < len(leaderboard); j++ {
-			// Sort by best time (ascending), with 0 times (no wins) at the end
-			if (leaderboard[i].BestTime == 0 && leaderboard[j].BestTime > 0) ||
-				(leaderboard[i].BestTime > 0 && leaderboard[j].BestTime > 0 && leaderboard[i].BestTime > leaderboard[j].BestTime) {
-				leaderboard[i], leaderboard[j] = leaderboard[j], leaderboard[i]
-			}
-		}
-	}
-	
-	// Limit to top 10
-	if len(leaderboard) > 10 {
-		leaderboard = leaderboard[:10]
+	if err := m.store.SaveGame(storedGame(game, duration)); err != nil {
+		log.Printf("Failed to save game result: %v", err)
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(leaderboard)
 }
 
 func (m *Manager) GetStats(w http.ResponseWriter, r *http.Request) {
-	if m.db == nil {
-		// Return basic stats when database is not available
-		stats := map[string]interface{}{
-			"totalGames":    0,
-			"botGames":      0,
-			"humanGames":    0,
-			"avgDuration":   0,
-			"activeGames":   len(m.games),
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(stats)
-		return
-	}
-
-	var totalGames, botGames int
-	var avgDuration float64
-
-	err := m.db.QueryRow(`
-		SELECT 
-			COUNT(*) as total_games,
-			SUM(CASE WHEN is_bot THEN 1 ELSE 0 END) as bot_games,
-			AVG(duration) as avg_duration
-		FROM games
-	`).Scan(&totalGames, &botGames, &avgDuration)
-
+	counts, err := m.store.GameStats()
 	if err != nil {
+		log.Printf("Failed to fetch stats: %v", err)
 		http.Error(w, "Failed to fetch stats", http.StatusInternalServerError)
 		return
 	}
 
 	stats := map[string]interface{}{
-		"totalGames":    totalGames,
-		"botGames":      botGames,
-		"humanGames":    totalGames - botGames,
-		"avgDuration":   avgDuration,
-		"activeGames":   len(m.games),
+		"totalGames":    counts.TotalGames,
+		"botGames":      counts.BotGames,
+		"humanGames":    counts.TotalGames - counts.BotGames,
+		"avgDuration":   counts.AvgDuration,
+		"activeGames":   m.gameCount(),
+		"matchmaking":   m.MatchmakingMetrics(),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -518,28 +459,33 @@ func (m *Manager) GetStats(w http.ResponseWriter, r *http.Request) {
 func (m *Manager) cleanupOldGames() {
 	ticker := time.NewTicker(5 * time.Minute)
 	defer ticker.Stop()
-	
+
 	for range ticker.C {
-		m.mutex.Lock()
 		now := time.Now()
-		
-		for gameID, game := range m.games {
+		var abandoned []string
+
+		for _, hosted := range m.hostedGames() {
+			hosted.mutex.Lock()
+			game := hosted.game
 			// Remove finished games older than 30 minutes
-			if game.Status == "finished" && now.Sub(game.LastMove) > 30*time.Minute {
-				delete(m.games, gameID)
-				log.Printf("Cleaned up finished game: %s", gameID)
+			if (game.Status == "finished" || game.Status == "aborted") && now.Sub(game.LastMove) > 30*time.Minute {
+				hosted.closeBotSession()
+				m.unhost(game.ID)
+				log.Printf("Cleaned up finished game: %s", game.ID)
 			}
 			// Remove waiting games older than 15 minutes (abandoned)
 			if game.Status == "waiting" && now.Sub(game.CreatedAt) > 15*time.Minute {
-				delete(m.games, gameID)
-				if m.waitingPlayer != nil && m.waitingPlayer.Username == game.Player1.Username {
-					m.waitingPlayer = nil
-				}
-				log.Printf("Cleaned up abandoned waiting game: %s", gameID)
+				m.unhost(game.ID)
+				abandoned = append(abandoned, game.ID)
+				log.Printf("Cleaned up abandoned waiting game: %s", game.ID)
 			}
+			hosted.mutex.Unlock()
+		}
+
+		// Nobody is looking for an opponent for them any more
+		for _, gameID := range abandoned {
+			m.dropQueueEntry(gameID)
 		}
-		
-		m.mutex.Unlock()
 	}
 }
 
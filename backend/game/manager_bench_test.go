@@ -0,0 +1,123 @@
+package game_test
+
+import (
+	"connect4-backend/game"
+	"connect4-backend/store"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowStore delays every write, standing in for a slow database.
+type slowStore struct {
+	store.Store
+	latency time.Duration
+}
+
+func (s slowStore) SaveGame(g store.Game) error {
+	time.Sleep(s.latency)
+	return s.Store.SaveGame(g)
+}
+
+func (s slowStore) SaveActiveGame(id string, state []byte) error {
+	time.Sleep(s.latency)
+	return s.Store.SaveActiveGame(id, state)
+}
+
+func (s slowStore) DeleteActiveGame(id string) error {
+	time.Sleep(s.latency)
+	return s.Store.DeleteActiveGame(id)
+}
+
+func (s slowStore) SaveRating(rating store.Rating, update store.RatingUpdate) error {
+	time.Sleep(s.latency)
+	return s.Store.SaveRating(rating, update)
+}
+
+// BenchmarkConcurrentGames plays private games in parallel, one move per
+// iteration, starting a new game whenever one finishes. Run it with
+// -cpu 1,2,4,8 to see how moves scale with the number of games in
+// progress. With a slow store the figures should barely change, since no
+// store I/O happens while a game is locked.
+func BenchmarkConcurrentGames(b *testing.B) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	b.Run("memory", func(b *testing.B) {
+		benchmarkGames(b, store.NewMemory())
+	})
+	b.Run("slow-store", func(b *testing.B) {
+		benchmarkGames(b, slowStore{Store: store.NewMemory(), latency: 20 * time.Millisecond})
+	})
+}
+
+func benchmarkGames(b *testing.B, st store.Store) {
+	manager := game.NewManager(st, nil, nil)
+	var games atomic.Int64
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var current *benchGame
+		for pb.Next() {
+			if current == nil {
+				var err error
+				if current, err = newBenchGame(manager, games.Add(1)); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+
+			finished, err := current.move()
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			if finished {
+				current = nil
+			}
+		}
+	})
+	b.ReportMetric(float64(games.Load()), "games")
+}
+
+// benchGame has two players fill the board column by column until the
+// game ends.
+type benchGame struct {
+	manager *game.Manager
+	id      string
+	players [2]string
+	made    int
+	column  int
+}
+
+func newBenchGame(manager *game.Manager, n int64) (*benchGame, error) {
+	host, guest := fmt.Sprintf("h%d", n), fmt.Sprintf("g%d", n)
+	created, _, err := manager.CreatePrivateGame(host, "", game.QueuePreferences{})
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := manager.JoinPrivateGame(guest, created.InviteCode, ""); err != nil {
+		return nil, err
+	}
+	return &benchGame{manager: manager, id: created.ID, players: [2]string{host, guest}}, nil
+}
+
+// move makes the next move and reports whether it ended the game.
+func (g *benchGame) move() (bool, error) {
+	for {
+		_, current, err := g.manager.MakeMove(g.id, g.column, g.players[g.made%2])
+		g.column = (g.column + 1) % 7
+		if err == game.ErrColumnFull {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		g.made++
+		return current.Status != "playing", nil
+	}
+}
@@ -0,0 +1,75 @@
+package game
+
+import (
+	"log"
+	"time"
+)
+
+// Match is a game set up between two chosen players, such as a tournament
+// or arena pairing, rather than found by the matchmaker.
+type Match struct {
+	Player1    string // Moves first
+	Player2    string
+	Prefs      QueuePreferences
+	Tournament string
+	Arena      string
+	ShowUp     time.Duration // How long the players have to take their seats; the grace period when zero
+}
+
+// CreateMatch starts a match straight away. Its players need not be
+// connected: like players who disconnected, each has until ShowUp is over
+// to take their seat, or forfeits. The game is hosted on this node.
+func (m *Manager) CreateMatch(match Match) (*Game, error) {
+	username1, err := m.Admit(match.Player1)
+	if err != nil {
+		return nil, err
+	}
+	username2, err := m.Admit(match.Player2)
+	if err != nil {
+		return nil, err
+	}
+	if username1 == username2 {
+		return nil, ErrSamePlayer
+	}
+
+	prefs, err := match.Prefs.Normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	player1 := &Player{ID: username1, Username: username1}
+	player2 := &Player{ID: username2, Username: username2}
+
+	game := NewGame(player1)
+	game.Variant = prefs.Variant
+	game.TimeControl = prefs.TimeControl
+	game.Rated = prefs.Rated
+	game.Tournament = match.Tournament
+	game.Arena = match.Arena
+	m.claimGameID(game)
+	m.events.Publish(GameCreated{Game: game.snapshot()})
+	hosted := m.host(game)
+
+	hosted.mutex.Lock()
+	game.AddPlayer2(player2)
+	m.checkpoint(game)
+	snapshot := game.snapshot()
+	m.events.Publish(PlayerJoined{Game: snapshot, Player: player2})
+	hosted.mutex.Unlock()
+
+	showUp := match.ShowUp
+	if showUp <= 0 {
+		showUp = m.gracePeriod
+	}
+	for _, username := range []string{username1, username2} {
+		m.awaitPlayer(game.ID, username, showUp)
+	}
+
+	log.Printf("Started match %s: %s vs %s", game.ID, username1, username2)
+	return snapshot, nil
+}
+
+// PlayerRatings returns the ratings of players, new players included.
+func (m *Manager) PlayerRatings(usernames []string) map[string]PlayerRating {
+	return m.ratings.Ratings(usernames)
+}
@@ -0,0 +1,650 @@
+package game
+
+import (
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	matchmakingInterval = time.Second
+	defaultMaxQueueWait = 10 * time.Second
+
+	// Players are paired when their ratings are within both players'
+	// windows. A window starts at ratingWindowStart and grows by
+	// ratingWindowGrowth for every second spent waiting.
+	ratingWindowStart  = 100.0
+	ratingWindowGrowth = 25.0
+
+	// Weight of the newest observation in a queue's running averages
+	metricsSmoothing = 0.2
+)
+
+var (
+	variants          = map[string]bool{"standard": true}
+	timeControlFormat = regexp.MustCompile(`^[1-9]\d{0,2}\+\d{1,2}$`)
+)
+
+// QueuePreferences decide which players can be paired: only players with the
+// same time control, variant and rated setting share a queue. TimeControl is
+// "unlimited" or "minutes+increment" such as "5+3", with the increment in
+// seconds; see Clock. HumanOnly players keep waiting instead of being given
+// a bot, and can be paired with anyone in their queue.
+type QueuePreferences struct {
+	TimeControl string `json:"timeControl"`
+	Variant     string `json:"variant"`
+	Rated       bool   `json:"rated"`
+	HumanOnly   bool   `json:"humanOnly"`
+}
+
+// QueueStatus tells a waiting player where they stand. EstimatedWait is in
+// seconds, or -1 when there is nothing to estimate from; RatingWindow is how far from their rating an opponent may be.
+type QueueStatus struct {
+	Position      int              `json:"position"`
+	QueueSize     int              `json:"queueSize"`
+	EstimatedWait float64          `json:"estimatedWait"`
+	RatingWindow  int              `json:"ratingWindow"`
+	Preferences   QueuePreferences `json:"preferences"`
+}
+
+// QueueMetrics summarise recent pairings in one queue. Averages are in
+// seconds and rating points and favour recent matches.
+type QueueMetrics struct {
+	Waiting             int     `json:"waiting"`
+	Matches             int     `json:"matches"`
+	BotMatches          int     `json:"botMatches"`
+	AverageWait         float64 `json:"averageWait"`
+	AverageRatingSpread float64 `json:"averageRatingSpread"`
+}
+
+type queueMetrics struct {
+	matches       int
+	botMatches    int
+	averageWait   time.Duration // Human matches only; feeds wait estimates
+	averageSpread float64
+}
+
+type queueEntry struct {
+	player     *Player
+	gameID     string
+	prefs      QueuePreferences
+	rating     float64
+	joinedAt   time.Time
+	lastStatus QueueStatus
+}
+
+// maxQueueWaitFromEnv reads MATCHMAKING_MAX_WAIT, the longest a player
+// searches for a human opponent before a bot is seated.
+func maxQueueWaitFromEnv() time.Duration {
+	value := os.Getenv("MATCHMAKING_MAX_WAIT")
+	if value == "" {
+		return defaultMaxQueueWait
+	}
+
+	wait, err := time.ParseDuration(value)
+	if err != nil || wait < 0 {
+		log.Printf("Ignoring invalid MATCHMAKING_MAX_WAIT %q", value)
+		return defaultMaxQueueWait
+	}
+	return wait
+}
+
+// RatingWindow is how far from their rating a player who has waited for an
+// opponent may be paired.
+func RatingWindow(waited time.Duration) float64 {
+	return ratingWindowStart + ratingWindowGrowth*waited.Seconds()
+}
+
+// Normalize fills in the default time control and variant, and checks both.
+func (p QueuePreferences) Normalize() (QueuePreferences, error) {
+	if p.TimeControl == "" {
+		p.TimeControl = "unlimited"
+	}
+	if p.Variant == "" {
+		p.Variant = "standard"
+	}
+
+	if p.TimeControl != "unlimited" && !timeControlFormat.MatchString(p.TimeControl) {
+		return p, ErrInvalidTimeControl
+	}
+	if !variants[p.Variant] {
+		return p, ErrUnknownVariant
+	}
+
+	return p, nil
+}
+
+func (p QueuePreferences) key() string {
+	rated := "casual"
+	if p.Rated {
+		rated = "rated"
+	}
+	return p.Variant + "/" + p.TimeControl + "/" + rated
+}
+
+// BotUsername is the name the bot plays under.
+const BotUsername = "Smart Bot"
+
+// reservedUsernames stand for something other than a player in recorded
+// games: results name the winner or "draw", tournaments and arenas mark
+// unplayed games "none", and analytics knows the bot as "Bot Luffy".
+var reservedUsernames = map[string]bool{
+	"draw":                       true,
+	"none":                       true,
+	strings.ToLower(BotUsername): true,
+	"bot luffy":                  true,
+}
+
+func cleanUsername(username string) (string, error) {
+	username = strings.TrimSpace(username)
+	if len(username) == 0 {
+		return "", ErrInvalidUsername
+	}
+	if len(username) > 20 {
+		username = strings.TrimSpace(username[:20])
+	}
+	if reservedUsernames[strings.ToLower(username)] {
+		return "", ErrReservedUsername
+	}
+	return username, nil
+}
+
+// Enqueue puts a player in the matchmaking queue. The player gets a waiting
+// game straight away, which friends can join by ID; the matchmaker seats an
+// opponent in it later. Calling Enqueue again while queued returns the same
+// game.
+func (m *Manager) Enqueue(username string, prefs QueuePreferences) (*Game, *Player, error) {
+	username, err := m.Admit(username)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	prefs, err = prefs.Normalize()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// May hit the database, so look it up before taking the queue lock
+	rating := m.ratings.Rating(username).Rating
+
+	if m.cluster != nil {
+		return m.enqueueInCluster(&Player{
+			ID:       username,
+			Username: username,
+			IsBot:    false,
+		}, prefs, rating)
+	}
+
+	m.queueMutex.Lock()
+	defer m.queueMutex.Unlock()
+
+	// Already queued (reconnection case)
+	for _, entry := range m.queue {
+		if entry.player.Username == username {
+			if game, exists := m.GetGame(entry.gameID); exists {
+				return game, entry.player, nil
+			}
+			// Its waiting game has been cleaned up
+			m.removeQueueEntry(func(e *queueEntry) bool {
+				return e == entry
+			})
+			break
+		}
+	}
+
+	player := &Player{
+		ID:       username,
+		Username: username,
+		IsBot:    false,
+	}
+
+	game := NewGame(player)
+	game.Variant = prefs.Variant
+	game.TimeControl = prefs.TimeControl
+	game.Rated = prefs.Rated
+
+	snapshot := game.snapshot()
+	m.events.Publish(GameCreated{Game: snapshot})
+	m.host(game)
+
+	m.queue = append(m.queue, &queueEntry{
+		player:   player,
+		gameID:   game.ID,
+		prefs:    prefs,
+		rating:   rating,
+		joinedAt: time.Now(),
+	})
+
+	log.Printf("Player %s (%.0f) queued for %s in game %s", username, rating, prefs.key(), game.ID)
+
+	return snapshot, snapshot.Player1, nil
+}
+
+// PlayBot starts a game against the bot straight away. A player who is
+// already queued gets the bot in their waiting game.
+func (m *Manager) PlayBot(username string, prefs QueuePreferences) (*Game, *Player, error) {
+	username, err := m.Admit(username)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	prefs, err = prefs.Normalize()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entry := m.dequeue(username)
+
+	var hosted *hostedGame
+	wait := time.Duration(0)
+	if entry != nil {
+		hosted = m.lookup(entry.gameID)
+		wait = time.Since(entry.joinedAt)
+	}
+
+	reused := false
+	if hosted != nil {
+		hosted.mutex.Lock()
+		if game := hosted.game; game.Status == "waiting" {
+			game.Variant = prefs.Variant
+			game.TimeControl = prefs.TimeControl
+			game.Rated = prefs.Rated
+			reused = true
+		}
+		hosted.mutex.Unlock()
+	}
+	if !reused {
+		player := &Player{
+			ID:       username,
+			Username: username,
+			IsBot:    false,
+		}
+		game := NewGame(player)
+		game.Variant = prefs.Variant
+		game.TimeControl = prefs.TimeControl
+		game.Rated = prefs.Rated
+		m.claimGameID(game)
+		m.events.Publish(GameCreated{Game: game.snapshot()})
+		hosted = m.host(game)
+	}
+
+	log.Printf("Player %s asked to play the bot", username)
+	m.seatBot(hosted, wait)
+
+	hosted.mutex.Lock()
+	snapshot := hosted.game.snapshot()
+	hosted.mutex.Unlock()
+
+	return snapshot, snapshot.Player1, nil
+}
+
+// LeaveQueue cancels a player's search and discards their waiting game.
+func (m *Manager) LeaveQueue(username string) error {
+	entry := m.dequeue(username)
+	if entry == nil {
+		return ErrNotInQueue
+	}
+	m.discardWaitingGame(entry.gameID)
+
+	log.Printf("Player %s left the queue", username)
+	return nil
+}
+
+// QueueStatus returns the status of a queued player.
+func (m *Manager) QueueStatus(username string) (QueueStatus, bool) {
+	if m.cluster == nil {
+		return m.localQueueStatus(username)
+	}
+
+	var reply queueStatusReply
+	if err := m.cluster.Call(m.queueNode(), "queue.status", username, &reply); err != nil {
+		log.Printf("Failed to fetch queue status of %s: %v", username, err)
+		return QueueStatus{}, false
+	}
+	return reply.Status, reply.Queued
+}
+
+// localQueueStatus returns the status of a player in the queue kept here.
+func (m *Manager) localQueueStatus(username string) (QueueStatus, bool) {
+	m.queueMutex.Lock()
+	defer m.queueMutex.Unlock()
+
+	now := time.Now()
+	for _, entry := range m.queue {
+		if entry.player.Username == username {
+			return m.statusOf(entry, now), true
+		}
+	}
+	return QueueStatus{}, false
+}
+
+// removeQueueEntry removes and returns the first entry matching match.
+// Callers must hold queueMutex.
+func (m *Manager) removeQueueEntry(match func(*queueEntry) bool) *queueEntry {
+	for i, entry := range m.queue {
+		if match(entry) {
+			m.queue = append(m.queue[:i], m.queue[i+1:]...)
+			return entry
+		}
+	}
+	return nil
+}
+
+func (m *Manager) runMatchmaking() {
+	ticker := time.NewTicker(matchmakingInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		m.matchPlayers()
+	}
+}
+
+type queueUpdate struct {
+	gameID string
+	status QueueStatus
+}
+
+type queuePair struct {
+	first, second *queueEntry
+	wait          time.Duration // How long first, the older entry, waited
+	spread        float64
+}
+
+// matchPlayers goes through the queue oldest first, pairing each player with
+// the closest-rated compatible player whose rating falls within both their
+// windows, and seats a bot for anyone who has waited the maximum time. Pairs
+// are picked under queueMutex only; games are updated afterwards so the
+// queue is never held while the game registry is busy.
+func (m *Manager) matchPlayers() {
+	now := time.Now()
+
+	m.queueMutex.Lock()
+	var pairs []queuePair
+	var botMatches []*queueEntry
+	matched := make(map[*queueEntry]bool)
+
+	for i, first := range m.queue {
+		if matched[first] {
+			continue
+		}
+
+		window := RatingWindow(now.Sub(first.joinedAt))
+		var best *queueEntry
+		bestSpread := 0.0
+		for _, second := range m.queue[i+1:] {
+			if matched[second] || second.prefs.key() != first.prefs.key() ||
+				second.player.Username == first.player.Username {
+				continue
+			}
+			spread := math.Abs(first.rating - second.rating)
+			if spread > window || spread > RatingWindow(now.Sub(second.joinedAt)) {
+				continue
+			}
+			if best == nil || spread < bestSpread {
+				best, bestSpread = second, spread
+			}
+		}
+
+		if best != nil {
+			pairs = append(pairs, queuePair{
+				first:  first,
+				second: best,
+				wait:   now.Sub(first.joinedAt),
+				spread: bestSpread,
+			})
+			matched[first], matched[best] = true, true
+		} else if !first.prefs.HumanOnly && now.Sub(first.joinedAt) >= m.maxQueueWait {
+			botMatches = append(botMatches, first)
+			matched[first] = true
+		}
+	}
+
+	remaining := m.queue[:0]
+	for _, entry := range m.queue {
+		if !matched[entry] {
+			remaining = append(remaining, entry)
+		}
+	}
+	m.queue = remaining
+
+	for _, pair := range pairs {
+		m.metricsFor(pair.first.prefs).recordMatch(pair, now)
+	}
+	for _, entry := range botMatches {
+		m.metricsFor(entry.prefs).botMatches++
+	}
+
+	var updates []queueUpdate
+	for _, entry := range m.queue {
+		status := m.statusOf(entry, now)
+		if status != entry.lastStatus {
+			entry.lastStatus = status
+			updates = append(updates, queueUpdate{gameID: entry.gameID, status: status})
+		}
+	}
+	m.queueMutex.Unlock()
+
+	for _, pair := range pairs {
+		m.startMatch(pair)
+	}
+	for _, entry := range botMatches {
+		m.seatQueuedBot(entry, now.Sub(entry.joinedAt))
+	}
+	for _, update := range updates {
+		m.sendQueueStatus(update.gameID, update.status)
+	}
+}
+
+// startMatch seats second in first's waiting game and retires second's own
+// waiting game. If either game was taken in the meantime (e.g. a friend
+// joined it by ID), the player still waiting goes back to the queue front.
+func (m *Manager) startMatch(pair queuePair) {
+	if m.cluster != nil {
+		m.startMatchAcross(pair)
+		return
+	}
+
+	first, second := pair.first, pair.second
+
+	hosted, secondHosted := m.lookup(first.gameID), m.lookup(second.gameID)
+	firstOK, secondOK := hosted != nil, secondHosted != nil
+
+	// Only the matchmaker holds two game locks at once, so the order
+	// between them does not matter
+	if firstOK {
+		hosted.mutex.Lock()
+		firstOK = hosted.game.Status == "waiting"
+	}
+	if secondOK {
+		secondHosted.mutex.Lock()
+		secondOK = secondHosted.game.Status == "waiting"
+	}
+	unlock := func() {
+		if hosted != nil {
+			hosted.mutex.Unlock()
+		}
+		if secondHosted != nil {
+			secondHosted.mutex.Unlock()
+		}
+	}
+
+	if !firstOK || !secondOK {
+		unlock()
+		m.queueMutex.Lock()
+		if firstOK {
+			m.queue = append([]*queueEntry{first}, m.queue...)
+		}
+		if secondOK {
+			m.queue = append([]*queueEntry{second}, m.queue...)
+		}
+		m.queueMutex.Unlock()
+		return
+	}
+
+	game := hosted.game
+	game.AddPlayer2(second.player)
+	m.unhost(second.gameID)
+	m.checkpoint(game)
+	snapshot := game.snapshot()
+	unlock()
+
+	log.Printf("Matched players: %s vs %s in game %s (rating spread %.0f)",
+		snapshot.Player1.Username, snapshot.Player2.Username, snapshot.ID, pair.spread)
+
+	// Move the second player's clients over, then notify everyone
+	if m.onPlayerMatched != nil {
+		m.onPlayerMatched(second.gameID, snapshot, second.player)
+	}
+	m.events.Publish(PlayerJoined{
+		Game:         snapshot,
+		Player:       second.player,
+		Queue:        first.prefs.key(),
+		Wait:         pair.wait,
+		RatingSpread: pair.spread,
+	})
+}
+
+// seatBot gives a waiting game a bot opponent at the player's level.
+func (m *Manager) seatBot(hosted *hostedGame, wait time.Duration) {
+	hosted.mutex.Lock()
+	username := hosted.game.Player1.Username
+	hosted.mutex.Unlock()
+
+	// May hit the store, so look it up before taking the game's lock
+	level := m.ratings.BotLevel(username)
+
+	hosted.mutex.Lock()
+	game := hosted.game
+	if game.Status != "waiting" {
+		hosted.mutex.Unlock()
+		return
+	}
+
+	// Add bot as player 2
+	botPlayer := &Player{
+		ID:       "bot",
+		Username: BotUsername,
+		IsBot:    true,
+	}
+
+	game.AddPlayer2(botPlayer)
+	game.BotLevel = level
+	m.checkpoint(game)
+	m.events.Publish(PlayerJoined{
+		Game:   game.snapshot(),
+		Player: botPlayer,
+		Queue:  QueuePreferences{TimeControl: game.TimeControl, Variant: game.Variant, Rated: game.Rated}.key(),
+		Wait:   wait,
+	})
+	hosted.mutex.Unlock()
+
+	log.Printf("Bot joined game %s with player %s at level %d", game.ID, username, level)
+}
+
+// statusOf works out an entry's position among players with the same
+// preferences and how long it is likely to wait. Callers must hold
+// queueMutex.
+func (m *Manager) statusOf(entry *queueEntry, now time.Time) QueueStatus {
+	status := QueueStatus{Preferences: entry.prefs}
+	for _, other := range m.queue {
+		if other.prefs.key() != entry.prefs.key() {
+			continue
+		}
+		status.QueueSize++
+		if other == entry {
+			status.Position = status.QueueSize
+		}
+	}
+
+	waited := now.Sub(entry.joinedAt)
+	metrics, known := m.queueMetrics[entry.prefs.key()]
+	known = known && metrics.matches > 0
+
+	if entry.prefs.HumanOnly && !known {
+		// No bot to bound the wait and no history to go on
+		status.EstimatedWait = -1
+	} else {
+		// A bot joins once the maximum wait is up, so that bounds the
+		// wait unless the player only wants a human
+		var estimate time.Duration
+		if entry.prefs.HumanOnly || (known && metrics.averageWait < m.maxQueueWait) {
+			estimate = metrics.averageWait - waited
+		} else {
+			estimate = m.maxQueueWait - waited
+		}
+		if estimate < 0 {
+			estimate = 0
+		}
+		status.EstimatedWait = estimate.Round(time.Second).Seconds()
+	}
+	status.RatingWindow = int(RatingWindow(waited))
+
+	return status
+}
+
+// MatchmakingMetrics returns wait time and rating spread figures for every
+// queue that has seen players.
+func (m *Manager) MatchmakingMetrics() map[string]QueueMetrics {
+	if m.cluster == nil {
+		return m.localMatchmakingMetrics()
+	}
+
+	result := make(map[string]QueueMetrics)
+	if err := m.cluster.Call(m.queueNode(), "queue.metrics", nil, &result); err != nil {
+		log.Printf("Failed to fetch matchmaking metrics: %v", err)
+	}
+	return result
+}
+
+func (m *Manager) localMatchmakingMetrics() map[string]QueueMetrics {
+	m.queueMutex.Lock()
+	defer m.queueMutex.Unlock()
+
+	result := make(map[string]QueueMetrics)
+	for key, metrics := range m.queueMetrics {
+		result[key] = QueueMetrics{
+			Matches:             metrics.matches,
+			BotMatches:          metrics.botMatches,
+			AverageWait:         metrics.averageWait.Seconds(),
+			AverageRatingSpread: metrics.averageSpread,
+		}
+	}
+	for _, entry := range m.queue {
+		key := entry.prefs.key()
+		metrics := result[key]
+		metrics.Waiting++
+		result[key] = metrics
+	}
+	return result
+}
+
+// metricsFor returns the metrics of a queue, creating them on first use.
+// Callers must hold queueMutex.
+func (m *Manager) metricsFor(prefs QueuePreferences) *queueMetrics {
+	key := prefs.key()
+	metrics, exists := m.queueMetrics[key]
+	if !exists {
+		metrics = &queueMetrics{}
+		m.queueMetrics[key] = metrics
+	}
+	return metrics
+}
+
+// recordMatch folds both players' waits and their rating spread into the
+// queue's running averages.
+func (q *queueMetrics) recordMatch(pair queuePair, now time.Time) {
+	if q.matches == 0 {
+		q.averageWait = now.Sub(pair.second.joinedAt)
+		q.averageSpread = pair.spread
+	} else {
+		q.averageSpread += metricsSmoothing * (pair.spread - q.averageSpread)
+	}
+	for _, entry := range []*queueEntry{pair.first, pair.second} {
+		wait := now.Sub(entry.joinedAt)
+		q.averageWait += time.Duration(metricsSmoothing * float64(wait-q.averageWait))
+	}
+	q.matches++
+}
@@ -0,0 +1,253 @@
+package game
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	recentGamesLimit     = 10
+	headToHeadGamesLimit = 20
+)
+
+// Record is a player's results over a set of games.
+type Record struct {
+	Games   int     `json:"games"`
+	Wins    int     `json:"wins"`
+	Losses  int     `json:"losses"`
+	Draws   int     `json:"draws"`
+	WinRate float64 `json:"winRate"`
+}
+
+func (r *Record) add(result string) {
+	r.Games++
+	switch result {
+	case "win":
+		r.Wins++
+	case "loss":
+		r.Losses++
+	default:
+		r.Draws++
+	}
+	r.WinRate = float64(r.Wins) / float64(r.Games) * 100
+}
+
+// PlayerGame is a finished game from one player's point of view.
+type PlayerGame struct {
+	ID          string  `json:"id"`
+	Opponent    string  `json:"opponent"`
+	VsBot       bool    `json:"vsBot"`
+	Result      string  `json:"result"` // "win", "loss" or "draw"
+	EndReason   string  `json:"endReason,omitempty"`
+	Variant     string  `json:"variant"`
+	TimeControl string  `json:"timeControl"`
+	Rated       bool    `json:"rated"`
+	Moves       int     `json:"moves"`
+	Duration    float64 `json:"duration"`
+	PlayedAt    int64   `json:"playedAt"` // Unix milliseconds
+}
+
+func newPlayerGame(r *GameRecord, username string) PlayerGame {
+	game := PlayerGame{
+		ID:          r.ID,
+		Opponent:    r.Player2,
+		VsBot:       r.IsBot,
+		Result:      "draw",
+		EndReason:   r.EndReason,
+		Variant:     r.Variant,
+		TimeControl: r.TimeControl,
+		Rated:       r.Rated,
+		Moves:       len(r.Moves),
+		Duration:    r.Duration,
+		PlayedAt:    r.CreatedAt.UnixMilli(),
+	}
+	if r.Player2 == username {
+		game.Opponent = r.Player1
+	}
+	if r.Winner == username {
+		game.Result = "win"
+	} else if r.Winner == game.Opponent {
+		game.Result = "loss"
+	}
+	return game
+}
+
+// Opening is the column a player most often opens with.
+type Opening struct {
+	Column int `json:"column"`
+	Games  int `json:"games"`
+}
+
+// PlayerProfile summarises a player's rating and game history.
+type PlayerProfile struct {
+	Username        string            `json:"username"`
+	Rating          float64           `json:"rating"`
+	Deviation       float64           `json:"deviation"`
+	Provisional     bool              `json:"provisional"`
+	Record          Record            `json:"record"`
+	Modes           map[string]Record `json:"modes"`    // "human" and "bot"
+	Variants        map[string]Record `json:"variants"` // By variant
+	CurrentStreak   int               `json:"currentStreak"`
+	BestStreak      int               `json:"bestStreak"`
+	FavoriteOpening *Opening          `json:"favoriteOpening"`
+	FastestWin      float64           `json:"fastestWin,omitempty"`
+	RecentGames     []PlayerGame      `json:"recentGames"` // Newest first
+}
+
+// HeadToHead is the history between two players, from Player's point of
+// view.
+type HeadToHead struct {
+	Player   string       `json:"player"`
+	Opponent string       `json:"opponent"`
+	Record   Record       `json:"record"`
+	Games    []PlayerGame `json:"games"` // Newest first
+}
+
+// PlayerProfile builds username's profile from their recorded games.
+func (m *Manager) PlayerProfile(username string) (*PlayerProfile, error) {
+	username, err := cleanUsername(username)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := m.loadResults(resultFilter{Player: username})
+	if err != nil {
+		return nil, err
+	}
+
+	rating := m.ratings.Rating(username)
+	if len(results) == 0 && rating.Games == 0 {
+		return nil, ErrPlayerNotFound
+	}
+
+	profile := &PlayerProfile{
+		Username:    username,
+		Rating:      math.Round(rating.Rating),
+		Deviation:   math.Round(rating.Deviation),
+		Provisional: rating.Provisional(),
+		Modes:       map[string]Record{"human": {}, "bot": {}},
+		Variants:    make(map[string]Record),
+		RecentGames: []PlayerGame{},
+	}
+
+	openings := make(map[int]int)
+	for i := range results {
+		game := newPlayerGame(&results[i], username)
+
+		profile.Record.add(game.Result)
+		mode := "human"
+		if game.VsBot {
+			mode = "bot"
+		}
+		record := profile.Modes[mode]
+		record.add(game.Result)
+		profile.Modes[mode] = record
+		record = profile.Variants[game.Variant]
+		record.add(game.Result)
+		profile.Variants[game.Variant] = record
+
+		if game.Result == "win" {
+			profile.CurrentStreak++
+			if profile.CurrentStreak > profile.BestStreak {
+				profile.BestStreak = profile.CurrentStreak
+			}
+			if profile.FastestWin == 0 || game.Duration < profile.FastestWin {
+				profile.FastestWin = game.Duration
+			}
+		} else {
+			profile.CurrentStreak = 0
+		}
+
+		// The player's first move is the first or second of the game
+		first := 0
+		if results[i].Player2 == username {
+			first = 1
+		}
+		if len(results[i].Moves) > first {
+			openings[results[i].Moves[first]]++
+		}
+	}
+
+	for column, games := range openings {
+		favorite := profile.FavoriteOpening
+		if favorite == nil || games > favorite.Games || games == favorite.Games && column < favorite.Column {
+			profile.FavoriteOpening = &Opening{Column: column, Games: games}
+		}
+	}
+
+	profile.RecentGames = recentGames(results, username, recentGamesLimit)
+	return profile, nil
+}
+
+// HeadToHead returns the games played between player and opponent.
+func (m *Manager) HeadToHead(player, opponent string) (*HeadToHead, error) {
+	player, err := cleanUsername(player)
+	if err != nil {
+		return nil, err
+	}
+	opponent, err = cleanUsername(opponent)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := m.loadResults(resultFilter{Player: player, Opponent: opponent})
+	if err != nil {
+		return nil, err
+	}
+
+	h2h := &HeadToHead{Player: player, Opponent: opponent}
+	for i := range results {
+		h2h.Record.add(newPlayerGame(&results[i], player).Result)
+	}
+	h2h.Games = recentGames(results, player, headToHeadGamesLimit)
+	return h2h, nil
+}
+
+// recentGames returns up to limit of the newest results, which must be
+// oldest first, from username's point of view.
+func recentGames(results []GameRecord, username string, limit int) []PlayerGame {
+	games := []PlayerGame{}
+	for i := len(results) - 1; i >= 0 && len(games) < limit; i-- {
+		games = append(games, newPlayerGame(&results[i], username))
+	}
+	return games
+}
+
+// GetPlayer serves /api/players/{username}.
+func (m *Manager) GetPlayer(w http.ResponseWriter, r *http.Request) {
+	profile, err := m.PlayerProfile(mux.Vars(r)["username"])
+	if err == ErrPlayerNotFound || err == ErrInvalidUsername || err == ErrReservedUsername {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to fetch player profile: %v", err)
+		http.Error(w, "Failed to fetch player", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+// GetHeadToHead serves /api/players/{username}/vs/{opponent}.
+func (m *Manager) GetHeadToHead(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	h2h, err := m.HeadToHead(vars["username"], vars["opponent"])
+	if err == ErrInvalidUsername || err == ErrReservedUsername {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to fetch head-to-head record: %v", err)
+		http.Error(w, "Failed to fetch head-to-head record", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h2h)
+}
@@ -0,0 +1,174 @@
+package game
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+const defaultGracePeriod = 30 * time.Second
+
+// gracePeriodFromEnv reads DISCONNECT_GRACE_PERIOD, how long a disconnected
+// player has to come back before losing the game.
+func gracePeriodFromEnv() time.Duration {
+	value := os.Getenv("DISCONNECT_GRACE_PERIOD")
+	if value == "" {
+		return defaultGracePeriod
+	}
+
+	period, err := time.ParseDuration(value)
+	if err != nil || period <= 0 {
+		log.Printf("Ignoring invalid DISCONNECT_GRACE_PERIOD %q", value)
+		return defaultGracePeriod
+	}
+	return period
+}
+
+// SeatVacated is called when the last client of a player in a game goes
+// away. If the game is being played, the player has the grace period to
+// come back before they forfeit.
+func (m *Manager) SeatVacated(gameID, username string) {
+	m.awaitPlayer(gameID, username, m.gracePeriod)
+}
+
+// awaitPlayer gives a player who is not in a game being played until
+// within to take their seat, or forfeit.
+func (m *Manager) awaitPlayer(gameID, username string, within time.Duration) {
+	hosted := m.lookup(gameID)
+	if hosted == nil {
+		return
+	}
+
+	hosted.mutex.Lock()
+	game := hosted.game
+	if game.Status != "playing" || playerNumber(game, username) == 0 {
+		hosted.mutex.Unlock()
+		return
+	}
+
+	if _, pending := hosted.disconnects[username]; pending {
+		hosted.mutex.Unlock()
+		return
+	}
+
+	deadline := time.Now().Add(within)
+	hosted.disconnects[username] = time.AfterFunc(within, func() {
+		m.forfeitAbsentPlayer(gameID, username)
+	})
+	hosted.mutex.Unlock()
+
+	log.Printf("Player %s is not in game %s, forfeiting in %v unless they return", username, gameID, within)
+
+	if m.onSeatVacated != nil {
+		m.onSeatVacated(gameID, username, deadline)
+	}
+}
+
+// SeatOccupied is called when a player has a client in a game again. It
+// cancels their forfeit countdown, if one is running.
+func (m *Manager) SeatOccupied(gameID, username string) {
+	hosted := m.lookup(gameID)
+	if hosted == nil {
+		return
+	}
+
+	hosted.mutex.Lock()
+	timer, pending := hosted.disconnects[username]
+	if pending {
+		timer.Stop()
+		delete(hosted.disconnects, username)
+	}
+	hosted.mutex.Unlock()
+
+	if !pending {
+		return
+	}
+
+	log.Printf("Player %s returned to game %s", username, gameID)
+
+	if m.onSeatReturned != nil {
+		m.onSeatReturned(gameID, username)
+	}
+}
+
+// forfeitAbsentPlayer ends a game whose player did not return in time. A
+// game without moves is aborted instead, since nobody has played yet.
+func (m *Manager) forfeitAbsentPlayer(gameID, username string) {
+	// A reconnect can race with the disconnect that started the countdown
+	if m.isConnected != nil && m.isConnected(gameID, username) {
+		m.SeatOccupied(gameID, username)
+		return
+	}
+
+	hosted := m.lookup(gameID)
+	if hosted == nil {
+		return
+	}
+
+	hosted.mutex.Lock()
+	defer hosted.mutex.Unlock()
+
+	if _, pending := hosted.disconnects[username]; !pending {
+		// Cancelled while the timer was firing
+		return
+	}
+	delete(hosted.disconnects, username)
+
+	game := hosted.game
+	player := playerNumber(game, username)
+	if game.Status != "playing" || player == 0 {
+		return
+	}
+
+	game.LastMove = time.Now()
+	if len(game.Moves) == 0 {
+		game.Status = "aborted"
+		game.EndReason = "abandoned"
+		hosted.closeBotSession()
+		m.releaseInviteCode(game)
+		hosted.cancelDisconnectTimers()
+		hosted.stopClock()
+
+		m.events.Publish(GameAborted{Game: game.snapshot(), Player: username})
+		log.Printf("Aborted game %s: %s never came back", gameID, username)
+	} else {
+		game.Status = "finished"
+		game.Winner = PLAYER1
+		if player == PLAYER1 {
+			game.Winner = PLAYER2
+		}
+		game.EndReason = "disconnect"
+		m.finishGame(hosted, "")
+		log.Printf("Player %s forfeited game %s by disconnecting", username, gameID)
+	}
+	m.checkpoint(game)
+}
+
+// cancelDisconnectTimers stops every forfeit countdown in a game that has
+// ended. Callers must hold mutex.
+func (h *hostedGame) cancelDisconnectTimers() {
+	for username, timer := range h.disconnects {
+		timer.Stop()
+		delete(h.disconnects, username)
+	}
+}
+
+// seatedPlayers returns the usernames of the humans in a game.
+func seatedPlayers(game *Game) []string {
+	usernames := []string{game.Player1.Username}
+	if game.Player2 != nil && !game.Player2.IsBot {
+		usernames = append(usernames, game.Player2.Username)
+	}
+	return usernames
+}
+
+// playerNumber returns PLAYER1 or PLAYER2 for a human in the game, or 0.
+func playerNumber(game *Game, username string) int {
+	if game.Player1.Username == username {
+		return PLAYER1
+	}
+	if game.Player2 != nil && !game.Player2.IsBot && game.Player2.Username == username {
+		return PLAYER2
+	}
+	return 0
+}
@@ -0,0 +1,341 @@
+package game
+
+import (
+	"connect4-backend/bot"
+	"connect4-backend/store"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Glicko-2 constants. Ratings share the scale of the bot level calibration,
+// so new players start at initialRating rather than the customary 1500.
+const (
+	initialRating        = 1000.0
+	glickoScale          = 173.7178
+	initialDeviation     = 350.0
+	initialVolatility    = 0.06
+	glickoTau            = 0.5
+	glickoEpsilon        = 0.000001
+	ratingPeriod         = 24 * time.Hour
+	botRatingDeviation   = 30.0
+	provisionalDeviation = 110.0
+
+	defaultTargetWinRate = 0.5
+)
+
+// PlayerRating is a player's Glicko-2 rating. Deviation is the uncertainty
+// in Rating; it shrinks as the player plays and grows while they are away.
+type PlayerRating struct {
+	Username   string    `json:"username"`
+	Rating     float64   `json:"rating"`
+	Deviation  float64   `json:"deviation"`
+	Volatility float64   `json:"volatility"`
+	Games      int       `json:"games"`
+	LastPlayed time.Time `json:"lastPlayed"`
+}
+
+// Provisional reports whether too little is known about the player for the
+// rating to be trusted.
+func (r *PlayerRating) Provisional() bool {
+	return r.Deviation > provisionalDeviation
+}
+
+// RatingChange is how a game moved a player's rating.
+type RatingChange struct {
+	Before    float64 `json:"before"`
+	After     float64 `json:"after"`
+	Change    float64 `json:"change"`
+	Deviation float64 `json:"deviation"`
+}
+
+// RatingTracker keeps players' Glicko-2 ratings, updated after every rated
+// game and stored with a per-game history, and picks the bot level to play
+// each player at.
+// Every game is rated as soon as it ends, as a rating period of its own;
+// deviation also grows with the number of ratingPeriods a player has been
+// inactive.
+type RatingTracker struct {
+	mutex         sync.Mutex
+	store         store.Store
+	ratings       map[string]*PlayerRating
+	targetWinRate float64
+}
+
+func NewRatingTracker(st store.Store) *RatingTracker {
+	return &RatingTracker{
+		store:         st,
+		ratings:       make(map[string]*PlayerRating),
+		targetWinRate: targetWinRateFromEnv(),
+	}
+}
+
+// targetWinRateFromEnv reads BOT_TARGET_WIN_RATE, the share of games
+// players are expected to win against the bot.
+func targetWinRateFromEnv() float64 {
+	value := os.Getenv("BOT_TARGET_WIN_RATE")
+	if value == "" {
+		return defaultTargetWinRate
+	}
+
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate <= 0 || rate >= 1 {
+		log.Printf("Ignoring invalid BOT_TARGET_WIN_RATE %q", value)
+		return defaultTargetWinRate
+	}
+	return rate
+}
+
+// BotLevel returns the bot level to use against username: the one whose
+// calibrated rating the player is expected to score targetWinRate against.
+func (t *RatingTracker) BotLevel(username string) int {
+	t.mutex.Lock()
+	rating := t.get(username).Rating
+	t.mutex.Unlock()
+
+	return bot.LevelForRating(rating + 400*math.Log10((1-t.targetWinRate)/t.targetWinRate))
+}
+
+// Rating returns a copy of username's current rating.
+func (t *RatingTracker) Rating(username string) PlayerRating {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return *t.get(username)
+}
+
+// Ratings returns copies of several players' current ratings, loading the
+// ones not yet cached in a single query.
+func (t *RatingTracker) Ratings(usernames []string) map[string]PlayerRating {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.preload(usernames)
+
+	ratings := make(map[string]PlayerRating, len(usernames))
+	for _, username := range usernames {
+		ratings[username] = *t.get(username)
+	}
+	return ratings
+}
+
+// Forget drops cached ratings, so they are read from the store again after
+// another node has changed them.
+func (t *RatingTracker) Forget(usernames []string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, username := range usernames {
+		delete(t.ratings, username)
+	}
+}
+
+// RecordResult updates the ratings of the human players in a finished rated
+// game and returns the changes by username. Bots are fixed-rating opponents
+// at their level's calibrated rating.
+func (t *RatingTracker) RecordResult(game *Game) map[string]RatingChange {
+	if !ratesGame(game) {
+		return nil
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	score1 := 0.5
+	if game.Winner == PLAYER1 {
+		score1 = 1
+	} else if game.Winner == PLAYER2 {
+		score1 = 0
+	}
+
+	now := time.Now()
+	player1 := t.get(game.Player1.Username)
+	inflate(player1, now)
+
+	changes := make(map[string]RatingChange)
+	if game.Player2.IsBot {
+		botRating := PlayerRating{
+			Rating:    bot.LevelRatings[game.BotLevel],
+			Deviation: botRatingDeviation,
+		}
+		changes[player1.Username] = t.apply(game, player1, botRating, score1, now)
+		return changes
+	}
+
+	// Both players are rated against their opponent's rating before the game
+	player2 := t.get(game.Player2.Username)
+	inflate(player2, now)
+	before1, before2 := *player1, *player2
+	changes[player1.Username] = t.apply(game, player1, before2, score1, now)
+	changes[player2.Username] = t.apply(game, player2, before1, 1-score1, now)
+	return changes
+}
+
+// ratesGame reports whether a finished game changes its players' ratings.
+func ratesGame(game *Game) bool {
+	return game.Rated && game.Player2 != nil
+}
+
+// apply rates player on one game against opponent and records the result.
+func (t *RatingTracker) apply(game *Game, player *PlayerRating, opponent PlayerRating, score float64, now time.Time) RatingChange {
+	before := *player
+	player.Rating, player.Deviation, player.Volatility = glicko2(
+		player.Rating, player.Deviation, player.Volatility,
+		[]glickoResult{{opponent.Rating, opponent.Deviation, score}})
+	player.Games++
+	player.LastPlayed = now
+
+	update := store.RatingUpdate{
+		GameID:          game.ID,
+		Username:        player.Username,
+		RatingBefore:    before.Rating,
+		RatingAfter:     player.Rating,
+		DeviationBefore: before.Deviation,
+		DeviationAfter:  player.Deviation,
+		Volatility:      player.Volatility,
+		OpponentRating:  opponent.Rating,
+		Score:           score,
+		CreatedAt:       now,
+	}
+	if err := t.store.SaveRating(store.Rating(*player), update); err != nil {
+		log.Printf("Failed to save rating for %s: %v", player.Username, err)
+	}
+
+	return newRatingChange(update)
+}
+
+// newRatingChange describes a rating update in whole points.
+func newRatingChange(update store.RatingUpdate) RatingChange {
+	return RatingChange{
+		Before:    math.Round(update.RatingBefore),
+		After:     math.Round(update.RatingAfter),
+		Change:    math.Round(update.RatingAfter) - math.Round(update.RatingBefore),
+		Deviation: math.Round(update.DeviationAfter),
+	}
+}
+
+// inflate grows a player's deviation for every rating period they sat out,
+// as Glicko-2 does for players with no games in a period.
+func inflate(player *PlayerRating, now time.Time) {
+	if player.LastPlayed.IsZero() {
+		return
+	}
+
+	periods := math.Floor(now.Sub(player.LastPlayed).Hours() / ratingPeriod.Hours())
+	if periods < 1 {
+		return
+	}
+
+	phi := player.Deviation / glickoScale
+	phi = math.Sqrt(phi*phi + periods*player.Volatility*player.Volatility)
+	player.Deviation = math.Min(phi*glickoScale, initialDeviation)
+}
+
+// glickoResult is one game of a rating period: the opponent's rating and
+// deviation, and the score (1 win, 0.5 draw, 0 loss).
+type glickoResult struct {
+	rating, deviation, score float64
+}
+
+// glicko2 returns a player's new rating, deviation and volatility after a
+// rating period with the given games, following Glickman's "Example of the
+// Glicko-2 system".
+func glicko2(rating, deviation, volatility float64, results []glickoResult) (float64, float64, float64) {
+	mu := (rating - initialRating) / glickoScale
+	phi := deviation / glickoScale
+
+	var vInverse, improvement float64
+	for _, r := range results {
+		muJ := (r.rating - initialRating) / glickoScale
+		phiJ := r.deviation / glickoScale
+		g := 1 / math.Sqrt(1+3*phiJ*phiJ/(math.Pi*math.Pi))
+		expected := 1 / (1 + math.Exp(-g*(mu-muJ)))
+		vInverse += g * g * expected * (1 - expected)
+		improvement += g * (r.score - expected)
+	}
+	v := 1 / vInverse
+	delta := v * improvement
+
+	// New volatility by the Illinois algorithm
+	a := math.Log(volatility * volatility)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		return ex*(delta*delta-phi*phi-v-ex)/(2*math.Pow(phi*phi+v+ex, 2)) - (x-a)/(glickoTau*glickoTau)
+	}
+
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*glickoTau) < 0 {
+			k++
+		}
+		B = a - k*glickoTau
+	}
+
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > glickoEpsilon {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+	newVolatility := math.Exp(A / 2)
+
+	phiStar := math.Sqrt(phi*phi + newVolatility*newVolatility)
+	newPhi := 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	newMu := mu + newPhi*newPhi*improvement
+
+	return newMu*glickoScale + initialRating, newPhi * glickoScale, newVolatility
+}
+
+func (t *RatingTracker) get(username string) *PlayerRating {
+	if rating, exists := t.ratings[username]; exists {
+		return rating
+	}
+
+	t.preload([]string{username})
+	return t.ratings[username]
+}
+
+// preload caches the stored ratings of the given players, and initial
+// ratings for those who have none. Callers must hold mutex.
+func (t *RatingTracker) preload(usernames []string) {
+	var missing []string
+	for _, username := range usernames {
+		if _, exists := t.ratings[username]; !exists {
+			missing = append(missing, username)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	ratings, err := t.store.Ratings(missing)
+	if err != nil {
+		log.Printf("Failed to load ratings: %v", err)
+	}
+
+	for _, username := range missing {
+		if rating, exists := ratings[username]; exists {
+			stored := PlayerRating(rating)
+			t.ratings[username] = &stored
+			continue
+		}
+		t.ratings[username] = &PlayerRating{
+			Username:   username,
+			Rating:     initialRating,
+			Deviation:  initialDeviation,
+			Volatility: initialVolatility,
+		}
+	}
+}
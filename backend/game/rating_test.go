@@ -0,0 +1,48 @@
+package game
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGlicko2(t *testing.T) {
+	tests := []struct {
+		name                                      string
+		rating, deviation, volatility             float64
+		results                                   []glickoResult
+		wantRating, wantDeviation, wantVolatility float64
+	}{
+		{
+			// Glickman, "Example of the Glicko-2 system"
+			name:   "worked example",
+			rating: 1500, deviation: 200, volatility: 0.06,
+			results: []glickoResult{
+				{1400, 30, 1},
+				{1550, 100, 0},
+				{1700, 300, 0},
+			},
+			wantRating: 1464.06, wantDeviation: 151.52, wantVolatility: 0.05999,
+		},
+		{
+			name:   "draw against an equal opponent",
+			rating: 1200, deviation: 50, volatility: 0.06,
+			results:    []glickoResult{{1200, 50, 0.5}},
+			wantRating: 1200, wantDeviation: 50.54, wantVolatility: 0.06,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rating, deviation, volatility := glicko2(tt.rating, tt.deviation, tt.volatility, tt.results)
+			if math.Abs(rating-tt.wantRating) > 0.01 {
+				t.Errorf("rating = %.4f, want %.2f", rating, tt.wantRating)
+			}
+			if math.Abs(deviation-tt.wantDeviation) > 0.01 {
+				t.Errorf("deviation = %.4f, want %.2f", deviation, tt.wantDeviation)
+			}
+			if math.Abs(volatility-tt.wantVolatility) > 0.00001 {
+				t.Errorf("volatility = %.6f, want %.5f", volatility, tt.wantVolatility)
+			}
+		})
+	}
+}
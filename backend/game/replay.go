@@ -0,0 +1,45 @@
+package game
+
+// Position reconstructs the game as it stood after its first ply moves,
+// along with the move that led there (nil at the start). After the last
+// move the game carries the recorded result, including games that ended
+// off the board, such as by disconnection.
+func (r *GameRecord) Position(ply int) (*Game, *Move, error) {
+	if ply < 0 || ply > len(r.Moves) {
+		return nil, nil, ErrInvalidPly
+	}
+
+	g := NewGame(&Player{ID: r.Player1, Username: r.Player1})
+	g.ID = r.ID
+	g.CreatedAt = r.CreatedAt
+	g.Variant = r.Variant
+	g.TimeControl = r.TimeControl
+	g.Rated = r.Rated
+	g.AddPlayer2(&Player{ID: r.Player2, Username: r.Player2, IsBot: r.IsBot})
+	g.Clock = nil // Clock times are not recorded
+
+	var move *Move
+	for _, column := range r.Moves[:ply] {
+		var err error
+		move, err = g.MakeMove(column, g.CurrentTurn)
+		if err != nil {
+			return nil, nil, ErrCorruptRecord
+		}
+	}
+	g.LastMove = r.CreatedAt
+
+	if ply == len(r.Moves) {
+		g.Status = "finished"
+		g.EndReason = r.EndReason
+		g.RatingChanges = r.RatingChanges
+		switch r.Winner {
+		case r.Player1:
+			g.Winner = PLAYER1
+		case r.Player2:
+			g.Winner = PLAYER2
+		default:
+			g.Winner = 0
+		}
+	}
+	return g, move, nil
+}
@@ -0,0 +1,248 @@
+package game
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	inviteCodeLength   = 5
+	privateRoomTimeout = 10 * time.Minute
+	roomSweepInterval  = 30 * time.Second
+
+	// No 0/O, 1/I/L or 5/S, so codes survive being read out loud
+	inviteCodeAlphabet = "ABCDEFGHJKMNPQRTUVWXYZ2346789"
+)
+
+// privateRoom is the invite for a private game. The code stays valid until
+// the game finishes or, if nobody joins, until privateRoomTimeout.
+type privateRoom struct {
+	code         string
+	gameID       string
+	salt         []byte
+	passwordHash []byte // nil when the room has no password
+	expiresAt    time.Time
+}
+
+// CreatePrivateGame creates a game that only players with its invite code
+// (and password, if one is set) can join. Private games never enter the
+// matchmaking queue, so they are not paired with strangers or bots.
+func (m *Manager) CreatePrivateGame(username, password string, prefs QueuePreferences) (*Game, *Player, error) {
+	username, err := m.Admit(username)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	prefs, err = prefs.Normalize()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	player := &Player{
+		ID:       username,
+		Username: username,
+		IsBot:    false,
+	}
+
+	game := NewGame(player)
+	game.Variant = prefs.Variant
+	game.TimeControl = prefs.TimeControl
+	game.Rated = prefs.Rated
+	game.Private = true
+	m.claimGameID(game)
+
+	room := &privateRoom{
+		gameID:    game.ID,
+		expiresAt: time.Now().Add(privateRoomTimeout),
+	}
+	if password != "" {
+		room.salt = make([]byte, 16)
+		if _, err := rand.Read(room.salt); err != nil {
+			return nil, nil, err
+		}
+		room.passwordHash = hashPassword(room.salt, password)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for {
+		room.code, err = newInviteCode()
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, taken := m.rooms[room.code]; !taken && m.ownsInviteCode(room.code) {
+			break
+		}
+	}
+
+	game.InviteCode = room.code
+	m.games[game.ID] = newHostedGame(game)
+	m.rooms[room.code] = room
+	snapshot := game.snapshot()
+	m.events.Publish(GameCreated{Game: snapshot})
+
+	log.Printf("Player %s created private game %s with code %s", username, game.ID, room.code)
+
+	return snapshot, snapshot.Player1, nil
+}
+
+// JoinPrivateGame seats a player in the private game behind code. The host
+// can use it to get back into their own game.
+func (m *Manager) JoinPrivateGame(username, code, password string) (*Game, *Player, error) {
+	username, err := m.Admit(username)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hosted, err := m.openRoom(code, password)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hosted.mutex.Lock()
+	defer hosted.mutex.Unlock()
+	game := hosted.game
+
+	// Rejoining players keep their seat
+	if game.Player1.Username == username {
+		snapshot := game.snapshot()
+		return snapshot, snapshot.Player1, nil
+	}
+	if game.Player2 != nil && game.Player2.Username == username {
+		snapshot := game.snapshot()
+		return snapshot, snapshot.Player2, nil
+	}
+	if game.Status != "waiting" {
+		return nil, nil, ErrGameFull
+	}
+
+	player := &Player{
+		ID:       username,
+		Username: username,
+		IsBot:    false,
+	}
+	game.AddPlayer2(player)
+	m.checkpoint(game)
+
+	log.Printf("Player %s joined private game %s with %s", username, game.ID, game.Player1.Username)
+
+	snapshot := game.snapshot()
+	m.events.Publish(PlayerJoined{Game: snapshot, Player: player})
+
+	return snapshot, player, nil
+}
+
+// SpectatePrivateGame returns the private game behind code for watching.
+func (m *Manager) SpectatePrivateGame(code, password string) (*Game, error) {
+	hosted, err := m.openRoom(code, password)
+	if err != nil {
+		return nil, err
+	}
+
+	hosted.mutex.Lock()
+	defer hosted.mutex.Unlock()
+	return hosted.game.snapshot(), nil
+}
+
+// openRoom finds the game behind an invite code and checks its password.
+func (m *Manager) openRoom(code, password string) (*hostedGame, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	room, exists := m.rooms[strings.ToUpper(strings.TrimSpace(code))]
+	if !exists {
+		return nil, ErrInviteNotFound
+	}
+	hosted, exists := m.games[room.gameID]
+	if !exists {
+		return nil, ErrInviteNotFound
+	}
+
+	if room.passwordHash != nil &&
+		subtle.ConstantTimeCompare(room.passwordHash, hashPassword(room.salt, password)) != 1 {
+		return nil, ErrWrongPassword
+	}
+	return hosted, nil
+}
+
+// releaseInviteCode frees the code of a private game that has ended.
+func (m *Manager) releaseInviteCode(game *Game) {
+	if game.InviteCode == "" {
+		return
+	}
+
+	m.mutex.Lock()
+	delete(m.rooms, game.InviteCode)
+	m.mutex.Unlock()
+}
+
+// expireRooms drops invite codes whose game ended or disappeared, and
+// closes private games nobody joined in time.
+func (m *Manager) expireRooms() {
+	ticker := time.NewTicker(roomSweepInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		m.sweepRooms(time.Now())
+	}
+}
+
+func (m *Manager) sweepRooms(now time.Time) {
+	type roomGame struct {
+		room   *privateRoom
+		hosted *hostedGame
+	}
+
+	m.mutex.Lock()
+	var rooms []roomGame
+	for code, room := range m.rooms {
+		hosted, exists := m.games[room.gameID]
+		if !exists {
+			delete(m.rooms, code)
+			continue
+		}
+		rooms = append(rooms, roomGame{room: room, hosted: hosted})
+	}
+	m.mutex.Unlock()
+
+	// Game locks come before mutex, so each game is checked on its own
+	for _, entry := range rooms {
+		entry.hosted.mutex.Lock()
+		game := entry.hosted.game
+		switch {
+		case game.Status == "finished" || game.Status == "aborted":
+			m.releaseInviteCode(game)
+		case game.Status == "waiting" && now.After(entry.room.expiresAt):
+			m.releaseInviteCode(game)
+			m.unhost(game.ID)
+			game.Status = "expired"
+			m.events.Publish(GameExpired{Game: game.snapshot()})
+			log.Printf("Private game %s expired with code %s unused", game.ID, entry.room.code)
+		}
+		entry.hosted.mutex.Unlock()
+	}
+}
+
+func newInviteCode() (string, error) {
+	max := big.NewInt(int64(len(inviteCodeAlphabet)))
+	code := make([]byte, inviteCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = inviteCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+func hashPassword(salt []byte, password string) []byte {
+	sum := sha256.Sum256(append(append([]byte{}, salt...), password...))
+	return sum[:]
+}
@@ -0,0 +1,120 @@
+package game
+
+import "time"
+
+// The manager's own event subscribers. Each runs on its own goroutine, so
+// slow database or Kafka writes do not hold up games.
+
+// recordResult saves finished games.
+func (m *Manager) recordResult(event Event) {
+	if finished, ok := event.(GameFinished); ok {
+		m.saveGameResult(finished.Game, finished.Duration.Seconds())
+		m.leaderboards.clear()
+		m.forgetPlayers(finished.Game)
+	}
+}
+
+// rateGame updates the players' ratings after a rated game and announces
+// the changes.
+func (m *Manager) rateGame(event Event) {
+	finished, ok := event.(GameFinished)
+	if !ok {
+		return
+	}
+
+	changes := m.ratings.RecordResult(finished.Game)
+	if changes == nil {
+		return
+	}
+	m.leaderboards.clear()
+	m.forgetPlayers(finished.Game)
+
+	// Players who come back to the game see how it moved their rating
+	game := finished.Game
+	if hosted := m.lookup(game.ID); hosted != nil {
+		hosted.mutex.Lock()
+		defer hosted.mutex.Unlock()
+		hosted.game.RatingChanges = changes
+		game = hosted.game.snapshot()
+	} else {
+		game.RatingChanges = changes
+	}
+	m.events.Publish(RatingsUpdated{Game: game, Changes: changes, Duration: finished.Duration})
+}
+
+// sendEventToKafka passes game events on to analytics.
+func (m *Manager) sendEventToKafka(event Event) {
+	switch e := event.(type) {
+	case PlayerJoined:
+		data := map[string]interface{}{
+			"gameId":  e.Game.ID,
+			"player1": e.Game.Player1.Username,
+			"player2": e.Game.Player2.Username,
+			"isBot":   e.Game.IsBot,
+		}
+		if e.Game.IsBot {
+			// Analytics has always known the bot by this name
+			data["player2"] = "Bot Luffy"
+			data["botLevel"] = e.Game.BotLevel
+		}
+		if e.Game.Private {
+			data["private"] = true
+		}
+		if e.Queue != "" {
+			data["queue"] = e.Queue
+			data["waitTime"] = e.Wait.Seconds()
+			if !e.Game.IsBot {
+				data["ratingSpread"] = e.RatingSpread
+			}
+		}
+		m.sendKafkaEvent("game_started", data)
+
+	case MoveMade:
+		player := e.Game.Player1
+		if e.Move.Player == PLAYER2 {
+			player = e.Game.Player2
+		}
+		m.sendKafkaEvent("move_made", map[string]interface{}{
+			"gameId": e.Game.ID,
+			"player": player.Username,
+			"column": e.Move.Column,
+			"row":    e.Move.Row,
+			"isBot":  player.IsBot,
+		})
+
+	case GameFinished:
+		// Rated games are reported once their rating changes are known
+		if !ratesGame(e.Game) {
+			m.sendKafkaEvent("game_finished", gameFinishedData(e.Game, e.Duration))
+		}
+
+	case GameAborted:
+		m.sendKafkaEvent("game_aborted", map[string]interface{}{
+			"gameId": e.Game.ID,
+			"player": e.Player,
+			"reason": e.Game.EndReason,
+		})
+
+	case RatingsUpdated:
+		data := gameFinishedData(e.Game, e.Duration)
+		data["ratingChanges"] = e.Changes
+		m.sendKafkaEvent("game_finished", data)
+		m.sendKafkaEvent("ratings_updated", map[string]interface{}{
+			"gameId":        e.Game.ID,
+			"ratingChanges": e.Changes,
+		})
+	}
+}
+
+func gameFinishedData(game *Game, duration time.Duration) map[string]interface{} {
+	data := map[string]interface{}{
+		"gameId":   game.ID,
+		"winner":   game.Winner,
+		"duration": duration.Seconds(),
+		"rated":    game.Rated,
+	}
+	if game.EndReason != "" {
+		data["reason"] = game.EndReason
+	}
+	return data
+}
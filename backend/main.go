@@ -1,13 +1,19 @@
 package main
 
 import (
-	"connect4-backend/database"
+	"connect4-backend/admin"
+	"connect4-backend/arena"
+	"connect4-backend/bot"
+	"connect4-backend/cluster"
 	"connect4-backend/game"
 	"connect4-backend/kafka"
+	"connect4-backend/store"
+	"connect4-backend/tournament"
 	"connect4-backend/websocket"
 	"context"
 	"encoding/json"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -20,21 +26,20 @@ import (
 func main() {
 	log.Println("Starting 4-in-a-Row Game Server...")
 
-	// Initialize database with retry logic
-	var db *database.DB
-	var err error
-	
-	db, err = database.Initialize()
+	// Open the store: Postgres, SQLite or in memory
+	st, err := store.Open()
+	persistent := err == nil
 	if err != nil {
-		log.Printf("Warning: Database unavailable: %v", err)
-		log.Println("Continuing without database (leaderboard will be disabled)")
-		db = nil
-	} else if db != nil {
-		defer db.Close()
-		log.Println("Database connected successfully")
+		log.Printf("Warning: Store unavailable: %v", err)
+		log.Println("Continuing with an in-memory store (nothing will be saved)")
+		st = store.NewMemory()
 	} else {
-		log.Println("No database configured (running in simple mode)")
+		if _, inMemory := st.(*store.Memory); inMemory {
+			persistent = false
+		}
+		log.Printf("Using %s store", st)
 	}
+	defer st.Close()
 
 	// Initialize Kafka with retry logic
 	var kafkaProducer *kafka.Producer
@@ -48,15 +53,54 @@ func main() {
 		log.Println("Kafka connected successfully")
 	}
 
+	// Join the cluster if one is configured
+	node, err := cluster.FromEnv()
+	if err != nil {
+		log.Fatalf("Invalid cluster configuration: %v", err)
+	}
+	if node != nil {
+		log.Printf("Running as node %s of %d", node.Self().ID, len(node.Nodes()))
+	}
+
 	// Initialize game manager
-	gameManager := game.NewManager(db, kafkaProducer)
+	gameManager := game.NewManager(st, kafkaProducer, node)
 	log.Println("Game manager initialized")
 
+	// Load the bot persona's evaluation weights if one is configured
+	builtinBot := bot.NewBot()
+	weights, err := bot.LoadWeightsFromEnv()
+	if err != nil {
+		log.Printf("Warning: Bot weights unavailable: %v", err)
+		log.Println("Continuing with default weights")
+	} else if weights != nil {
+		builtinBot = bot.NewBotWithWeights(weights)
+		gameManager.SetBotStrategy(builtinBot)
+		log.Printf("Loaded bot weights %q", weights.Name)
+	}
+
+	// Use an external engine for the bot if one is configured
+	engine, err := bot.NewEngineFromEnv(builtinBot)
+	if err != nil {
+		log.Printf("Warning: External engine unavailable: %v", err)
+		log.Println("Continuing with built-in bot")
+	} else if engine != nil {
+		defer engine.Close()
+		gameManager.SetBotStrategy(engine)
+	}
+
 	// Initialize WebSocket hub
 	hub := websocket.NewHub(gameManager)
 	go hub.Run()
 	log.Println("WebSocket hub started")
 
+	// Run tournaments, telling players over the WebSocket when their games are ready
+	director := tournament.NewDirector(gameManager, st)
+	hub.SetDirector(director)
+
+	// Run arenas, pushing their players new games and leaderboards the same way
+	arenas := arena.NewDirector(gameManager, st)
+	hub.SetArenas(arenas)
+
 	// Setup routes
 	router := mux.NewRouter()
 	
@@ -66,11 +110,14 @@ func main() {
 			"status":    "healthy",
 			"timestamp": time.Now().Unix(),
 			"services": map[string]bool{
-				"database": db != nil,
+				"database": persistent,
 				"kafka":    kafkaProducer != nil,
 				"websocket": true,
 			},
 		}
+		if node != nil {
+			health["node"] = node.Self().ID
+		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(health)
 	}).Methods("GET")
@@ -103,10 +150,42 @@ func main() {
 
 	// WebSocket endpoint
 	router.HandleFunc("/ws", hub.HandleWebSocket).Methods("GET")
+
 	
 	// API endpoints
 	router.HandleFunc("/api/leaderboard", gameManager.GetLeaderboard).Methods("GET")
 	router.HandleFunc("/api/stats", gameManager.GetStats).Methods("GET")
+	router.HandleFunc("/api/games", gameManager.GetGames).Methods("GET")
+	router.HandleFunc("/api/games/{id}", gameManager.GetGameRecord).Methods("GET")
+	router.HandleFunc("/api/players/{username}", gameManager.GetPlayer).Methods("GET")
+	router.HandleFunc("/api/players/{username}/vs/{opponent}", gameManager.GetHeadToHead).Methods("GET")
+	router.HandleFunc("/api/tournaments", director.GetTournaments).Methods("GET")
+	router.HandleFunc("/api/tournaments/{id}", director.GetTournament).Methods("GET")
+	router.HandleFunc("/api/tournaments/{id}/standings", director.GetStandings).Methods("GET")
+	router.HandleFunc("/api/tournaments/{id}/players", director.RegisterPlayer).Methods("POST")
+	router.HandleFunc("/api/tournaments/{id}/players/{username}", director.WithdrawPlayer).Methods("DELETE")
+	router.HandleFunc("/api/arenas", arenas.GetArenas).Methods("GET")
+	router.HandleFunc("/api/arenas/{id}", arenas.GetArena).Methods("GET")
+	router.HandleFunc("/api/arenas/{id}/players", arenas.JoinPlayer).Methods("POST")
+	router.HandleFunc("/api/arenas/{id}/players/{username}", arenas.LeavePlayer).Methods("DELETE")
+
+	// Admin API, when admins are configured
+	adminTokens, err := admin.TokensFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid admin configuration: %v", err)
+	}
+	trustedProxies, err := admin.TrustedProxiesFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid admin configuration: %v", err)
+	}
+	if adminTokens != nil {
+		adminAPI := admin.New(gameManager, hub, director, arenas, st, adminTokens)
+		adminAPI.SetTrustedProxies(trustedProxies)
+		adminAPI.Register(router)
+		log.Printf("Admin API enabled for %d admins", len(adminTokens))
+	} else {
+		log.Println("Admin API disabled: ADMIN_TOKENS is not set")
+	}
 
 	// Serve the game HTML file - try multiple paths
 	gamePaths := []string{
@@ -622,6 +701,28 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// Calls between cluster nodes are served on their own listener, kept
+	// off the public router
+	var internal *http.Server
+	if node != nil {
+		if _, clusterPort, _ := net.SplitHostPort(node.ListenAddr()); clusterPort == port {
+			log.Fatalf("CLUSTER_LISTEN must not be the public port %s", port)
+		}
+		internal = &http.Server{
+			Addr:         node.ListenAddr(),
+			Handler:      node,
+			ReadTimeout:  15 * time.Second,
+			WriteTimeout: 15 * time.Second,
+			IdleTimeout:  60 * time.Second,
+		}
+		go func() {
+			log.Printf("Cluster calls served on %s", node.ListenAddr())
+			if err := internal.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Cluster listener failed to start: %v", err)
+			}
+		}()
+	}
+
 	// Start server in goroutine
 	go func() {
 		log.Printf("Server starting on http://localhost:%s", port)
@@ -649,6 +750,11 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		log.Printf("Server shutdown error: %v", err)
 	}
+	if internal != nil {
+		if err := internal.Shutdown(ctx); err != nil {
+			log.Printf("Cluster listener shutdown error: %v", err)
+		}
+	}
 
 	// Close Kafka producer
 	if kafkaProducer != nil {
@@ -0,0 +1,251 @@
+package store
+
+import "sync"
+
+// Memory keeps everything in process memory, so it is lost on restart.
+type Memory struct {
+	mutex       sync.RWMutex
+	games       []Game // Newest first
+	active      map[string][]byte
+	ratings     map[string]Rating
+	updates     []RatingUpdate
+	bans        map[string]Ban
+	audit       []AuditEntry // Oldest first
+	tournaments map[string][]byte
+	arenas      map[string][]byte
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		active:      make(map[string][]byte),
+		ratings:     make(map[string]Rating),
+		bans:        make(map[string]Ban),
+		tournaments: make(map[string][]byte),
+		arenas:      make(map[string][]byte),
+	}
+}
+
+func (s *Memory) String() string { return "memory" }
+
+func (s *Memory) SaveGame(game Game) error {
+	game.Moves = append([]int{}, game.Moves...)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Games mostly finish in the order they started, so the new game
+	// usually goes at or near the front
+	cursor := Cursor{CreatedAt: game.CreatedAt, ID: game.ID}
+	i := 0
+	for i < len(s.games) && !cursor.follows(&s.games[i]) {
+		i++
+	}
+	s.games = append(s.games, Game{})
+	copy(s.games[i+1:], s.games[i:])
+	s.games[i] = game
+	return nil
+}
+
+func (s *Memory) Game(id string) (Game, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, game := range s.games {
+		if game.ID == id {
+			game.Moves = append([]int{}, game.Moves...)
+			return game, nil
+		}
+	}
+	return Game{}, ErrNotFound
+}
+
+func (s *Memory) Games(filter GameFilter) ([]Game, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var games []Game
+	for i := range s.games {
+		if filter.Limit > 0 && len(games) == filter.Limit {
+			break
+		}
+		if filter.Matches(&s.games[i]) {
+			game := s.games[i]
+			game.Moves = append([]int{}, game.Moves...)
+			games = append(games, game)
+		}
+	}
+	return games, nil
+}
+
+func (s *Memory) GameStats() (GameStats, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var stats GameStats
+	for _, game := range s.games {
+		stats.TotalGames++
+		if game.IsBot {
+			stats.BotGames++
+		}
+		stats.AvgDuration += game.Duration
+	}
+	if stats.TotalGames > 0 {
+		stats.AvgDuration /= float64(stats.TotalGames)
+	}
+	return stats, nil
+}
+
+func (s *Memory) SaveActiveGame(id string, state []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.active[id] = append([]byte(nil), state...)
+	return nil
+}
+
+func (s *Memory) DeleteActiveGame(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.active, id)
+	return nil
+}
+
+func (s *Memory) ActiveGames() ([][]byte, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	states := make([][]byte, 0, len(s.active))
+	for _, state := range s.active {
+		states = append(states, append([]byte(nil), state...))
+	}
+	return states, nil
+}
+
+func (s *Memory) Ratings(usernames []string) (map[string]Rating, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	ratings := make(map[string]Rating)
+	for _, username := range usernames {
+		if rating, exists := s.ratings[username]; exists {
+			ratings[username] = rating
+		}
+	}
+	return ratings, nil
+}
+
+func (s *Memory) SaveRating(rating Rating, update RatingUpdate) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.ratings[rating.Username] = rating
+	s.updates = append(s.updates, update)
+	return nil
+}
+
+func (s *Memory) RatingUpdates(gameID string) ([]RatingUpdate, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var updates []RatingUpdate
+	for _, update := range s.updates {
+		if update.GameID == gameID {
+			updates = append(updates, update)
+		}
+	}
+	return updates, nil
+}
+
+func (s *Memory) Bans() ([]Ban, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	bans := make([]Ban, 0, len(s.bans))
+	for _, ban := range s.bans {
+		bans = append(bans, ban)
+	}
+	return bans, nil
+}
+
+func (s *Memory) SaveBan(ban Ban) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.bans[ban.Username] = ban
+	return nil
+}
+
+func (s *Memory) DeleteBan(username string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.bans, username)
+	return nil
+}
+
+func (s *Memory) SaveTournament(id string, state []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.tournaments[id] = append([]byte(nil), state...)
+	return nil
+}
+
+func (s *Memory) Tournaments() ([][]byte, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	states := make([][]byte, 0, len(s.tournaments))
+	for _, state := range s.tournaments {
+		states = append(states, append([]byte(nil), state...))
+	}
+	return states, nil
+}
+
+func (s *Memory) SaveArena(id string, state []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.arenas[id] = append([]byte(nil), state...)
+	return nil
+}
+
+func (s *Memory) Arenas() ([][]byte, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	states := make([][]byte, 0, len(s.arenas))
+	for _, state := range s.arenas {
+		states = append(states, append([]byte(nil), state...))
+	}
+	return states, nil
+}
+
+func (s *Memory) SaveAuditEntry(entry AuditEntry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.audit = append(s.audit, entry)
+	return nil
+}
+
+func (s *Memory) AuditLog(filter AuditFilter) ([]AuditEntry, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var entries []AuditEntry
+	for i := len(s.audit) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(entries) == filter.Limit {
+			break
+		}
+		if filter.Matches(&s.audit[i]) {
+			entries = append(entries, s.audit[i])
+		}
+	}
+	return entries, nil
+}
+
+func (s *Memory) Close() error {
+	return nil
+}
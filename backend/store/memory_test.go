@@ -0,0 +1,12 @@
+package store_test
+
+import (
+	"testing"
+
+	"connect4-backend/store"
+	"connect4-backend/store/storetest"
+)
+
+func TestMemory(t *testing.T) {
+	storetest.Run(t, func() store.Store { return store.NewMemory() })
+}
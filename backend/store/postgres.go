@@ -0,0 +1,490 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Postgres keeps everything in a PostgreSQL database shared by every
+// instance.
+type Postgres struct {
+	db *sql.DB
+}
+
+// OpenPostgres connects to the database at url and creates any missing
+// tables.
+func OpenPostgres(url string) (*Postgres, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+
+	// Configure connection pool
+	db.SetMaxOpenConns(25)
+	db.SetMaxIdleConns(5)
+	db.SetConnMaxLifetime(5 * time.Minute)
+
+	// Test connection with timeout
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &Postgres{db: db}
+	if err := s.createTables(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	log.Println("✅ Database initialized successfully")
+	return s, nil
+}
+
+func (s *Postgres) String() string { return "postgres" }
+
+func (s *Postgres) createTables() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS games (
+		id VARCHAR(255) PRIMARY KEY,
+		player1 VARCHAR(255) NOT NULL,
+		player2 VARCHAR(255) NOT NULL,
+		winner VARCHAR(255),
+		duration FLOAT,
+		is_bot BOOLEAN DEFAULT FALSE,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	ALTER TABLE games ADD COLUMN IF NOT EXISTS variant VARCHAR(32) NOT NULL DEFAULT 'standard';
+	ALTER TABLE games ADD COLUMN IF NOT EXISTS time_control VARCHAR(32) NOT NULL DEFAULT 'unlimited';
+	ALTER TABLE games ADD COLUMN IF NOT EXISTS rated BOOLEAN NOT NULL DEFAULT TRUE;
+	ALTER TABLE games ADD COLUMN IF NOT EXISTS moves TEXT NOT NULL DEFAULT '[]';
+	ALTER TABLE games ADD COLUMN IF NOT EXISTS end_reason VARCHAR(32) NOT NULL DEFAULT '';
+
+	CREATE INDEX IF NOT EXISTS idx_games_winner ON games(winner);
+	CREATE INDEX IF NOT EXISTS idx_games_created_at ON games(created_at);
+	CREATE INDEX IF NOT EXISTS idx_games_player1 ON games(player1);
+	CREATE INDEX IF NOT EXISTS idx_games_player2 ON games(player2);
+
+	CREATE TABLE IF NOT EXISTS player_ratings (
+		username VARCHAR(255) PRIMARY KEY,
+		rating FLOAT NOT NULL,
+		deviation FLOAT NOT NULL,
+		volatility FLOAT NOT NULL,
+		games INTEGER NOT NULL DEFAULT 0,
+		last_played TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS rating_history (
+		id SERIAL PRIMARY KEY,
+		game_id VARCHAR(255) NOT NULL,
+		username VARCHAR(255) NOT NULL,
+		rating_before FLOAT NOT NULL,
+		rating_after FLOAT NOT NULL,
+		deviation_before FLOAT NOT NULL,
+		deviation_after FLOAT NOT NULL,
+		volatility FLOAT NOT NULL,
+		opponent_rating FLOAT NOT NULL,
+		score FLOAT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_rating_history_username ON rating_history(username, created_at);
+	CREATE INDEX IF NOT EXISTS idx_rating_history_game ON rating_history(game_id);
+
+	CREATE TABLE IF NOT EXISTS active_games (
+		id VARCHAR(255) PRIMARY KEY,
+		state JSONB NOT NULL,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS bans (
+		username VARCHAR(255) PRIMARY KEY,
+		reason TEXT NOT NULL,
+		admin VARCHAR(255) NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id SERIAL PRIMARY KEY,
+		admin VARCHAR(255) NOT NULL,
+		action VARCHAR(64) NOT NULL,
+		target VARCHAR(255) NOT NULL DEFAULT '',
+		reason TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT '',
+		address VARCHAR(255) NOT NULL DEFAULT '',
+		forwarded_for VARCHAR(255) NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log(target);
+
+	CREATE TABLE IF NOT EXISTS tournaments (
+		id VARCHAR(255) PRIMARY KEY,
+		state JSONB NOT NULL,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS arenas (
+		id VARCHAR(255) PRIMARY KEY,
+		state JSONB NOT NULL,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+
+	_, err := s.db.Exec(query)
+	if err != nil {
+		log.Printf("Failed to create tables: %v", err)
+		return err
+	}
+
+	log.Println("✅ Database tables created/verified")
+	return nil
+}
+
+func (s *Postgres) SaveGame(game Game) error {
+	moves, _ := json.Marshal(game.Moves)
+
+	_, err := s.db.Exec(`
+		INSERT INTO games (id, player1, player2, winner, duration, is_bot, created_at,
+			variant, time_control, rated, moves, end_reason)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`, game.ID, game.Player1, game.Player2, game.Winner,
+		game.Duration, game.IsBot, game.CreatedAt,
+		game.Variant, game.TimeControl, game.Rated, string(moves), game.EndReason)
+	return err
+}
+
+const postgresGameColumns = `id, player1, COALESCE(player2, ''), COALESCE(winner, 'draw'), is_bot,
+	variant, time_control, rated, COALESCE(duration, 0), created_at, moves, end_reason`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanPostgresGame reads a row of postgresGameColumns.
+func scanPostgresGame(row scanner) (Game, error) {
+	var game Game
+	var moves string
+	err := row.Scan(&game.ID, &game.Player1, &game.Player2, &game.Winner, &game.IsBot,
+		&game.Variant, &game.TimeControl, &game.Rated, &game.Duration, &game.CreatedAt,
+		&moves, &game.EndReason)
+	if err != nil {
+		return game, err
+	}
+	json.Unmarshal([]byte(moves), &game.Moves)
+	return game, nil
+}
+
+func (s *Postgres) Game(id string) (Game, error) {
+	game, err := scanPostgresGame(s.db.QueryRow(`
+		SELECT `+postgresGameColumns+`
+		FROM games WHERE id = $1
+	`, id))
+	if err == sql.ErrNoRows {
+		return game, ErrNotFound
+	}
+	return game, err
+}
+
+func (s *Postgres) Games(filter GameFilter) ([]Game, error) {
+	var until, afterTime sql.NullTime
+	var afterID string
+	if !filter.Until.IsZero() {
+		until = sql.NullTime{Time: filter.Until, Valid: true}
+	}
+	if filter.After != nil {
+		afterTime = sql.NullTime{Time: filter.After.CreatedAt, Valid: true}
+		afterID = filter.After.ID
+	}
+
+	rows, err := s.db.Query(`
+		SELECT `+postgresGameColumns+`
+		FROM games
+		WHERE created_at >= $1
+			AND ($2::timestamp IS NULL OR created_at < $2::timestamp)
+			AND ($3 IN ('', 'all') OR is_bot = ($3 = 'bot'))
+			AND ($4 = '' OR variant = $4)
+			AND ($5 = '' OR player1 = $5 OR player2 = $5)
+			AND ($6 = '' OR player1 = $6 OR player2 = $6)
+			AND CASE $7
+				WHEN 'draw' THEN COALESCE(winner, 'draw') = 'draw'
+				WHEN 'decisive' THEN COALESCE(winner, 'draw') <> 'draw'
+				WHEN 'win' THEN winner = $5
+				WHEN 'loss' THEN COALESCE(winner, 'draw') NOT IN ('draw', $5)
+				ELSE TRUE
+			END
+			AND json_array_length(moves::json) >= $8
+			AND ($9::timestamp IS NULL OR (created_at, id) < ($9::timestamp, $10))
+		ORDER BY created_at DESC, id DESC
+		LIMIT NULLIF($11::int, 0)
+	`, filter.Since, until, filter.Mode, filter.Variant, filter.Player, filter.Opponent,
+		filter.Result, filter.MinMoves, afterTime, afterID, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var games []Game
+	for rows.Next() {
+		game, err := scanPostgresGame(rows)
+		if err != nil {
+			return nil, err
+		}
+		games = append(games, game)
+	}
+	return games, rows.Err()
+}
+
+func (s *Postgres) GameStats() (GameStats, error) {
+	var stats GameStats
+	err := s.db.QueryRow(`
+		SELECT
+			COUNT(*) as total_games,
+			COALESCE(SUM(CASE WHEN is_bot THEN 1 ELSE 0 END), 0) as bot_games,
+			COALESCE(AVG(duration), 0) as avg_duration
+		FROM games
+	`).Scan(&stats.TotalGames, &stats.BotGames, &stats.AvgDuration)
+	return stats, err
+}
+
+func (s *Postgres) SaveActiveGame(id string, state []byte) error {
+	_, err := s.db.Exec(`
+		INSERT INTO active_games (id, state, updated_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (id) DO UPDATE
+		SET state = $2, updated_at = $3
+	`, id, state, time.Now())
+	return err
+}
+
+func (s *Postgres) DeleteActiveGame(id string) error {
+	_, err := s.db.Exec(`DELETE FROM active_games WHERE id = $1`, id)
+	return err
+}
+
+func (s *Postgres) ActiveGames() ([][]byte, error) {
+	rows, err := s.db.Query(`SELECT state FROM active_games`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var states [][]byte
+	for rows.Next() {
+		var state []byte
+		if err := rows.Scan(&state); err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, rows.Err()
+}
+
+func (s *Postgres) Ratings(usernames []string) (map[string]Rating, error) {
+	rows, err := s.db.Query(`
+		SELECT username, rating, deviation, volatility, games, last_played
+		FROM player_ratings WHERE username = ANY($1)
+	`, pq.Array(usernames))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ratings := make(map[string]Rating)
+	for rows.Next() {
+		var rating Rating
+		var lastPlayed sql.NullTime
+		if err := rows.Scan(&rating.Username, &rating.Rating, &rating.Deviation,
+			&rating.Volatility, &rating.Games, &lastPlayed); err != nil {
+			return nil, err
+		}
+		rating.LastPlayed = lastPlayed.Time
+		ratings[rating.Username] = rating
+	}
+	return ratings, rows.Err()
+}
+
+func (s *Postgres) SaveRating(rating Rating, update RatingUpdate) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO player_ratings (username, rating, deviation, volatility, games, last_played)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (username) DO UPDATE
+		SET rating = $2, deviation = $3, volatility = $4, games = $5, last_played = $6
+	`, rating.Username, rating.Rating, rating.Deviation, rating.Volatility, rating.Games, rating.LastPlayed)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO rating_history (game_id, username, rating_before, rating_after,
+			deviation_before, deviation_after, volatility, opponent_rating, score, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`, update.GameID, update.Username, update.RatingBefore, update.RatingAfter,
+		update.DeviationBefore, update.DeviationAfter, update.Volatility,
+		update.OpponentRating, update.Score, update.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Postgres) RatingUpdates(gameID string) ([]RatingUpdate, error) {
+	rows, err := s.db.Query(`
+		SELECT game_id, username, rating_before, rating_after, deviation_before, deviation_after,
+			volatility, opponent_rating, score, created_at
+		FROM rating_history WHERE game_id = $1
+		ORDER BY id
+	`, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var updates []RatingUpdate
+	for rows.Next() {
+		var update RatingUpdate
+		if err := rows.Scan(&update.GameID, &update.Username, &update.RatingBefore, &update.RatingAfter,
+			&update.DeviationBefore, &update.DeviationAfter, &update.Volatility,
+			&update.OpponentRating, &update.Score, &update.CreatedAt); err != nil {
+			return nil, err
+		}
+		updates = append(updates, update)
+	}
+	return updates, rows.Err()
+}
+
+func (s *Postgres) Bans() ([]Ban, error) {
+	rows, err := s.db.Query(`SELECT username, reason, admin, created_at FROM bans`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bans []Ban
+	for rows.Next() {
+		var ban Ban
+		if err := rows.Scan(&ban.Username, &ban.Reason, &ban.Admin, &ban.CreatedAt); err != nil {
+			return nil, err
+		}
+		bans = append(bans, ban)
+	}
+	return bans, rows.Err()
+}
+
+func (s *Postgres) SaveBan(ban Ban) error {
+	_, err := s.db.Exec(`
+		INSERT INTO bans (username, reason, admin, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (username) DO UPDATE
+		SET reason = $2, admin = $3, created_at = $4
+	`, ban.Username, ban.Reason, ban.Admin, ban.CreatedAt)
+	return err
+}
+
+func (s *Postgres) DeleteBan(username string) error {
+	_, err := s.db.Exec(`DELETE FROM bans WHERE username = $1`, username)
+	return err
+}
+
+func (s *Postgres) SaveTournament(id string, state []byte) error {
+	_, err := s.db.Exec(`
+		INSERT INTO tournaments (id, state, updated_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (id) DO UPDATE
+		SET state = $2, updated_at = $3
+	`, id, state, time.Now())
+	return err
+}
+
+func (s *Postgres) Tournaments() ([][]byte, error) {
+	rows, err := s.db.Query(`SELECT state FROM tournaments`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var states [][]byte
+	for rows.Next() {
+		var state []byte
+		if err := rows.Scan(&state); err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, rows.Err()
+}
+
+func (s *Postgres) SaveArena(id string, state []byte) error {
+	_, err := s.db.Exec(`
+		INSERT INTO arenas (id, state, updated_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (id) DO UPDATE
+		SET state = $2, updated_at = $3
+	`, id, state, time.Now())
+	return err
+}
+
+func (s *Postgres) Arenas() ([][]byte, error) {
+	rows, err := s.db.Query(`SELECT state FROM arenas`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var states [][]byte
+	for rows.Next() {
+		var state []byte
+		if err := rows.Scan(&state); err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, rows.Err()
+}
+
+func (s *Postgres) SaveAuditEntry(entry AuditEntry) error {
+	_, err := s.db.Exec(`
+		INSERT INTO audit_log (admin, action, target, reason, error, address, forwarded_for, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, entry.Admin, entry.Action, entry.Target, entry.Reason, entry.Error, entry.Address, entry.ForwardedFor, entry.CreatedAt)
+	return err
+}
+
+func (s *Postgres) AuditLog(filter AuditFilter) ([]AuditEntry, error) {
+	rows, err := s.db.Query(`
+		SELECT admin, action, target, reason, error, address, forwarded_for, created_at
+		FROM audit_log
+		WHERE ($1 = '' OR admin = $1) AND ($2 = '' OR target = $2)
+		ORDER BY id DESC
+		LIMIT NULLIF($3::int, 0)
+	`, filter.Admin, filter.Target, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.Scan(&entry.Admin, &entry.Action, &entry.Target, &entry.Reason,
+			&entry.Error, &entry.Address, &entry.ForwardedFor, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *Postgres) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,63 @@
+package store_test
+
+import (
+	"database/sql"
+	"net/url"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"connect4-backend/store"
+	"connect4-backend/store/storetest"
+)
+
+// The checks run against the database at TEST_DATABASE_URL, never the
+// server's DATABASE_URL. Each check gets a schema of its own, dropped when
+// the test ends.
+func TestPostgres(t *testing.T) {
+	testURL := os.Getenv("TEST_DATABASE_URL")
+	if testURL == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := sql.Open("postgres", testURL)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	run := strconv.FormatInt(time.Now().UnixNano(), 36)
+	schemas := 0
+	storetest.Run(t, func() store.Store {
+		schemas++
+		schema := "storetest_" + run + "_" + strconv.Itoa(schemas)
+		if _, err := db.Exec("CREATE SCHEMA " + schema); err != nil {
+			t.Fatalf("create schema: %v", err)
+		}
+		t.Cleanup(func() {
+			if _, err := db.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+				t.Errorf("drop schema: %v", err)
+			}
+		})
+
+		st, err := store.OpenPostgres(withSearchPath(t, testURL, schema))
+		if err != nil {
+			t.Fatalf("OpenPostgres: %v", err)
+		}
+		return st
+	})
+}
+
+// withSearchPath returns the connection URL with tables created and read
+// in schema.
+func withSearchPath(t *testing.T, rawURL, schema string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("TEST_DATABASE_URL: %v", err)
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
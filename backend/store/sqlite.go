@@ -0,0 +1,502 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLite keeps everything in a single database file, for installs that run
+// one server. Times are stored as Unix nanoseconds.
+type SQLite struct {
+	db *sql.DB
+}
+
+// OpenSQLite opens the database file at path, creating it and any missing
+// tables as needed.
+func OpenSQLite(path string) (*SQLite, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows one writer at a time; queue writes here rather than
+	// have them fail as busy
+	db.SetMaxOpenConns(1)
+
+	s := &SQLite{db: db}
+	if err := s.createTables(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SQLite) String() string { return "sqlite" }
+
+func (s *SQLite) createTables() error {
+	_, err := s.db.Exec(`
+	CREATE TABLE IF NOT EXISTS games (
+		id TEXT PRIMARY KEY,
+		player1 TEXT NOT NULL,
+		player2 TEXT NOT NULL,
+		winner TEXT NOT NULL,
+		duration REAL NOT NULL DEFAULT 0,
+		is_bot INTEGER NOT NULL DEFAULT 0,
+		created_at INTEGER NOT NULL,
+		variant TEXT NOT NULL DEFAULT 'standard',
+		time_control TEXT NOT NULL DEFAULT 'unlimited',
+		rated INTEGER NOT NULL DEFAULT 1,
+		moves TEXT NOT NULL DEFAULT '[]',
+		end_reason TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_games_created_at ON games(created_at, id);
+	CREATE INDEX IF NOT EXISTS idx_games_player1 ON games(player1);
+	CREATE INDEX IF NOT EXISTS idx_games_player2 ON games(player2);
+
+	CREATE TABLE IF NOT EXISTS player_ratings (
+		username TEXT PRIMARY KEY,
+		rating REAL NOT NULL,
+		deviation REAL NOT NULL,
+		volatility REAL NOT NULL,
+		games INTEGER NOT NULL DEFAULT 0,
+		last_played INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS rating_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		game_id TEXT NOT NULL,
+		username TEXT NOT NULL,
+		rating_before REAL NOT NULL,
+		rating_after REAL NOT NULL,
+		deviation_before REAL NOT NULL,
+		deviation_after REAL NOT NULL,
+		volatility REAL NOT NULL,
+		opponent_rating REAL NOT NULL,
+		score REAL NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_rating_history_game ON rating_history(game_id);
+
+	CREATE TABLE IF NOT EXISTS active_games (
+		id TEXT PRIMARY KEY,
+		state BLOB NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS bans (
+		username TEXT PRIMARY KEY,
+		reason TEXT NOT NULL,
+		admin TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		admin TEXT NOT NULL,
+		action TEXT NOT NULL,
+		target TEXT NOT NULL DEFAULT '',
+		reason TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT '',
+		address TEXT NOT NULL DEFAULT '',
+		forwarded_for TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log(target);
+
+	CREATE TABLE IF NOT EXISTS tournaments (
+		id TEXT PRIMARY KEY,
+		state BLOB NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS arenas (
+		id TEXT PRIMARY KEY,
+		state BLOB NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+	`)
+	return err
+}
+
+// nanos converts a time for storage. The zero time is stored as 0.
+func nanos(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromNanos(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
+
+func (s *SQLite) SaveGame(game Game) error {
+	moves, _ := json.Marshal(game.Moves)
+	_, err := s.db.Exec(`
+		INSERT INTO games (id, player1, player2, winner, duration, is_bot, created_at,
+			variant, time_control, rated, moves, end_reason)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, game.ID, game.Player1, game.Player2, game.Winner,
+		game.Duration, game.IsBot, nanos(game.CreatedAt),
+		game.Variant, game.TimeControl, game.Rated, string(moves), game.EndReason)
+	return err
+}
+
+const sqliteGameColumns = `id, player1, player2, winner, is_bot,
+	variant, time_control, rated, duration, created_at, moves, end_reason`
+
+// scanSQLiteGame reads a row of sqliteGameColumns.
+func scanSQLiteGame(row scanner) (Game, error) {
+	var game Game
+	var createdAt int64
+	var moves string
+	err := row.Scan(&game.ID, &game.Player1, &game.Player2, &game.Winner, &game.IsBot,
+		&game.Variant, &game.TimeControl, &game.Rated, &game.Duration, &createdAt,
+		&moves, &game.EndReason)
+	if err != nil {
+		return game, err
+	}
+	game.CreatedAt = fromNanos(createdAt)
+	json.Unmarshal([]byte(moves), &game.Moves)
+	return game, nil
+}
+
+func (s *SQLite) Game(id string) (Game, error) {
+	game, err := scanSQLiteGame(s.db.QueryRow(`
+		SELECT `+sqliteGameColumns+`
+		FROM games WHERE id = ?
+	`, id))
+	if err == sql.ErrNoRows {
+		return game, ErrNotFound
+	}
+	return game, err
+}
+
+func (s *SQLite) Games(filter GameFilter) ([]Game, error) {
+	var until, afterTime sql.NullInt64
+	var afterID string
+	if !filter.Until.IsZero() {
+		until = sql.NullInt64{Int64: filter.Until.UnixNano(), Valid: true}
+	}
+	if filter.After != nil {
+		afterTime = sql.NullInt64{Int64: filter.After.CreatedAt.UnixNano(), Valid: true}
+		afterID = filter.After.ID
+	}
+	limit := filter.Limit
+	if limit == 0 {
+		limit = -1
+	}
+
+	rows, err := s.db.Query(`
+		SELECT `+sqliteGameColumns+`
+		FROM games
+		WHERE created_at >= ?1
+			AND (?2 IS NULL OR created_at < ?2)
+			AND (?3 IN ('', 'all') OR is_bot = (?3 = 'bot'))
+			AND (?4 = '' OR variant = ?4)
+			AND (?5 = '' OR player1 = ?5 OR player2 = ?5)
+			AND (?6 = '' OR player1 = ?6 OR player2 = ?6)
+			AND CASE ?7
+				WHEN 'draw' THEN winner = 'draw'
+				WHEN 'decisive' THEN winner <> 'draw'
+				WHEN 'win' THEN winner = ?5
+				WHEN 'loss' THEN winner NOT IN ('draw', ?5)
+				ELSE 1
+			END
+			AND json_array_length(moves) >= ?8
+			AND (?9 IS NULL OR created_at < ?9 OR created_at = ?9 AND id < ?10)
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?11
+	`, nanos(filter.Since), until, filter.Mode, filter.Variant, filter.Player, filter.Opponent,
+		filter.Result, filter.MinMoves, afterTime, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var games []Game
+	for rows.Next() {
+		game, err := scanSQLiteGame(rows)
+		if err != nil {
+			return nil, err
+		}
+		games = append(games, game)
+	}
+	return games, rows.Err()
+}
+
+func (s *SQLite) GameStats() (GameStats, error) {
+	var stats GameStats
+	err := s.db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(is_bot), 0), COALESCE(AVG(duration), 0)
+		FROM games
+	`).Scan(&stats.TotalGames, &stats.BotGames, &stats.AvgDuration)
+	return stats, err
+}
+
+func (s *SQLite) SaveActiveGame(id string, state []byte) error {
+	_, err := s.db.Exec(`
+		INSERT INTO active_games (id, state, updated_at)
+		VALUES (?1, ?2, ?3)
+		ON CONFLICT (id) DO UPDATE
+		SET state = ?2, updated_at = ?3
+	`, id, state, time.Now().UnixNano())
+	return err
+}
+
+func (s *SQLite) DeleteActiveGame(id string) error {
+	_, err := s.db.Exec(`DELETE FROM active_games WHERE id = ?`, id)
+	return err
+}
+
+func (s *SQLite) ActiveGames() ([][]byte, error) {
+	rows, err := s.db.Query(`SELECT state FROM active_games`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var states [][]byte
+	for rows.Next() {
+		var state []byte
+		if err := rows.Scan(&state); err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, rows.Err()
+}
+
+func (s *SQLite) Ratings(usernames []string) (map[string]Rating, error) {
+	// SQLite has no arrays, so the names go in as a JSON list
+	list, _ := json.Marshal(usernames)
+	rows, err := s.db.Query(`
+		SELECT username, rating, deviation, volatility, games, last_played
+		FROM player_ratings WHERE username IN (SELECT value FROM json_each(?))
+	`, string(list))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ratings := make(map[string]Rating)
+	for rows.Next() {
+		var rating Rating
+		var lastPlayed int64
+		if err := rows.Scan(&rating.Username, &rating.Rating, &rating.Deviation,
+			&rating.Volatility, &rating.Games, &lastPlayed); err != nil {
+			return nil, err
+		}
+		rating.LastPlayed = fromNanos(lastPlayed)
+		ratings[rating.Username] = rating
+	}
+	return ratings, rows.Err()
+}
+
+func (s *SQLite) SaveRating(rating Rating, update RatingUpdate) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO player_ratings (username, rating, deviation, volatility, games, last_played)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6)
+		ON CONFLICT (username) DO UPDATE
+		SET rating = ?2, deviation = ?3, volatility = ?4, games = ?5, last_played = ?6
+	`, rating.Username, rating.Rating, rating.Deviation, rating.Volatility, rating.Games, nanos(rating.LastPlayed))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO rating_history (game_id, username, rating_before, rating_after,
+			deviation_before, deviation_after, volatility, opponent_rating, score, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, update.GameID, update.Username, update.RatingBefore, update.RatingAfter,
+		update.DeviationBefore, update.DeviationAfter, update.Volatility,
+		update.OpponentRating, update.Score, nanos(update.CreatedAt))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLite) RatingUpdates(gameID string) ([]RatingUpdate, error) {
+	rows, err := s.db.Query(`
+		SELECT game_id, username, rating_before, rating_after, deviation_before, deviation_after,
+			volatility, opponent_rating, score, created_at
+		FROM rating_history WHERE game_id = ?
+		ORDER BY id
+	`, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var updates []RatingUpdate
+	for rows.Next() {
+		var update RatingUpdate
+		var createdAt int64
+		if err := rows.Scan(&update.GameID, &update.Username, &update.RatingBefore, &update.RatingAfter,
+			&update.DeviationBefore, &update.DeviationAfter, &update.Volatility,
+			&update.OpponentRating, &update.Score, &createdAt); err != nil {
+			return nil, err
+		}
+		update.CreatedAt = fromNanos(createdAt)
+		updates = append(updates, update)
+	}
+	return updates, rows.Err()
+}
+
+func (s *SQLite) Bans() ([]Ban, error) {
+	rows, err := s.db.Query(`SELECT username, reason, admin, created_at FROM bans`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bans []Ban
+	for rows.Next() {
+		var ban Ban
+		var createdAt int64
+		if err := rows.Scan(&ban.Username, &ban.Reason, &ban.Admin, &createdAt); err != nil {
+			return nil, err
+		}
+		ban.CreatedAt = fromNanos(createdAt)
+		bans = append(bans, ban)
+	}
+	return bans, rows.Err()
+}
+
+func (s *SQLite) SaveBan(ban Ban) error {
+	_, err := s.db.Exec(`
+		INSERT INTO bans (username, reason, admin, created_at)
+		VALUES (?1, ?2, ?3, ?4)
+		ON CONFLICT (username) DO UPDATE
+		SET reason = ?2, admin = ?3, created_at = ?4
+	`, ban.Username, ban.Reason, ban.Admin, nanos(ban.CreatedAt))
+	return err
+}
+
+func (s *SQLite) DeleteBan(username string) error {
+	_, err := s.db.Exec(`DELETE FROM bans WHERE username = ?`, username)
+	return err
+}
+
+func (s *SQLite) SaveTournament(id string, state []byte) error {
+	_, err := s.db.Exec(`
+		INSERT INTO tournaments (id, state, updated_at)
+		VALUES (?1, ?2, ?3)
+		ON CONFLICT (id) DO UPDATE
+		SET state = ?2, updated_at = ?3
+	`, id, state, time.Now().UnixNano())
+	return err
+}
+
+func (s *SQLite) Tournaments() ([][]byte, error) {
+	rows, err := s.db.Query(`SELECT state FROM tournaments`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var states [][]byte
+	for rows.Next() {
+		var state []byte
+		if err := rows.Scan(&state); err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, rows.Err()
+}
+
+func (s *SQLite) SaveArena(id string, state []byte) error {
+	_, err := s.db.Exec(`
+		INSERT INTO arenas (id, state, updated_at)
+		VALUES (?1, ?2, ?3)
+		ON CONFLICT (id) DO UPDATE
+		SET state = ?2, updated_at = ?3
+	`, id, state, time.Now().UnixNano())
+	return err
+}
+
+func (s *SQLite) Arenas() ([][]byte, error) {
+	rows, err := s.db.Query(`SELECT state FROM arenas`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var states [][]byte
+	for rows.Next() {
+		var state []byte
+		if err := rows.Scan(&state); err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, rows.Err()
+}
+
+func (s *SQLite) SaveAuditEntry(entry AuditEntry) error {
+	_, err := s.db.Exec(`
+		INSERT INTO audit_log (admin, action, target, reason, error, address, forwarded_for, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, entry.Admin, entry.Action, entry.Target, entry.Reason, entry.Error, entry.Address, entry.ForwardedFor,
+		nanos(entry.CreatedAt))
+	return err
+}
+
+func (s *SQLite) AuditLog(filter AuditFilter) ([]AuditEntry, error) {
+	limit := filter.Limit
+	if limit == 0 {
+		limit = -1
+	}
+
+	rows, err := s.db.Query(`
+		SELECT admin, action, target, reason, error, address, forwarded_for, created_at
+		FROM audit_log
+		WHERE (?1 = '' OR admin = ?1) AND (?2 = '' OR target = ?2)
+		ORDER BY id DESC
+		LIMIT ?3
+	`, filter.Admin, filter.Target, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		var createdAt int64
+		if err := rows.Scan(&entry.Admin, &entry.Action, &entry.Target, &entry.Reason,
+			&entry.Error, &entry.Address, &entry.ForwardedFor, &createdAt); err != nil {
+			return nil, err
+		}
+		entry.CreatedAt = fromNanos(createdAt)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,24 @@
+package store_test
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"connect4-backend/store"
+	"connect4-backend/store/storetest"
+)
+
+// Each check gets a fresh database file.
+func TestSQLite(t *testing.T) {
+	dir := t.TempDir()
+	files := 0
+	storetest.Run(t, func() store.Store {
+		files++
+		st, err := store.OpenSQLite(filepath.Join(dir, "check"+strconv.Itoa(files)+".db"))
+		if err != nil {
+			t.Fatalf("OpenSQLite: %v", err)
+		}
+		return st
+	})
+}
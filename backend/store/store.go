@@ -0,0 +1,255 @@
+// Package store persists finished games, games in progress and players'
+// ratings. Postgres suits deployments with several instances,
+// SQLite single-node installs, and the in-memory store tests and throwaway
+// servers.
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const defaultSQLitePath = "data/connect4.db"
+
+var ErrNotFound = errors.New("not found")
+
+// Store is where the server keeps everything that should outlive a game.
+// Implementations are safe for concurrent use.
+type Store interface {
+	// SaveGame records a finished game.
+	SaveGame(game Game) error
+	// Game returns a recorded game, or ErrNotFound.
+	Game(id string) (Game, error)
+	// Games returns up to filter.Limit of the recorded games matching
+	// filter, newest first. A zero Limit returns all of them.
+	Games(filter GameFilter) ([]Game, error)
+	// GameStats summarises every recorded game.
+	GameStats() (GameStats, error)
+
+	// SaveActiveGame checkpoints a game in progress, replacing any earlier
+	// checkpoint of it.
+	SaveActiveGame(id string, state []byte) error
+	DeleteActiveGame(id string) error
+	// ActiveGames returns every checkpoint, in no particular order.
+	ActiveGames() ([][]byte, error)
+
+	// Ratings returns the ratings of those of the players who have one.
+	Ratings(usernames []string) (map[string]Rating, error)
+	// SaveRating stores a player's new rating together with the update
+	// that produced it.
+	SaveRating(rating Rating, update RatingUpdate) error
+	// RatingUpdates returns how a game moved its players' ratings.
+	RatingUpdates(gameID string) ([]RatingUpdate, error)
+
+	// Bans returns every banned player.
+	Bans() ([]Ban, error)
+	// SaveBan bans a player, replacing any ban they already have.
+	SaveBan(ban Ban) error
+	DeleteBan(username string) error
+
+	// SaveTournament stores a tournament's state, replacing any earlier
+	// state of it.
+	SaveTournament(id string, state []byte) error
+	// Tournaments returns the state of every tournament, in no particular
+	// order.
+	Tournaments() ([][]byte, error)
+
+	// SaveArena stores an arena's state, replacing any earlier state of it.
+	SaveArena(id string, state []byte) error
+	// Arenas returns the state of every arena, in no particular order.
+	Arenas() ([][]byte, error)
+
+	// SaveAuditEntry records an action taken through the admin API.
+	SaveAuditEntry(entry AuditEntry) error
+	// AuditLog returns up to filter.Limit of the recorded admin actions
+	// matching filter, newest first. A zero Limit returns all of them.
+	AuditLog(filter AuditFilter) ([]AuditEntry, error)
+
+	Close() error
+}
+
+// Game is a finished game. Winner is the winner's username or "draw"; when
+// IsBot is set Player2 is the bot.
+type Game struct {
+	ID          string    `json:"id"`
+	Player1     string    `json:"player1"`
+	Player2     string    `json:"player2"`
+	Winner      string    `json:"winner"`
+	IsBot       bool      `json:"isBot"`
+	Variant     string    `json:"variant"`
+	TimeControl string    `json:"timeControl"`
+	Rated       bool      `json:"rated"`
+	Duration    float64   `json:"duration"` // Seconds
+	CreatedAt   time.Time `json:"createdAt"`
+	Moves       []int     `json:"moves"` // Columns played, in order
+	EndReason   string    `json:"endReason,omitempty"`
+}
+
+func (g *Game) involves(username string) bool {
+	return g.Player1 == username || g.Player2 == username
+}
+
+// Cursor is the position of a game in newest-first order.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+// GameFilter narrows the recorded games a query looks at. Empty fields
+// match every game.
+type GameFilter struct {
+	Since    time.Time
+	Until    time.Time
+	Mode     string // "human" or "bot", or "all"
+	Variant  string
+	Player   string
+	Opponent string // Only used along with Player
+	Result   string // "win", "loss" or "draw" for Player; "draw" or "decisive" without one
+	MinMoves int
+	After    *Cursor // Only games older than this one
+	Limit    int
+}
+
+// Matches reports whether game passes the filter.
+func (f *GameFilter) Matches(game *Game) bool {
+	if game.CreatedAt.Before(f.Since) || !f.Until.IsZero() && !game.CreatedAt.Before(f.Until) {
+		return false
+	}
+	if f.Mode == "human" && game.IsBot || f.Mode == "bot" && !game.IsBot {
+		return false
+	}
+	if f.Player != "" && !game.involves(f.Player) || f.Opponent != "" && !game.involves(f.Opponent) {
+		return false
+	}
+	if len(game.Moves) < f.MinMoves || f.After != nil && !f.After.follows(game) {
+		return false
+	}
+	if f.Variant != "" && game.Variant != f.Variant {
+		return false
+	}
+
+	switch f.Result {
+	case "draw":
+		return game.Winner == "draw"
+	case "decisive":
+		return game.Winner != "draw"
+	case "win":
+		return game.Winner == f.Player
+	case "loss":
+		return game.Winner != "draw" && game.Winner != f.Player
+	}
+	return true
+}
+
+// follows reports whether game comes after the cursor, newest first.
+func (c *Cursor) follows(game *Game) bool {
+	if !game.CreatedAt.Equal(c.CreatedAt) {
+		return game.CreatedAt.Before(c.CreatedAt)
+	}
+	return game.ID < c.ID
+}
+
+// GameStats are totals over every recorded game.
+type GameStats struct {
+	TotalGames  int
+	BotGames    int
+	AvgDuration float64
+}
+
+// Rating is a player's Glicko-2 rating.
+type Rating struct {
+	Username   string
+	Rating     float64
+	Deviation  float64
+	Volatility float64
+	Games      int
+	LastPlayed time.Time
+}
+
+// RatingUpdate is how one game moved one player's rating.
+type RatingUpdate struct {
+	GameID          string
+	Username        string
+	RatingBefore    float64
+	RatingAfter     float64
+	DeviationBefore float64
+	DeviationAfter  float64
+	Volatility      float64
+	OpponentRating  float64
+	Score           float64
+	CreatedAt       time.Time
+}
+
+// Ban keeps a player out of games.
+type Ban struct {
+	Username  string    `json:"username"`
+	Reason    string    `json:"reason"`
+	Admin     string    `json:"admin"` // Who banned them
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// AuditEntry is one action taken through the admin API. Error is set when
+// the action failed.
+type AuditEntry struct {
+	Admin        string    `json:"admin"`
+	Action       string    `json:"action"`
+	Target       string    `json:"target,omitempty"` // The game or player acted on
+	Reason       string    `json:"reason,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	Address      string    `json:"address"`                // The connection the request came in on
+	ForwardedFor string    `json:"forwardedFor,omitempty"` // X-Forwarded-For, from a trusted proxy
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// AuditFilter narrows the audit log. Empty fields match every entry.
+type AuditFilter struct {
+	Admin  string
+	Target string
+	Limit  int
+}
+
+// Matches reports whether entry passes the filter.
+func (f *AuditFilter) Matches(entry *AuditEntry) bool {
+	return (f.Admin == "" || entry.Admin == f.Admin) && (f.Target == "" || entry.Target == f.Target)
+}
+
+// Open opens the store selected by STORE: "postgres", "sqlite" or
+// "memory". Without STORE it uses Postgres when DATABASE_URL is set and
+// otherwise SQLite at SQLITE_PATH.
+func Open() (Store, error) {
+	kind := os.Getenv("STORE")
+	if kind == "" {
+		kind = "sqlite"
+		if os.Getenv("DATABASE_URL") != "" {
+			kind = "postgres"
+		}
+	}
+
+	switch kind {
+	case "postgres":
+		url := os.Getenv("DATABASE_URL")
+		if url == "" {
+			return nil, errors.New("STORE=postgres requires DATABASE_URL")
+		}
+		s, err := OpenPostgres(url)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "sqlite":
+		path := os.Getenv("SQLITE_PATH")
+		if path == "" {
+			path = defaultSQLitePath
+		}
+		s, err := OpenSQLite(path)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "memory":
+		return NewMemory(), nil
+	}
+	return nil, fmt.Errorf("unknown store %q", kind)
+}
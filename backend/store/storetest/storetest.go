@@ -0,0 +1,582 @@
+// Package storetest is the conformance suite every store.Store must pass,
+// run from each store's tests. The checks only touch players and games they
+// create, under names unique to the run, so they can also be pointed at a
+// database in use.
+package storetest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"connect4-backend/store"
+)
+
+// check is one part of the suite.
+type check struct {
+	name string
+	run  func(s store.Store, t *T)
+}
+
+// T is the test a check runs in, with the names it may use.
+type T struct {
+	*testing.T
+	prefix string
+}
+
+// name returns a player or game name unique to the run.
+func (t *T) name(suffix string) string {
+	return t.prefix + "-" + suffix
+}
+
+var checks = []check{
+	{"game round trip", checkGameRoundTrip},
+	{"game search", checkGameSearch},
+	{"game paging", checkGamePaging},
+	{"game stats", checkGameStats},
+	{"active games", checkActiveGames},
+	{"ratings", checkRatings},
+	{"bans", checkBans},
+	{"audit log", checkAuditLog},
+	{"tournaments", checkTournaments},
+	{"arenas", checkArenas},
+}
+
+// Run runs every check as a subtest against a store from open, which is
+// called once per check, from t's goroutine, so it may fail t.
+func Run(t *testing.T, open func() store.Store) {
+	run := strconv.FormatInt(time.Now().UnixNano(), 36)
+	for i, c := range checks {
+		s := open()
+		prefix := run + strconv.Itoa(i)
+		t.Run(c.name, func(t *testing.T) {
+			c.run(s, &T{T: t, prefix: prefix})
+		})
+		s.Close()
+	}
+}
+
+// baseTime is a start time every store keeps exactly.
+func baseTime() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+func checkGameRoundTrip(s store.Store, t *T) {
+	game := store.Game{
+		ID:          t.name("game"),
+		Player1:     t.name("alice"),
+		Player2:     t.name("bob"),
+		Winner:      t.name("alice"),
+		Variant:     "popout",
+		TimeControl: "blitz",
+		Rated:       true,
+		Duration:    42.5,
+		CreatedAt:   baseTime(),
+		Moves:       []int{3, 3, 4, 4, 5, 5, 6},
+		EndReason:   "connect_four",
+	}
+	if err := s.SaveGame(game); err != nil {
+		t.Errorf("SaveGame: %v", err)
+		return
+	}
+
+	got, err := s.Game(game.ID)
+	if err != nil {
+		t.Errorf("Game: %v", err)
+		return
+	}
+	if !got.CreatedAt.Equal(game.CreatedAt) {
+		t.Errorf("CreatedAt = %v, want %v", got.CreatedAt, game.CreatedAt)
+	}
+	got.CreatedAt = game.CreatedAt
+	if !reflect.DeepEqual(got, game) {
+		t.Errorf("Game = %+v, want %+v", got, game)
+	}
+
+	bot := store.Game{
+		ID:          t.name("bot-game"),
+		Player1:     t.name("alice"),
+		Player2:     "Luffy",
+		Winner:      "draw",
+		IsBot:       true,
+		Variant:     "standard",
+		TimeControl: "unlimited",
+		CreatedAt:   baseTime(),
+		Moves:       []int{},
+	}
+	if err := s.SaveGame(bot); err != nil {
+		t.Errorf("SaveGame: %v", err)
+		return
+	}
+	if got, err := s.Game(bot.ID); err != nil || !got.IsBot || got.Rated || got.Winner != "draw" || len(got.Moves) != 0 {
+		t.Errorf("Game = %+v, %v; want an unrated bot draw with no moves", got, err)
+	}
+
+	if _, err := s.Game(t.name("missing")); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Game of an unknown ID: err = %v, want ErrNotFound", err)
+	}
+}
+
+func checkGameSearch(s store.Store, t *T) {
+	alice, bob, carol := t.name("alice"), t.name("bob"), t.name("carol")
+	start := baseTime()
+	games := []store.Game{
+		{Player1: alice, Player2: bob, Winner: alice, Variant: "standard", Moves: []int{0, 1, 0, 1, 0, 1, 0}},
+		{Player1: bob, Player2: alice, Winner: bob, Variant: "standard", Moves: []int{1, 2, 1, 2, 1, 2, 1}},
+		{Player1: alice, Player2: carol, Winner: "draw", Variant: "popout", Moves: []int{3, 3}},
+		{Player1: alice, Player2: "Luffy", Winner: "Luffy", IsBot: true, Variant: "standard", Moves: []int{3}},
+		{Player1: carol, Player2: bob, Winner: carol, Variant: "standard", Moves: []int{2, 2, 2}},
+	}
+	for i := range games {
+		games[i].ID = t.name("game" + strconv.Itoa(i))
+		games[i].TimeControl = "unlimited"
+		games[i].Rated = true
+		games[i].CreatedAt = start.Add(time.Duration(i) * time.Minute)
+		if err := s.SaveGame(games[i]); err != nil {
+			t.Errorf("SaveGame: %v", err)
+			return
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter store.GameFilter
+		want   []int // Indexes into games, newest first
+	}{
+		{"player", store.GameFilter{Player: alice}, []int{3, 2, 1, 0}},
+		{"opponent", store.GameFilter{Player: alice, Opponent: bob}, []int{1, 0}},
+		{"human", store.GameFilter{Player: alice, Mode: "human"}, []int{2, 1, 0}},
+		{"bot", store.GameFilter{Player: alice, Mode: "bot"}, []int{3}},
+		{"all modes", store.GameFilter{Player: alice, Mode: "all"}, []int{3, 2, 1, 0}},
+		{"variant", store.GameFilter{Player: alice, Variant: "popout"}, []int{2}},
+		{"win", store.GameFilter{Player: alice, Result: "win"}, []int{0}},
+		{"loss", store.GameFilter{Player: alice, Result: "loss"}, []int{3, 1}},
+		{"draw", store.GameFilter{Player: alice, Result: "draw"}, []int{2}},
+		{"decisive", store.GameFilter{Player: bob, Result: "decisive"}, []int{4, 1, 0}},
+		{"min moves", store.GameFilter{Player: bob, MinMoves: 7}, []int{1, 0}},
+		{"since", store.GameFilter{Player: bob, Since: games[1].CreatedAt}, []int{4, 1}},
+		{"until", store.GameFilter{Player: bob, Until: games[4].CreatedAt}, []int{1, 0}},
+		{"limit", store.GameFilter{Player: alice, Limit: 2}, []int{3, 2}},
+	}
+	for _, test := range tests {
+		got, err := s.Games(test.filter)
+		if err != nil {
+			t.Errorf("%s: Games: %v", test.name, err)
+			continue
+		}
+		if ids, want := gameIDs(got), indexIDs(games, test.want); !reflect.DeepEqual(ids, want) {
+			t.Errorf("%s: Games = %v, want %v", test.name, ids, want)
+		}
+	}
+}
+
+func checkGamePaging(s store.Store, t *T) {
+	alice, bob := t.name("alice"), t.name("bob")
+	start := baseTime()
+
+	// Several games share a start time, so the cursor must fall back on IDs
+	var want []string
+	for i := 0; i < 7; i++ {
+		game := store.Game{
+			ID:          t.name("game" + strconv.Itoa(i)),
+			Player1:     alice,
+			Player2:     bob,
+			Winner:      "draw",
+			Variant:     "standard",
+			TimeControl: "unlimited",
+			CreatedAt:   start.Add(time.Duration(i/3) * time.Second),
+			Moves:       []int{},
+		}
+		if err := s.SaveGame(game); err != nil {
+			t.Errorf("SaveGame: %v", err)
+			return
+		}
+		// Later games start later or have greater IDs, so come first
+		want = append([]string{game.ID}, want...)
+	}
+
+	var got []string
+	filter := store.GameFilter{Player: alice, Limit: 3}
+	for page := 0; page < 5; page++ {
+		games, err := s.Games(filter)
+		if err != nil {
+			t.Errorf("Games: %v", err)
+			return
+		}
+		got = append(got, gameIDs(games)...)
+		if len(games) < filter.Limit {
+			break
+		}
+		last := games[len(games)-1]
+		filter.After = &store.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("paged through %v, want %v", got, want)
+	}
+}
+
+func checkGameStats(s store.Store, t *T) {
+	before, err := s.GameStats()
+	if err != nil {
+		t.Errorf("GameStats: %v", err)
+		return
+	}
+
+	durations := []float64{10, 20, 60}
+	for i, duration := range durations {
+		game := store.Game{
+			ID:          t.name("game" + strconv.Itoa(i)),
+			Player1:     t.name("alice"),
+			Player2:     t.name("bob"),
+			Winner:      "draw",
+			IsBot:       i == 0,
+			Variant:     "standard",
+			TimeControl: "unlimited",
+			Duration:    duration,
+			CreatedAt:   baseTime(),
+		}
+		if err := s.SaveGame(game); err != nil {
+			t.Errorf("SaveGame: %v", err)
+			return
+		}
+	}
+
+	after, err := s.GameStats()
+	if err != nil {
+		t.Errorf("GameStats: %v", err)
+		return
+	}
+	if after.TotalGames-before.TotalGames != 3 || after.BotGames-before.BotGames != 1 {
+		t.Errorf("GameStats went from %+v to %+v, want 3 more games, 1 more with the bot", before, after)
+	}
+	if before.TotalGames == 0 && after.AvgDuration != 30 {
+		t.Errorf("AvgDuration = %v, want 30", after.AvgDuration)
+	}
+}
+
+func checkActiveGames(s store.Store, t *T) {
+	first, second := t.name("game1"), t.name("game2")
+	state := func(id string, moves int) []byte {
+		return []byte(fmt.Sprintf(`{"id": %q, "moves": %d}`, id, moves))
+	}
+
+	for _, err := range []error{
+		s.SaveActiveGame(first, state(first, 1)),
+		s.SaveActiveGame(second, state(second, 1)),
+		s.SaveActiveGame(first, state(first, 2)),
+	} {
+		if err != nil {
+			t.Errorf("SaveActiveGame: %v", err)
+			return
+		}
+	}
+
+	states := activeStates(s, t)
+	if states[first] != 2 || states[second] != 1 {
+		t.Errorf("ActiveGames = %v, want %s at 2 moves and %s at 1", states, first, second)
+	}
+
+	if err := s.DeleteActiveGame(first); err != nil {
+		t.Errorf("DeleteActiveGame: %v", err)
+	}
+	if err := s.DeleteActiveGame(t.name("missing")); err != nil {
+		t.Errorf("DeleteActiveGame of an unknown game: %v", err)
+	}
+	states = activeStates(s, t)
+	if _, exists := states[first]; exists || states[second] != 1 {
+		t.Errorf("ActiveGames after delete = %v, want only %s", states, second)
+	}
+}
+
+func checkTournaments(s store.Store, t *T) {
+	first, second := t.name("cup"), t.name("league")
+	state := func(id string, round int) []byte {
+		return []byte(fmt.Sprintf(`{"id": %q, "round": %d}`, id, round))
+	}
+
+	for _, err := range []error{
+		s.SaveTournament(first, state(first, 1)),
+		s.SaveTournament(second, state(second, 1)),
+		s.SaveTournament(first, state(first, 2)),
+	} {
+		if err != nil {
+			t.Errorf("SaveTournament: %v", err)
+			return
+		}
+	}
+
+	states, err := s.Tournaments()
+	if err != nil {
+		t.Errorf("Tournaments: %v", err)
+		return
+	}
+	rounds := make(map[string]int)
+	for _, state := range states {
+		var tournament struct {
+			ID    string `json:"id"`
+			Round int    `json:"round"`
+		}
+		if err := json.Unmarshal(state, &tournament); err != nil {
+			t.Errorf("Tournaments returned %q: %v", state, err)
+			continue
+		}
+		if tournament.ID == first || tournament.ID == second {
+			rounds[tournament.ID] = tournament.Round
+		}
+	}
+	if len(rounds) != 2 || rounds[first] != 2 || rounds[second] != 1 {
+		t.Errorf("Tournaments = %v, want %s in round 2 and %s in round 1", rounds, first, second)
+	}
+}
+
+func checkArenas(s store.Store, t *T) {
+	first, second := t.name("blitz"), t.name("bullet")
+	state := func(id string, games int) []byte {
+		return []byte(fmt.Sprintf(`{"id": %q, "games": %d}`, id, games))
+	}
+
+	for _, err := range []error{
+		s.SaveArena(first, state(first, 1)),
+		s.SaveArena(second, state(second, 1)),
+		s.SaveArena(first, state(first, 5)),
+	} {
+		if err != nil {
+			t.Errorf("SaveArena: %v", err)
+			return
+		}
+	}
+
+	states, err := s.Arenas()
+	if err != nil {
+		t.Errorf("Arenas: %v", err)
+		return
+	}
+	games := make(map[string]int)
+	for _, state := range states {
+		var arena struct {
+			ID    string `json:"id"`
+			Games int    `json:"games"`
+		}
+		if err := json.Unmarshal(state, &arena); err != nil {
+			t.Errorf("Arenas returned %q: %v", state, err)
+			continue
+		}
+		if arena.ID == first || arena.ID == second {
+			games[arena.ID] = arena.Games
+		}
+	}
+	if len(games) != 2 || games[first] != 5 || games[second] != 1 {
+		t.Errorf("Arenas = %v, want %s with 5 games and %s with 1", games, first, second)
+	}
+}
+
+// activeStates returns the move counts of the run's checkpoints by game ID.
+func activeStates(s store.Store, t *T) map[string]int {
+	states, err := s.ActiveGames()
+	if err != nil {
+		t.Errorf("ActiveGames: %v", err)
+		return nil
+	}
+
+	counts := make(map[string]int)
+	for _, state := range states {
+		var game struct {
+			ID    string `json:"id"`
+			Moves int    `json:"moves"`
+		}
+		if err := json.Unmarshal(state, &game); err != nil {
+			t.Errorf("ActiveGames returned %q: %v", state, err)
+			continue
+		}
+		if len(game.ID) > len(t.prefix) && game.ID[:len(t.prefix)] == t.prefix {
+			counts[game.ID] = game.Moves
+		}
+	}
+	return counts
+}
+
+func checkRatings(s store.Store, t *T) {
+	alice, bob, carol := t.name("alice"), t.name("bob"), t.name("carol")
+	gameID := t.name("game")
+	played := baseTime()
+
+	ratings, err := s.Ratings([]string{alice, bob})
+	if err != nil || len(ratings) != 0 {
+		t.Errorf("Ratings of new players = %v, %v; want none", ratings, err)
+	}
+
+	updates := []store.RatingUpdate{
+		{GameID: gameID, Username: alice, RatingBefore: 1000, RatingAfter: 1162.3,
+			DeviationBefore: 350, DeviationAfter: 290.1, Volatility: 0.06,
+			OpponentRating: 1000, Score: 1, CreatedAt: played},
+		{GameID: gameID, Username: bob, RatingBefore: 1000, RatingAfter: 837.7,
+			DeviationBefore: 350, DeviationAfter: 290.1, Volatility: 0.06,
+			OpponentRating: 1000, Score: 0, CreatedAt: played},
+	}
+	for _, update := range updates {
+		rating := store.Rating{
+			Username:   update.Username,
+			Rating:     update.RatingAfter,
+			Deviation:  update.DeviationAfter,
+			Volatility: update.Volatility,
+			Games:      1,
+			LastPlayed: played,
+		}
+		if err := s.SaveRating(rating, update); err != nil {
+			t.Errorf("SaveRating: %v", err)
+			return
+		}
+	}
+
+	ratings, err = s.Ratings([]string{alice, bob, carol})
+	if err != nil {
+		t.Errorf("Ratings: %v", err)
+		return
+	}
+	if len(ratings) != 2 {
+		t.Errorf("Ratings = %v, want %s and %s only", ratings, alice, bob)
+	}
+	for _, update := range updates {
+		rating := ratings[update.Username]
+		if rating.Rating != update.RatingAfter || rating.Games != 1 || !rating.LastPlayed.Equal(played) {
+			t.Errorf("Ratings[%s] = %+v, want %v after 1 game at %v", update.Username, rating, update.RatingAfter, played)
+		}
+	}
+
+	got, err := s.RatingUpdates(gameID)
+	if err != nil {
+		t.Errorf("RatingUpdates: %v", err)
+		return
+	}
+	for i := range got {
+		if got[i].CreatedAt.Equal(played) {
+			got[i].CreatedAt = played
+		}
+	}
+	if !reflect.DeepEqual(got, updates) {
+		t.Errorf("RatingUpdates = %+v, want %+v", got, updates)
+	}
+	if got, err := s.RatingUpdates(t.name("missing")); err != nil || len(got) != 0 {
+		t.Errorf("RatingUpdates of an unrated game = %v, %v; want none", got, err)
+	}
+}
+
+func checkBans(s store.Store, t *T) {
+	alice, bob := t.name("alice"), t.name("bob")
+	banned := baseTime()
+
+	for _, ban := range []store.Ban{
+		{Username: alice, Reason: "spam", Admin: "ops", CreatedAt: banned},
+		{Username: bob, Reason: "abuse", Admin: "ops", CreatedAt: banned},
+		{Username: alice, Reason: "cheating", Admin: "mod", CreatedAt: banned.Add(time.Minute)},
+	} {
+		if err := s.SaveBan(ban); err != nil {
+			t.Errorf("SaveBan: %v", err)
+			return
+		}
+	}
+
+	bans := runBans(s, t)
+	if ban := bans[alice]; ban.Reason != "cheating" || ban.Admin != "mod" || !ban.CreatedAt.Equal(banned.Add(time.Minute)) {
+		t.Errorf("Bans[%s] = %+v, want the second ban of %s", alice, ban, alice)
+	}
+	if _, exists := bans[bob]; !exists || len(bans) != 2 {
+		t.Errorf("Bans = %v, want %s and %s", bans, alice, bob)
+	}
+
+	if err := s.DeleteBan(bob); err != nil {
+		t.Errorf("DeleteBan: %v", err)
+	}
+	if err := s.DeleteBan(t.name("carol")); err != nil {
+		t.Errorf("DeleteBan of a player who is not banned: %v", err)
+	}
+	if bans := runBans(s, t); len(bans) != 1 || bans[alice].Username != alice {
+		t.Errorf("Bans after delete = %v, want only %s", bans, alice)
+	}
+}
+
+// runBans returns the run's bans by username.
+func runBans(s store.Store, t *T) map[string]store.Ban {
+	bans, err := s.Bans()
+	if err != nil {
+		t.Errorf("Bans: %v", err)
+		return nil
+	}
+
+	byName := make(map[string]store.Ban)
+	for _, ban := range bans {
+		if len(ban.Username) > len(t.prefix) && ban.Username[:len(t.prefix)] == t.prefix {
+			byName[ban.Username] = ban
+		}
+	}
+	return byName
+}
+
+func checkAuditLog(s store.Store, t *T) {
+	admin, other := t.name("ops"), t.name("mod")
+	game, player := t.name("game"), t.name("alice")
+	at := baseTime()
+
+	entries := []store.AuditEntry{
+		{Admin: admin, Action: "abort_game", Target: game, Reason: "stuck", Address: "10.0.0.1", CreatedAt: at},
+		{Admin: other, Action: "ban", Target: player, Reason: "spam", Address: "10.0.0.2", CreatedAt: at.Add(time.Second),
+			ForwardedFor: "203.0.113.7"},
+		{Admin: admin, Action: "finish_game", Target: game, Reason: "stuck", Error: "game not found",
+			Address: "10.0.0.1", CreatedAt: at.Add(2 * time.Second)},
+	}
+	for _, entry := range entries {
+		if err := s.SaveAuditEntry(entry); err != nil {
+			t.Errorf("SaveAuditEntry: %v", err)
+			return
+		}
+	}
+
+	for _, tc := range []struct {
+		filter store.AuditFilter
+		want   []int
+	}{
+		{store.AuditFilter{Admin: admin}, []int{2, 0}},
+		{store.AuditFilter{Admin: admin, Limit: 1}, []int{2}},
+		{store.AuditFilter{Target: player}, []int{1}},
+		{store.AuditFilter{Admin: other, Target: game}, nil},
+	} {
+		got, err := s.AuditLog(tc.filter)
+		if err != nil {
+			t.Errorf("AuditLog(%+v): %v", tc.filter, err)
+			continue
+		}
+
+		var want []store.AuditEntry
+		for _, i := range tc.want {
+			want = append(want, entries[i])
+		}
+		for i := range got {
+			if i < len(want) && got[i].CreatedAt.Equal(want[i].CreatedAt) {
+				got[i].CreatedAt = want[i].CreatedAt
+			}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("AuditLog(%+v) = %+v, want %+v", tc.filter, got, want)
+		}
+	}
+}
+
+func gameIDs(games []store.Game) []string {
+	ids := []string{}
+	for _, game := range games {
+		ids = append(ids, game.ID)
+	}
+	return ids
+}
+
+func indexIDs(games []store.Game, indexes []int) []string {
+	ids := []string{}
+	for _, i := range indexes {
+		ids = append(ids, games[i].ID)
+	}
+	return ids
+}
@@ -0,0 +1,162 @@
+package tournament
+
+import (
+	"connect4-backend/game"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var statuses = map[string]bool{"registering": true, "running": true, "finished": true, "cancelled": true}
+
+// Summary is a tournament as the tournament list shows it.
+type Summary struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Format      string     `json:"format"`
+	Status      string     `json:"status"`
+	Round       int        `json:"round"`
+	Rounds      int        `json:"rounds"`
+	Players     int        `json:"players"`
+	MaxPlayers  int        `json:"maxPlayers"`
+	TimeControl string     `json:"timeControl"`
+	Variant     string     `json:"variant"`
+	Rated       bool       `json:"rated"`
+	StartsAt    *time.Time `json:"startsAt,omitempty"`
+	Winner      string     `json:"winner,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+func summarize(t *Tournament) Summary {
+	return Summary{
+		ID:          t.ID,
+		Name:        t.Name,
+		Format:      t.Format,
+		Status:      t.Status,
+		Round:       t.Round,
+		Rounds:      t.Rounds,
+		Players:     len(t.Players),
+		MaxPlayers:  t.MaxPlayers,
+		TimeControl: t.TimeControl,
+		Variant:     t.Variant,
+		Rated:       t.Rated,
+		StartsAt:    t.StartsAt,
+		Winner:      t.Winner,
+		CreatedAt:   t.CreatedAt,
+	}
+}
+
+// statusOf is the HTTP status for an error from the director.
+func statusOf(err error) int {
+	switch err {
+	case ErrTournamentNotFound, ErrNotRegistered:
+		return http.StatusNotFound
+	case ErrRegistrationClosed, ErrTournamentFull, ErrAlreadyStarted:
+		return http.StatusConflict
+	case game.ErrBanned:
+		return http.StatusForbidden
+	case game.ErrInvalidUsername, game.ErrReservedUsername:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func (d *Director) fail(w http.ResponseWriter, err error, action string) {
+	status := statusOf(err)
+	if status == http.StatusInternalServerError {
+		log.Printf("Failed to %s: %v", action, err)
+		http.Error(w, "Failed to "+action, status)
+		return
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// GetTournaments serves /api/tournaments. Parameters: status.
+func (d *Director) GetTournaments(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status != "" && !statuses[status] {
+		http.Error(w, "unknown status", http.StatusBadRequest)
+		return
+	}
+
+	tournaments, err := d.List(status)
+	if err != nil {
+		d.fail(w, err, "fetch tournaments")
+		return
+	}
+
+	summaries := make([]Summary, len(tournaments))
+	for i, t := range tournaments {
+		summaries[i] = summarize(t)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"tournaments": summaries})
+}
+
+// GetTournament serves /api/tournaments/{id}.
+func (d *Director) GetTournament(w http.ResponseWriter, r *http.Request) {
+	t, err := d.Get(mux.Vars(r)["id"])
+	if err != nil {
+		d.fail(w, err, "fetch tournament")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tournament": t,
+		"standings":  Standings(t),
+	})
+}
+
+// GetStandings serves /api/tournaments/{id}/standings.
+func (d *Director) GetStandings(w http.ResponseWriter, r *http.Request) {
+	t, err := d.Get(mux.Vars(r)["id"])
+	if err != nil {
+		d.fail(w, err, "fetch standings")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    t.Status,
+		"round":     t.Round,
+		"standings": Standings(t),
+	})
+}
+
+// RegisterPlayer serves POST /api/tournaments/{id}/players, whose body
+// names the player: {"username": "..."}.
+func (d *Director) RegisterPlayer(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&body); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	t, err := d.Register(mux.Vars(r)["id"], body.Username)
+	if err != nil {
+		d.fail(w, err, "register player")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"tournament": summarize(t)})
+}
+
+// WithdrawPlayer serves DELETE /api/tournaments/{id}/players/{username}.
+// Players can only withdraw before the tournament starts.
+func (d *Director) WithdrawPlayer(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if _, err := d.Withdraw(vars["id"], strings.TrimSpace(vars["username"])); err != nil {
+		d.fail(w, err, "withdraw player")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
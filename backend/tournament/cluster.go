@@ -0,0 +1,125 @@
+package tournament
+
+import (
+	"connect4-backend/game"
+	"encoding/json"
+)
+
+// In cluster mode the node that owns directorKey keeps every tournament
+// and hosts their games. Other nodes forward calls to it.
+
+const directorKey = "tournaments"
+
+// remoteErrors are the errors forwarded calls can return. They cross the
+// cluster RPC as text, and are matched back up here.
+var remoteErrors = []error{
+	ErrTournamentNotFound, ErrUnknownFormat, ErrNameRequired, ErrInvalidRounds,
+	ErrInvalidMaxPlayers, ErrRegistrationClosed, ErrTournamentFull, ErrNotRegistered,
+	ErrNotEnoughPlayers, ErrAlreadyStarted, ErrTournamentOver,
+	game.ErrBanned, game.ErrInvalidUsername, game.ErrReservedUsername, game.ErrInvalidTimeControl, game.ErrUnknownVariant,
+}
+
+type directorArgs struct {
+	ID       string    `json:"id,omitempty"`
+	Username string    `json:"username,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	Status   string    `json:"status,omitempty"`
+	Settings *Settings `json:"settings,omitempty"`
+}
+
+// serveCluster registers the calls other nodes make on the director.
+func (d *Director) serveCluster() {
+	d.cluster.Handle("tournament.create", d.handleCreate)
+	d.cluster.Handle("tournament.register", d.handleRegister)
+	d.cluster.Handle("tournament.withdraw", d.handleWithdraw)
+	d.cluster.Handle("tournament.start", d.handleStart)
+	d.cluster.Handle("tournament.cancel", d.handleCancel)
+	d.cluster.Handle("tournament.get", d.handleGet)
+	d.cluster.Handle("tournament.list", d.handleList)
+}
+
+func readArgs(body json.RawMessage) (directorArgs, error) {
+	var args directorArgs
+	err := json.Unmarshal(body, &args)
+	return args, err
+}
+
+func (d *Director) handleCreate(body json.RawMessage) (interface{}, error) {
+	args, err := readArgs(body)
+	if err != nil {
+		return nil, err
+	}
+	if args.Settings == nil {
+		return nil, ErrNameRequired
+	}
+	return d.Create(*args.Settings, args.Username)
+}
+
+func (d *Director) handleRegister(body json.RawMessage) (interface{}, error) {
+	args, err := readArgs(body)
+	if err != nil {
+		return nil, err
+	}
+	return d.Register(args.ID, args.Username)
+}
+
+func (d *Director) handleWithdraw(body json.RawMessage) (interface{}, error) {
+	args, err := readArgs(body)
+	if err != nil {
+		return nil, err
+	}
+	return d.Withdraw(args.ID, args.Username)
+}
+
+func (d *Director) handleStart(body json.RawMessage) (interface{}, error) {
+	args, err := readArgs(body)
+	if err != nil {
+		return nil, err
+	}
+	return d.Start(args.ID)
+}
+
+func (d *Director) handleCancel(body json.RawMessage) (interface{}, error) {
+	args, err := readArgs(body)
+	if err != nil {
+		return nil, err
+	}
+	return d.Cancel(args.ID, args.Reason)
+}
+
+func (d *Director) handleGet(body json.RawMessage) (interface{}, error) {
+	args, err := readArgs(body)
+	if err != nil {
+		return nil, err
+	}
+	return d.Get(args.ID)
+}
+
+func (d *Director) handleList(body json.RawMessage) (interface{}, error) {
+	args, err := readArgs(body)
+	if err != nil {
+		return nil, err
+	}
+	return d.List(args.Status)
+}
+
+// forward makes a call on the node keeping the tournaments.
+func (d *Director) forward(method string, args directorArgs) (*Tournament, error) {
+	var reply *Tournament
+	if err := d.call(method, args, &reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (d *Director) call(method string, args directorArgs, reply interface{}) error {
+	err := d.cluster.Call(d.cluster.Owner(directorKey), method, args, reply)
+	if err != nil {
+		for _, known := range remoteErrors {
+			if err.Error() == known.Error() {
+				return known
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,250 @@
+package tournament
+
+// pairingBudget caps the steps spent looking for Swiss pairings without
+// rematches, so a hard round cannot stall the director.
+const pairingBudget = 100000
+
+// swissPairings pairs players with the same score where it can, top half
+// against bottom half as in the Dutch system, and never pairs players who
+// have met unless there is no other way. With an odd number of players the
+// lowest ranked player who has not had a bye gets one.
+func swissPairings(t *Tournament) []*Pairing {
+	standings := Standings(t)
+	ranked := make([]string, len(standings))
+	points := make(map[string]float64)
+	for i, s := range standings {
+		ranked[i] = s.Username
+		points[s.Username] = s.Points
+	}
+
+	met := make(map[[2]string]bool)
+	hadBye := make(map[string]bool)
+	for _, round := range t.Schedule {
+		for _, p := range round.Pairings {
+			if p.Player2 == "" {
+				hadBye[p.Player1] = true
+			} else {
+				met[meeting(p.Player1, p.Player2)] = true
+			}
+		}
+	}
+
+	// Who may sit out, most deserving first: from the bottom up, those
+	// who have already had a bye last
+	var byes []int
+	if len(ranked)%2 == 1 {
+		for _, repeat := range []bool{false, true} {
+			for i := len(ranked) - 1; i >= 0; i-- {
+				if hadBye[ranked[i]] == repeat {
+					byes = append(byes, i)
+				}
+			}
+		}
+	}
+
+	for _, rematches := range []bool{false, true} {
+		budget := pairingBudget
+		if len(byes) == 0 {
+			if pairs, ok := pairUp(ranked, points, met, rematches, &budget); ok {
+				return orient(t, pairs, "")
+			}
+			continue
+		}
+
+		for _, i := range byes {
+			rest := append(append([]string{}, ranked[:i]...), ranked[i+1:]...)
+			if pairs, ok := pairUp(rest, points, met, rematches, &budget); ok {
+				return orient(t, pairs, ranked[i])
+			}
+		}
+	}
+
+	// Unreachable: with rematches allowed any players can be paired
+	return nil
+}
+
+// pairUp pairs players, who are in ranking order, by backtracking. The
+// best placed player takes the first opponent that leaves the rest
+// pairable.
+func pairUp(players []string, points map[string]float64, met map[[2]string]bool, rematches bool, budget *int) ([][2]string, bool) {
+	if len(players) == 0 {
+		return nil, true
+	}
+
+	top := players[0]
+	for _, i := range candidates(players, points) {
+		opponent := players[i]
+		if !rematches && met[meeting(top, opponent)] {
+			continue
+		}
+		if *budget--; *budget < 0 {
+			return nil, false
+		}
+
+		rest := make([]string, 0, len(players)-2)
+		for j, player := range players[1:] {
+			if j+1 != i {
+				rest = append(rest, player)
+			}
+		}
+		if pairs, ok := pairUp(rest, points, met, rematches, budget); ok {
+			return append([][2]string{{top, opponent}}, pairs...), true
+		}
+	}
+	return nil, false
+}
+
+// candidates orders the opponents of players[0] by preference: first its
+// own score group, starting halfway down it, then everyone below.
+func candidates(players []string, points map[string]float64) []int {
+	group := 1
+	for group < len(players) && points[players[group]] == points[players[0]] {
+		group++
+	}
+
+	order := make([]int, 0, len(players)-1)
+	half := group / 2
+	if half == 0 {
+		half = 1
+	}
+	for i := half; i < group; i++ {
+		order = append(order, i)
+	}
+	for i := half - 1; i >= 1; i-- {
+		order = append(order, i)
+	}
+	for i := group; i < len(players); i++ {
+		order = append(order, i)
+	}
+	return order
+}
+
+func meeting(a, b string) [2]string {
+	if b < a {
+		a, b = b, a
+	}
+	return [2]string{a, b}
+}
+
+// roundRobinPairings pairs the current round of a round robin by the
+// circle method: the top seed stays put while everyone else moves round
+// one place each round. An odd number of players leaves one with a bye.
+func roundRobinPairings(t *Tournament) []*Pairing {
+	seats := make([]string, len(t.Players))
+	for i, entrant := range t.Players {
+		seats[i] = entrant.Username
+	}
+	if len(seats)%2 == 1 {
+		seats = append(seats, "")
+	}
+
+	n := len(seats)
+	shift := (t.Round - 1) % (n - 1)
+	circle := append([]string{seats[0]}, seats[1+shift:]...)
+	circle = append(circle, seats[1:1+shift]...)
+
+	var pairs [][2]string
+	bye := ""
+	for i := 0; i < n/2; i++ {
+		a, b := circle[i], circle[n-1-i]
+		switch {
+		case a == "":
+			bye = b
+		case b == "":
+			bye = a
+		default:
+			pairs = append(pairs, [2]string{a, b})
+		}
+	}
+	return orient(t, pairs, bye)
+}
+
+// knockoutPairings pairs the current knockout round. The first round
+// follows the usual bracket, where the top seeds can only meet late and
+// get the byes when the field is not a power of two; after that the
+// winners of neighbouring pairings meet.
+func knockoutPairings(t *Tournament) []*Pairing {
+	if t.Round == 1 {
+		size := 1
+		for size < len(t.Players) {
+			size *= 2
+		}
+
+		var pairings []*Pairing
+		order := bracketOrder(size)
+		for i := 0; i < size; i += 2 {
+			a, b := order[i], order[i+1]
+			if b > len(t.Players) {
+				pairings = append(pairings, &Pairing{Player1: t.Players[a-1].Username})
+				continue
+			}
+			pairings = append(pairings, orient(t, [][2]string{{t.Players[a-1].Username, t.Players[b-1].Username}}, "")...)
+		}
+		return pairings
+	}
+
+	previous := t.Schedule[t.Round-2].Pairings
+	var pairings []*Pairing
+	for i := 0; i+1 < len(previous); i += 2 {
+		pairings = append(pairings, orient(t, [][2]string{{previous[i].Result, previous[i+1].Result}}, "")...)
+	}
+	return pairings
+}
+
+// bracketOrder returns the seeds 1 to size in bracket order, so that
+// neighbours play each other first and seeds 1 and 2 can only meet in the
+// final.
+func bracketOrder(size int) []int {
+	order := []int{1}
+	for n := 2; n <= size; n *= 2 {
+		next := make([]int, 0, n)
+		for _, seed := range order {
+			next = append(next, seed, n+1-seed)
+		}
+		order = next
+	}
+	return order
+}
+
+// orient turns pairs into pairings, deciding who moves first: the player
+// who has moved first less often, then the one who did not move first
+// last time, then the higher seed. The pairings keep the pairs' order,
+// followed by the bye if there is one.
+func orient(t *Tournament, pairs [][2]string, bye string) []*Pairing {
+	firsts := make(map[string]int)
+	last := make(map[string]bool) // Whether the player moved first in their latest game
+	for _, round := range t.Schedule {
+		for _, p := range round.Pairings {
+			if p.Player2 == "" {
+				continue
+			}
+			firsts[p.Player1]++
+			last[p.Player1] = true
+			last[p.Player2] = false
+		}
+	}
+
+	pairings := make([]*Pairing, 0, len(pairs)+1)
+	for _, pair := range pairs {
+		a, b := pair[0], pair[1]
+		switch {
+		case firsts[a] != firsts[b]:
+			if firsts[b] < firsts[a] {
+				a, b = b, a
+			}
+		case last[a] != last[b]:
+			if last[a] {
+				a, b = b, a
+			}
+		default:
+			if t.higherSeed(a, b) == b {
+				a, b = b, a
+			}
+		}
+		pairings = append(pairings, &Pairing{Player1: a, Player2: b})
+	}
+	if bye != "" {
+		pairings = append(pairings, &Pairing{Player1: bye})
+	}
+	return pairings
+}
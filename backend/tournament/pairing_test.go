@@ -0,0 +1,133 @@
+package tournament
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// newTestTournament returns a tournament of the given players, seeded in
+// order, with rounds made of pairings written "winner>loser", "a=b" for a
+// draw and "a" for a bye.
+func newTestTournament(format string, players []string, rounds ...[]string) *Tournament {
+	t := &Tournament{Settings: Settings{Format: format}}
+	for i, username := range players {
+		t.Players = append(t.Players, &Entrant{Username: username, Seed: i + 1})
+	}
+	for i, pairings := range rounds {
+		round := &Round{Number: i + 1}
+		for _, p := range pairings {
+			round.Pairings = append(round.Pairings, parsePairing(p))
+		}
+		t.Schedule = append(t.Schedule, round)
+	}
+	t.Round = len(rounds) + 1
+	return t
+}
+
+func parsePairing(p string) *Pairing {
+	for i, c := range p {
+		switch c {
+		case '>':
+			return &Pairing{Player1: p[:i], Player2: p[i+1:], Result: p[:i]}
+		case '=':
+			return &Pairing{Player1: p[:i], Player2: p[i+1:], Result: "draw"}
+		}
+	}
+	return &Pairing{Player1: p, Result: p}
+}
+
+// describe writes pairings as sorted "a-b" meetings and the bye.
+func describe(pairings []*Pairing) ([]string, string) {
+	var meetings []string
+	bye := ""
+	for _, p := range pairings {
+		if p.Player2 == "" {
+			bye = p.Player1
+			continue
+		}
+		m := meeting(p.Player1, p.Player2)
+		meetings = append(meetings, m[0]+"-"+m[1])
+	}
+	sort.Strings(meetings)
+	return meetings, bye
+}
+
+func TestSwissPairings(t *testing.T) {
+	tests := []struct {
+		name     string
+		players  []string
+		rounds   [][]string
+		meetings []string
+		bye      string
+	}{
+		{
+			name:     "first round top half against bottom half",
+			players:  []string{"a", "b", "c", "d"},
+			meetings: []string{"a-c", "b-d"},
+		},
+		{
+			name:     "no rematch while an alternative exists",
+			players:  []string{"a", "b", "c", "d"},
+			rounds:   [][]string{{"a=c", "b=d"}, {"a=b", "c=d"}},
+			meetings: []string{"a-d", "b-c"},
+		},
+		{
+			name:     "rematch when there is no alternative",
+			players:  []string{"a", "b"},
+			rounds:   [][]string{{"a>b"}},
+			meetings: []string{"a-b"},
+		},
+		{
+			name:     "lowest ranked player gets the bye",
+			players:  []string{"a", "b", "c", "d", "e"},
+			rounds:   [][]string{{"a>b", "c>d", "e"}},
+			meetings: []string{"a-c", "b-e"},
+			bye:      "d",
+		},
+		{
+			name:     "lowest ranked player without a bye gets the bye",
+			players:  []string{"a", "b", "c", "d", "e"},
+			rounds:   [][]string{{"a>b", "c>d", "e"}, {"a>c", "b>e", "d"}},
+			meetings: []string{"a-e", "b-d"},
+			bye:      "c",
+		},
+		{
+			name:     "bye repeats when everyone else has had one",
+			players:  []string{"a", "b", "c"},
+			rounds:   [][]string{{"a>b", "c"}, {"a>c", "b"}},
+			meetings: []string{"b-c"},
+			bye:      "a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meetings, bye := describe(swissPairings(newTestTournament(Swiss, tt.players, tt.rounds...)))
+			if !reflect.DeepEqual(meetings, tt.meetings) {
+				t.Errorf("meetings = %v, want %v", meetings, tt.meetings)
+			}
+			if bye != tt.bye {
+				t.Errorf("bye = %q, want %q", bye, tt.bye)
+			}
+		})
+	}
+}
+
+func TestBracketOrder(t *testing.T) {
+	tests := []struct {
+		size int
+		want []int
+	}{
+		{1, []int{1}},
+		{2, []int{1, 2}},
+		{4, []int{1, 4, 2, 3}},
+		{8, []int{1, 8, 4, 5, 2, 7, 3, 6}},
+	}
+
+	for _, tt := range tests {
+		if got := bracketOrder(tt.size); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("bracketOrder(%d) = %v, want %v", tt.size, got, tt.want)
+		}
+	}
+}
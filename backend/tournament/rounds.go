@@ -0,0 +1,374 @@
+package tournament
+
+import (
+	"connect4-backend/game"
+	"connect4-backend/store"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// How rounds are played. Everything here runs with the director's lock
+// held, and only collects the notices to send once it is released.
+
+// startRound pairs the next round and starts its games.
+func (d *Director) startRound(t *Tournament, notices *[]notice) {
+	t.Round++
+	round := &Round{Number: t.Round, StartedAt: time.Now().UTC()}
+	switch t.Format {
+	case Swiss:
+		round.Pairings = swissPairings(t)
+	case RoundRobin:
+		round.Pairings = roundRobinPairings(t)
+	case Knockout:
+		round.Pairings = knockoutPairings(t)
+	}
+	t.Schedule = append(t.Schedule, round)
+	log.Printf("Tournament %s: round %d of %d, %d pairings", t.ID, t.Round, t.Rounds, len(round.Pairings))
+
+	for _, p := range round.Pairings {
+		if p.Player2 != "" {
+			d.play(t, p, p.Player1, p.Player2, notices)
+			continue
+		}
+
+		p.Result = p.Player1
+		*notices = append(*notices, notice{p.Player1, "tournament_bye", map[string]interface{}{
+			"tournamentId": t.ID,
+			"name":         t.Name,
+			"round":        t.Round,
+		}})
+	}
+}
+
+// play starts a pairing's game, with first moving first, and tells both
+// players it is ready. A banned player forfeits instead.
+func (d *Director) play(t *Tournament, p *Pairing, first, second string, notices *[]notice) {
+	g, err := d.games.CreateMatch(game.Match{
+		Player1:    first,
+		Player2:    second,
+		Prefs:      t.prefs(),
+		Tournament: t.ID,
+		ShowUp:     showUpTime,
+	})
+	if err != nil {
+		log.Printf("Tournament %s: could not start %s vs %s: %v", t.ID, first, second, err)
+		banned1, banned2 := d.games.IsBanned(first), d.games.IsBanned(second)
+		switch {
+		case banned1 && !banned2:
+			p.Result = second
+		case banned2 && !banned1:
+			p.Result = first
+		case t.Format == Knockout:
+			p.Result = t.higherSeed(first, second)
+		default:
+			p.Result = "none"
+		}
+		p.Forfeit = true
+		return
+	}
+
+	p.GameID = g.ID
+	p.Games = append(p.Games, g.ID)
+	*notices = append(*notices, readyNotice(t, p, g, first), readyNotice(t, p, g, second))
+}
+
+// readyNotice tells a player their game in a tournament is waiting for
+// them.
+func readyNotice(t *Tournament, p *Pairing, g *game.Game, username string) notice {
+	player, opponent := game.PLAYER1, g.Player2.Username
+	if g.Player2.Username == username {
+		player, opponent = game.PLAYER2, g.Player1.Username
+	}
+	return notice{username, "tournament_game_ready", map[string]interface{}{
+		"tournamentId": t.ID,
+		"name":         t.Name,
+		"round":        t.Round,
+		"gameId":       g.ID,
+		"opponent":     opponent,
+		"player":       player,
+		"replay":       len(p.Games) > 1,
+		"deadline":     g.CreatedAt.Add(showUpTime).UnixMilli(),
+	}}
+}
+
+// remind sends a player their game in the round being played again.
+func (d *Director) remind(t *Tournament, username string) []notice {
+	for _, p := range t.pairings() {
+		if p.Result != "" || p.GameID == "" || (p.Player1 != username && p.Player2 != username) {
+			continue
+		}
+		if g, exists := d.games.GetGame(p.GameID); exists && g.Status == "playing" {
+			return []notice{readyNotice(t, p, g, username)}
+		}
+	}
+	return nil
+}
+
+// onGameEvent records the results of tournament games. A player who never
+// turned up forfeits; a game an admin aborted is played again.
+func (d *Director) onGameEvent(event game.Event) {
+	var g *game.Game
+	absent, aborted := "", false
+	switch e := event.(type) {
+	case game.GameFinished:
+		g = e.Game
+	case game.GameAborted:
+		g, absent, aborted = e.Game, e.Player, true
+	default:
+		return
+	}
+	if g.Tournament == "" || g.Player2 == nil {
+		return
+	}
+
+	d.mutex.Lock()
+	t, exists := d.tournaments[g.Tournament]
+	if !exists || t.Status != "running" {
+		// Games of a cancelled tournament are aborted on the way out
+		d.mutex.Unlock()
+		return
+	}
+	p := t.pairingFor(g.ID)
+	if p == nil {
+		d.mutex.Unlock()
+		return
+	}
+
+	var notices []notice
+	switch {
+	case aborted && absent != "":
+		p.Result = g.Player1.Username
+		if absent == p.Result {
+			p.Result = g.Player2.Username
+		}
+		p.Forfeit = true
+	case aborted:
+		log.Printf("Tournament %s: replaying game %s, which was aborted", t.ID, g.ID)
+		d.play(t, p, g.Player1.Username, g.Player2.Username, &notices)
+	default:
+		d.gameEnded(t, p, g.Player1.Username, g.Player2.Username, winnerOf(g), g.EndReason == "disconnect", &notices)
+	}
+	d.advance(t, &notices)
+	d.save(t)
+	d.mutex.Unlock()
+
+	d.notify(notices)
+}
+
+// gameEnded records the result of a pairing's game between first and
+// second. Drawn knockout games are replayed with colours reversed, and
+// the higher seed goes through once the replays run out.
+func (d *Director) gameEnded(t *Tournament, p *Pairing, first, second, winner string, forfeit bool, notices *[]notice) {
+	if winner == "draw" && t.Format == Knockout {
+		if p.Replays < maxReplays {
+			p.Replays++
+			d.play(t, p, second, first, notices)
+			return
+		}
+		winner = t.higherSeed(first, second)
+		log.Printf("Tournament %s: %s goes through as the higher seed after %d draws", t.ID, winner, p.Replays+1)
+	}
+	p.Result = winner
+	p.Forfeit = forfeit
+}
+
+// advance moves on to the next round once every pairing in the current
+// one is decided, and finishes the tournament after its last round.
+func (d *Director) advance(t *Tournament, notices *[]notice) {
+	for t.Status == "running" {
+		round := t.Schedule[t.Round-1]
+		for _, p := range round.Pairings {
+			if p.Result == "" {
+				return
+			}
+		}
+
+		now := time.Now().UTC()
+		round.FinishedAt = &now
+		if t.Format == Knockout {
+			for _, p := range round.Pairings {
+				if p.Player2 == "" {
+					continue
+				}
+				loser := p.Player1
+				if p.Result == loser {
+					loser = p.Player2
+				}
+				t.entrant(loser).Eliminated = t.Round
+			}
+		}
+
+		if t.Round >= t.Rounds {
+			d.finish(t, notices)
+			return
+		}
+		d.startRound(t, notices)
+	}
+}
+
+func (d *Director) finish(t *Tournament, notices *[]notice) {
+	standings := Standings(t)
+	now := time.Now().UTC()
+	t.Status = "finished"
+	t.FinishedAt = &now
+	t.Winner = standings[0].Username
+
+	for _, s := range standings {
+		*notices = append(*notices, notice{s.Username, "tournament_finished", map[string]interface{}{
+			"tournamentId": t.ID,
+			"name":         t.Name,
+			"winner":       t.Winner,
+			"rank":         s.Rank,
+			"points":       s.Points,
+		}})
+	}
+	log.Printf("Tournament %s won by %s", t.ID, t.Winner)
+}
+
+// pairingFor returns the undecided pairing of the round being played
+// whose latest game is gameID.
+func (t *Tournament) pairingFor(gameID string) *Pairing {
+	for _, p := range t.pairings() {
+		if p.GameID == gameID && p.Result == "" {
+			return p
+		}
+	}
+	return nil
+}
+
+func (t *Tournament) higherSeed(a, b string) string {
+	if t.entrant(b).Seed < t.entrant(a).Seed {
+		return b
+	}
+	return a
+}
+
+func winnerOf(g *game.Game) string {
+	switch g.Winner {
+	case game.PLAYER1:
+		return g.Player1.Username
+	case game.PLAYER2:
+		return g.Player2.Username
+	}
+	return "draw"
+}
+
+// load reads the tournaments from the store, before the director is in
+// use. Running tournaments pick up where they were: results that came in
+// while the server was down are recorded, and games lost with it are
+// played again.
+func (d *Director) load() {
+	states, err := d.store.Tournaments()
+	if err != nil {
+		log.Printf("Failed to load tournaments: %v", err)
+		return
+	}
+
+	resumed := 0
+	for _, state := range states {
+		t := &Tournament{}
+		if err := json.Unmarshal(state, t); err != nil {
+			log.Printf("Skipping unreadable tournament: %v", err)
+			continue
+		}
+		d.tournaments[t.ID] = t
+		if t.Status == "running" {
+			d.resume(t)
+			resumed++
+		}
+	}
+
+	if resumed > 0 {
+		log.Printf("Resumed %d tournaments", resumed)
+	}
+}
+
+// resume catches up with the games of a running tournament's current
+// round. Players are not notified: registering again reminds them of
+// their game.
+func (d *Director) resume(t *Tournament) {
+	var notices []notice
+	for _, p := range t.pairings() {
+		if p.Result != "" {
+			continue
+		}
+
+		if g, exists := d.games.GetGame(p.GameID); exists {
+			switch g.Status {
+			case "playing":
+			case "finished":
+				d.gameEnded(t, p, g.Player1.Username, g.Player2.Username, winnerOf(g), g.EndReason == "disconnect", &notices)
+			default:
+				d.play(t, p, g.Player1.Username, g.Player2.Username, &notices)
+			}
+			continue
+		}
+
+		record, err := d.games.FinishedGame(p.GameID)
+		if err != nil {
+			d.play(t, p, p.Player1, p.Player2, &notices)
+			continue
+		}
+		d.gameEnded(t, p, record.Player1, record.Player2, record.Winner, record.EndReason == "disconnect", &notices)
+	}
+	d.advance(t, &notices)
+	d.save(t)
+}
+
+// save queues a write of a tournament's state. Callers must hold mutex.
+func (d *Director) save(t *Tournament) {
+	state, err := json.Marshal(t)
+	if err != nil {
+		log.Printf("Failed to save tournament %s: %v", t.ID, err)
+		return
+	}
+	d.saves.queue(t.ID, state)
+}
+
+// saver writes tournament states in the background, so a slow store never
+// holds up the director; only each tournament's latest state waits.
+type saver struct {
+	store   store.Store
+	mutex   sync.Mutex
+	pending map[string][]byte // Latest unwritten state, keyed by tournament ID
+	wake    chan struct{}
+}
+
+func newSaver(st store.Store) *saver {
+	s := &saver{
+		store:   st,
+		pending: make(map[string][]byte),
+		wake:    make(chan struct{}, 1),
+	}
+	go s.run()
+	return s
+}
+
+func (s *saver) queue(id string, state []byte) {
+	s.mutex.Lock()
+	s.pending[id] = state
+	s.mutex.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+		// Already woken; the writer will pick this up too
+	}
+}
+
+func (s *saver) run() {
+	for range s.wake {
+		s.mutex.Lock()
+		pending := s.pending
+		s.pending = make(map[string][]byte)
+		s.mutex.Unlock()
+
+		for id, state := range pending {
+			if err := s.store.SaveTournament(id, state); err != nil {
+				log.Printf("Failed to save tournament %s: %v", id, err)
+			}
+		}
+	}
+}
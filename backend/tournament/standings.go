@@ -0,0 +1,131 @@
+package tournament
+
+import "sort"
+
+// Standing is a player's place in a tournament. Wins and byes score a
+// point and draws half a point. Ties on points are broken by Buchholz, the
+// sum of the opponents' points, and then by Sonneborn-Berger, the points
+// of the opponents beaten plus half the points of those drawn with;
+// players still level share a rank. In a knockout players are ranked by
+// how far they got.
+type Standing struct {
+	Rank            int     `json:"rank"`
+	Username        string  `json:"username"`
+	Seed            int     `json:"seed"`
+	Rating          float64 `json:"rating"`
+	Points          float64 `json:"points"`
+	Played          int     `json:"played"`
+	Wins            int     `json:"wins"`
+	Draws           int     `json:"draws"`
+	Losses          int     `json:"losses"`
+	Byes            int     `json:"byes"`
+	Buchholz        float64 `json:"buchholz"`
+	SonnebornBerger float64 `json:"sonnebornBerger"`
+	Eliminated      int     `json:"eliminated,omitempty"`
+}
+
+type outcome struct {
+	opponent string
+	score    float64
+}
+
+// Standings ranks a tournament's players on the pairings decided so far.
+func Standings(t *Tournament) []Standing {
+	standings := make([]Standing, len(t.Players))
+	index := make(map[string]int)
+	for i, entrant := range t.Players {
+		standings[i] = Standing{
+			Username:   entrant.Username,
+			Seed:       entrant.Seed,
+			Rating:     entrant.Rating,
+			Eliminated: entrant.Eliminated,
+		}
+		index[entrant.Username] = i
+	}
+
+	outcomes := make(map[string][]outcome)
+	for _, round := range t.Schedule {
+		for _, p := range round.Pairings {
+			if p.Result == "" {
+				continue
+			}
+			if p.Player2 == "" {
+				s := &standings[index[p.Player1]]
+				s.Byes++
+				s.Points++
+				continue
+			}
+
+			score1, score2 := 0.0, 0.0
+			switch p.Result {
+			case p.Player1:
+				score1 = 1
+			case p.Player2:
+				score2 = 1
+			case "draw":
+				score1, score2 = 0.5, 0.5
+			}
+			outcomes[p.Player1] = append(outcomes[p.Player1], outcome{p.Player2, score1})
+			outcomes[p.Player2] = append(outcomes[p.Player2], outcome{p.Player1, score2})
+		}
+	}
+
+	for i := range standings {
+		s := &standings[i]
+		for _, o := range outcomes[s.Username] {
+			s.Played++
+			s.Points += o.score
+			switch o.score {
+			case 1:
+				s.Wins++
+			case 0.5:
+				s.Draws++
+			default:
+				s.Losses++
+			}
+		}
+	}
+	for i := range standings {
+		s := &standings[i]
+		for _, o := range outcomes[s.Username] {
+			opponent := standings[index[o.opponent]].Points
+			s.Buchholz += opponent
+			s.SonnebornBerger += o.score * opponent
+		}
+	}
+
+	knockout := t.Format == Knockout
+	sort.SliceStable(standings, func(i, j int) bool {
+		a, b := standings[i], standings[j]
+		if knockout && a.Eliminated != b.Eliminated {
+			// Still in beats knocked out, and later beats earlier
+			return a.Eliminated == 0 || (b.Eliminated != 0 && a.Eliminated > b.Eliminated)
+		}
+		if !tied(a, b, knockout) {
+			if a.Points != b.Points {
+				return a.Points > b.Points
+			}
+			if a.Buchholz != b.Buchholz {
+				return a.Buchholz > b.Buchholz
+			}
+			return a.SonnebornBerger > b.SonnebornBerger
+		}
+		return a.Seed < b.Seed
+	})
+
+	for i := range standings {
+		standings[i].Rank = i + 1
+		if i > 0 && tied(standings[i-1], standings[i], knockout) {
+			standings[i].Rank = standings[i-1].Rank
+		}
+	}
+	return standings
+}
+
+// tied reports whether two players share a rank.
+func tied(a, b Standing, knockout bool) bool {
+	if knockout {
+		return a.Eliminated == b.Eliminated && a.Eliminated != 0
+	}
+	return a.Points == b.Points && a.Buchholz == b.Buchholz && a.SonnebornBerger == b.SonnebornBerger
+}
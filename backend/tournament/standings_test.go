@@ -0,0 +1,56 @@
+package tournament
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStandingsRanks(t *testing.T) {
+	tests := []struct {
+		name       string
+		format     string
+		players    []string
+		rounds     [][]string
+		eliminated map[string]int
+		want       map[string]int
+	}{
+		{
+			name:    "tied players share a rank",
+			format:  Swiss,
+			players: []string{"a", "b", "c", "d"},
+			rounds:  [][]string{{"a>c", "b>d"}},
+			want:    map[string]int{"a": 1, "b": 1, "c": 3, "d": 3},
+		},
+		{
+			name:    "Buchholz breaks a tie on points",
+			format:  Swiss,
+			players: []string{"a", "b", "c", "d"},
+			rounds:  [][]string{{"a>b", "c>d"}, {"a>d", "b>c"}},
+			want:    map[string]int{"a": 1, "b": 2, "c": 3, "d": 4},
+		},
+		{
+			name:       "knockout players out in the same round share a rank",
+			format:     Knockout,
+			players:    []string{"a", "b", "c", "d"},
+			eliminated: map[string]int{"b": 2, "c": 1, "d": 1},
+			want:       map[string]int{"a": 1, "b": 2, "c": 3, "d": 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tournament := newTestTournament(tt.format, tt.players, tt.rounds...)
+			for _, entrant := range tournament.Players {
+				entrant.Eliminated = tt.eliminated[entrant.Username]
+			}
+
+			ranks := make(map[string]int)
+			for _, s := range Standings(tournament) {
+				ranks[s.Username] = s.Rank
+			}
+			if !reflect.DeepEqual(ranks, tt.want) {
+				t.Errorf("ranks = %v, want %v", ranks, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,569 @@
+// Package tournament runs tournaments. Players register, and once the
+// tournament starts the director pairs them round by round in the Swiss,
+// round-robin or knockout format, starts their games through the game
+// manager and records each result as the game ends.
+package tournament
+
+import (
+	"connect4-backend/cluster"
+	"connect4-backend/game"
+	"connect4-backend/store"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Formats
+const (
+	Swiss      = "swiss"
+	RoundRobin = "roundRobin"
+	Knockout   = "knockout"
+)
+
+const (
+	// showUpTime is how long players have to take their seat in a
+	// tournament game before they forfeit it.
+	showUpTime = 2 * time.Minute
+
+	// maxReplays is how many times a drawn knockout game is replayed, with
+	// colours reversed, before the higher seed goes through.
+	maxReplays = 2
+
+	maxNameLength = 100
+	maxRounds     = 50
+
+	// How often tournaments with a start time are checked
+	scheduleInterval = 10 * time.Second
+)
+
+var (
+	ErrTournamentNotFound = errors.New("tournament not found")
+	ErrUnknownFormat      = errors.New(`format must be "swiss", "roundRobin" or "knockout"`)
+	ErrNameRequired       = errors.New("a tournament name is required")
+	ErrInvalidRounds      = errors.New("rounds can only be chosen for Swiss tournaments, up to 50")
+	ErrInvalidMaxPlayers  = errors.New("maxPlayers must be 0, for no limit, or at least 2")
+	ErrRegistrationClosed = errors.New("registration is closed")
+	ErrTournamentFull     = errors.New("tournament is full")
+	ErrNotRegistered      = errors.New("player is not registered")
+	ErrNotEnoughPlayers   = errors.New("a tournament needs at least 2 players")
+	ErrAlreadyStarted     = errors.New("tournament has already started")
+	ErrTournamentOver     = errors.New("tournament is over")
+)
+
+// Settings are what an admin chooses when creating a tournament. Rounds is
+// only chosen for Swiss tournaments, and is otherwise set when the
+// tournament starts; 0 leaves it to the number of players. A tournament
+// with a StartsAt time starts by itself then; others are started by an
+// admin.
+type Settings struct {
+	Name        string     `json:"name"`
+	Format      string     `json:"format"`
+	Rounds      int        `json:"rounds"`
+	MaxPlayers  int        `json:"maxPlayers"` // 0 for no limit
+	TimeControl string     `json:"timeControl"`
+	Variant     string     `json:"variant"`
+	Rated       bool       `json:"rated"`
+	StartsAt    *time.Time `json:"startsAt,omitempty"`
+}
+
+// Tournament is a tournament and everything played in it so far. Status
+// is "registering", "running", "finished" or "cancelled".
+type Tournament struct {
+	ID string `json:"id"`
+	Settings
+	Status     string     `json:"status"`
+	Round      int        `json:"round"` // The round being played, from 1
+	Players    []*Entrant `json:"players"`
+	Schedule   []*Round   `json:"schedule"`
+	Winner     string     `json:"winner,omitempty"`
+	Reason     string     `json:"reason,omitempty"` // Why it was cancelled
+	CreatedBy  string     `json:"createdBy,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// Entrant is a registered player. Seeds are handed out by rating when the
+// tournament starts, 1 being the strongest.
+type Entrant struct {
+	Username     string    `json:"username"`
+	Rating       float64   `json:"rating"` // When they registered
+	Seed         int       `json:"seed,omitempty"`
+	Eliminated   int       `json:"eliminated,omitempty"` // Knockout round they went out in
+	RegisteredAt time.Time `json:"registeredAt"`
+}
+
+type Round struct {
+	Number     int        `json:"number"`
+	Pairings   []*Pairing `json:"pairings"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// Pairing is two players meeting in a round, or a player given a bye when
+// Player2 is empty. Result is the winner's username, "draw", or "none"
+// when neither could play; it stays empty until the pairing is decided.
+type Pairing struct {
+	Player1 string   `json:"player1"` // Moves first
+	Player2 string   `json:"player2,omitempty"`
+	GameID  string   `json:"gameId,omitempty"` // The latest game
+	Games   []string `json:"games,omitempty"`  // Every game, replays included
+	Replays int      `json:"replays,omitempty"`
+	Result  string   `json:"result,omitempty"`
+	Forfeit bool     `json:"forfeit,omitempty"` // Decided without the game being played out
+}
+
+// notice is a WebSocket message for one player.
+type notice struct {
+	username    string
+	messageType string
+	data        interface{}
+}
+
+// Director runs every tournament. In cluster mode tournaments are kept by
+// the node owning directorKey, which also hosts their games and so sees
+// them end; other nodes forward calls to it.
+type Director struct {
+	games       *game.Manager
+	store       store.Store
+	cluster     *cluster.Cluster // nil when running on a single node
+	saves       *saver
+	mutex       sync.Mutex
+	tournaments map[string]*Tournament // Guarded by mutex
+	onNotify    func(username, messageType string, data interface{})
+}
+
+// NewDirector returns the director of a server's tournaments, resuming any
+// that were running when it last stopped.
+func NewDirector(games *game.Manager, st store.Store) *Director {
+	d := &Director{
+		games:       games,
+		store:       st,
+		cluster:     games.Cluster(),
+		saves:       newSaver(st),
+		tournaments: make(map[string]*Tournament),
+	}
+	if d.cluster != nil {
+		d.serveCluster()
+	}
+	if !d.here() {
+		return d
+	}
+
+	d.load()
+	games.Events().SubscribeLossless("tournaments", d.onGameEvent)
+	go d.startScheduled()
+	return d
+}
+
+// SetNotifyCallback sets how players are sent tournament messages, such as
+// their next game being ready.
+func (d *Director) SetNotifyCallback(callback func(username, messageType string, data interface{})) {
+	d.onNotify = callback
+}
+
+func (d *Director) notify(notices []notice) {
+	if d.onNotify == nil {
+		return
+	}
+	for _, n := range notices {
+		d.onNotify(n.username, n.messageType, n.data)
+	}
+}
+
+// Create opens a tournament for registration.
+func (d *Director) Create(settings Settings, createdBy string) (*Tournament, error) {
+	if !d.here() {
+		return d.forward("tournament.create", directorArgs{Settings: &settings, Username: createdBy})
+	}
+
+	settings, err := settings.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Tournament{
+		ID:        uuid.New().String(),
+		Settings:  settings,
+		Status:    "registering",
+		Players:   []*Entrant{},
+		Schedule:  []*Round{},
+		CreatedBy: createdBy,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	d.mutex.Lock()
+	d.tournaments[t.ID] = t
+	d.save(t)
+	view := t.clone()
+	d.mutex.Unlock()
+
+	log.Printf("Tournament %s (%s, %s) created by %s", t.ID, t.Name, t.Format, createdBy)
+	return view, nil
+}
+
+func (s Settings) validate() (Settings, error) {
+	s.Name = strings.TrimSpace(s.Name)
+	if s.Name == "" {
+		return s, ErrNameRequired
+	}
+	if len(s.Name) > maxNameLength {
+		s.Name = s.Name[:maxNameLength]
+	}
+
+	switch s.Format {
+	case Swiss:
+		if s.Rounds < 0 || s.Rounds > maxRounds {
+			return s, ErrInvalidRounds
+		}
+	case RoundRobin, Knockout:
+		if s.Rounds != 0 {
+			return s, ErrInvalidRounds
+		}
+	default:
+		return s, ErrUnknownFormat
+	}
+	if s.MaxPlayers < 0 || s.MaxPlayers == 1 {
+		return s, ErrInvalidMaxPlayers
+	}
+
+	prefs, err := s.prefs().Normalize()
+	if err != nil {
+		return s, err
+	}
+	s.TimeControl = prefs.TimeControl
+	s.Variant = prefs.Variant
+	return s, nil
+}
+
+func (s Settings) prefs() game.QueuePreferences {
+	return game.QueuePreferences{
+		TimeControl: s.TimeControl,
+		Variant:     s.Variant,
+		Rated:       s.Rated,
+	}
+}
+
+// Register enters a player in a tournament that has not started. A player
+// registering again once it is running is sent their game again, if they
+// have one waiting.
+func (d *Director) Register(id, username string) (*Tournament, error) {
+	if !d.here() {
+		return d.forward("tournament.register", directorArgs{ID: id, Username: username})
+	}
+
+	username, err := d.games.Admit(username)
+	if err != nil {
+		return nil, err
+	}
+	// May hit the database, so look it up before taking the lock
+	rating := d.games.PlayerRatings([]string{username})[username].Rating
+
+	d.mutex.Lock()
+	t, exists := d.tournaments[id]
+	if !exists {
+		d.mutex.Unlock()
+		return nil, ErrTournamentNotFound
+	}
+
+	var notices []notice
+	if t.entrant(username) != nil {
+		if t.Status == "running" {
+			notices = d.remind(t, username)
+		}
+	} else {
+		switch {
+		case t.Status != "registering":
+			err = ErrRegistrationClosed
+		case t.MaxPlayers > 0 && len(t.Players) >= t.MaxPlayers:
+			err = ErrTournamentFull
+		default:
+			t.Players = append(t.Players, &Entrant{
+				Username:     username,
+				Rating:       rating,
+				RegisteredAt: time.Now().UTC(),
+			})
+			d.save(t)
+			log.Printf("Player %s registered for tournament %s", username, id)
+		}
+	}
+	view := t.clone()
+	d.mutex.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	d.notify(notices)
+	return view, nil
+}
+
+// Withdraw takes a player out of a tournament that has not started.
+func (d *Director) Withdraw(id, username string) (*Tournament, error) {
+	if !d.here() {
+		return d.forward("tournament.withdraw", directorArgs{ID: id, Username: username})
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	t, exists := d.tournaments[id]
+	if !exists {
+		return nil, ErrTournamentNotFound
+	}
+	if t.Status != "registering" {
+		return nil, ErrAlreadyStarted
+	}
+
+	username = strings.TrimSpace(username)
+	for i, entrant := range t.Players {
+		if entrant.Username == username {
+			t.Players = append(t.Players[:i], t.Players[i+1:]...)
+			d.save(t)
+			log.Printf("Player %s withdrew from tournament %s", username, id)
+			return t.clone(), nil
+		}
+	}
+	return nil, ErrNotRegistered
+}
+
+// Start closes registration, seeds the players and starts the first round.
+func (d *Director) Start(id string) (*Tournament, error) {
+	if !d.here() {
+		return d.forward("tournament.start", directorArgs{ID: id})
+	}
+
+	d.mutex.Lock()
+	t, exists := d.tournaments[id]
+	if !exists {
+		d.mutex.Unlock()
+		return nil, ErrTournamentNotFound
+	}
+	if t.Status != "registering" {
+		d.mutex.Unlock()
+		if t.Status == "running" {
+			return nil, ErrAlreadyStarted
+		}
+		return nil, ErrTournamentOver
+	}
+	if len(t.Players) < 2 {
+		d.mutex.Unlock()
+		return nil, ErrNotEnoughPlayers
+	}
+
+	now := time.Now().UTC()
+	t.Status = "running"
+	t.StartedAt = &now
+	t.seed()
+	t.Rounds = roundsFor(t.Format, t.Rounds, len(t.Players))
+
+	log.Printf("Tournament %s started with %d players, %d rounds", id, len(t.Players), t.Rounds)
+	var notices []notice
+	d.startRound(t, &notices)
+	d.save(t)
+	view := t.clone()
+	d.mutex.Unlock()
+
+	d.notify(notices)
+	return view, nil
+}
+
+// seed orders the players by rating, earlier registration breaking ties,
+// and numbers them from 1.
+func (t *Tournament) seed() {
+	sort.SliceStable(t.Players, func(i, j int) bool {
+		a, b := t.Players[i], t.Players[j]
+		if a.Rating != b.Rating {
+			return a.Rating > b.Rating
+		}
+		return a.RegisteredAt.Before(b.RegisteredAt)
+	})
+	for i, entrant := range t.Players {
+		entrant.Seed = i + 1
+	}
+}
+
+// roundsFor is how many rounds a tournament of players has. A Swiss
+// tournament without a chosen number has enough rounds to single out a
+// winner, and never more than it can have without rematches.
+func roundsFor(format string, chosen, players int) int {
+	needed := int(math.Ceil(math.Log2(float64(players))))
+	switch format {
+	case RoundRobin:
+		if players%2 == 1 {
+			return players
+		}
+		return players - 1
+	case Knockout:
+		return needed
+	}
+
+	most := players - 1
+	if players%2 == 1 {
+		most = players
+	}
+	if chosen == 0 {
+		chosen = needed
+	}
+	if chosen > most {
+		chosen = most
+	}
+	return chosen
+}
+
+// Cancel ends a tournament early, aborting the games being played in it.
+func (d *Director) Cancel(id, reason string) (*Tournament, error) {
+	if !d.here() {
+		return d.forward("tournament.cancel", directorArgs{ID: id, Reason: reason})
+	}
+
+	d.mutex.Lock()
+	t, exists := d.tournaments[id]
+	if !exists {
+		d.mutex.Unlock()
+		return nil, ErrTournamentNotFound
+	}
+	if t.Status != "registering" && t.Status != "running" {
+		d.mutex.Unlock()
+		return nil, ErrTournamentOver
+	}
+
+	now := time.Now().UTC()
+	t.Status = "cancelled"
+	t.Reason = reason
+	t.FinishedAt = &now
+
+	var live []string
+	var notices []notice
+	for _, p := range t.pairings() {
+		if p.Result == "" && p.GameID != "" {
+			live = append(live, p.GameID)
+		}
+	}
+	for _, entrant := range t.Players {
+		notices = append(notices, notice{entrant.Username, "tournament_cancelled", map[string]interface{}{
+			"tournamentId": t.ID,
+			"name":         t.Name,
+			"reason":       reason,
+		}})
+	}
+	d.save(t)
+	view := t.clone()
+	d.mutex.Unlock()
+
+	// Their events are ignored now the tournament is over
+	for _, gameID := range live {
+		if _, err := d.games.AbortGame(gameID, "Tournament cancelled: "+reason); err != nil && err != game.ErrGameNotActive {
+			log.Printf("Failed to abort game %s of tournament %s: %v", gameID, id, err)
+		}
+	}
+
+	log.Printf("Tournament %s cancelled: %s", id, reason)
+	d.notify(notices)
+	return view, nil
+}
+
+// Get returns a tournament.
+func (d *Director) Get(id string) (*Tournament, error) {
+	if !d.here() {
+		return d.forward("tournament.get", directorArgs{ID: id})
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	t, exists := d.tournaments[id]
+	if !exists {
+		return nil, ErrTournamentNotFound
+	}
+	return t.clone(), nil
+}
+
+// List returns the tournaments with a status, or all of them when status
+// is empty, newest first.
+func (d *Director) List(status string) ([]*Tournament, error) {
+	if !d.here() {
+		var reply []*Tournament
+		err := d.call("tournament.list", directorArgs{Status: status}, &reply)
+		return reply, err
+	}
+
+	d.mutex.Lock()
+	list := []*Tournament{}
+	for _, t := range d.tournaments {
+		if status == "" || t.Status == status {
+			list = append(list, t.clone())
+		}
+	}
+	d.mutex.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list, nil
+}
+
+// startScheduled starts tournaments whose start time has come. One still
+// short of players then is cancelled.
+func (d *Director) startScheduled() {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		var due []string
+		d.mutex.Lock()
+		for id, t := range d.tournaments {
+			if t.Status == "registering" && t.StartsAt != nil && !t.StartsAt.After(now) {
+				due = append(due, id)
+			}
+		}
+		d.mutex.Unlock()
+
+		for _, id := range due {
+			_, err := d.Start(id)
+			if err == ErrNotEnoughPlayers {
+				d.Cancel(id, "Not enough players")
+			} else if err != nil {
+				log.Printf("Failed to start tournament %s: %v", id, err)
+			}
+		}
+	}
+}
+
+func (t *Tournament) entrant(username string) *Entrant {
+	for _, entrant := range t.Players {
+		if entrant.Username == username {
+			return entrant
+		}
+	}
+	return nil
+}
+
+// pairings returns the pairings of the round being played.
+func (t *Tournament) pairings() []*Pairing {
+	if t.Round == 0 || t.Round > len(t.Schedule) {
+		return nil
+	}
+	return t.Schedule[t.Round-1].Pairings
+}
+
+// clone returns a copy of a tournament that can be read without the
+// director's lock.
+func (t *Tournament) clone() *Tournament {
+	state, _ := json.Marshal(t)
+	copied := &Tournament{}
+	json.Unmarshal(state, copied)
+	return copied
+}
+
+// here reports whether tournaments are kept on this node.
+func (d *Director) here() bool {
+	return d.cluster == nil || d.cluster.Owns(directorKey)
+}
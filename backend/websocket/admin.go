@@ -0,0 +1,170 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxCloseReason is the longest reason a close frame can carry.
+const maxCloseReason = 123
+
+type disconnectArgs struct {
+	Username string `json:"username"`
+	Reason   string `json:"reason"`
+}
+
+type kickArgs struct {
+	Session string `json:"session"`
+	Reason  string `json:"reason"`
+}
+
+// Disconnect closes every connection of a player, on any node, giving them
+// reason. It returns how many connections were closed.
+func (h *Hub) Disconnect(username, reason string) int {
+	if h.cluster == nil {
+		return len(h.disconnectHere(username, reason))
+	}
+
+	sessions := make(map[string]bool)
+	for _, node := range h.cluster.Nodes() {
+		var closed []string
+		err := h.cluster.Call(node, "hub.disconnect", disconnectArgs{Username: username, Reason: reason}, &closed)
+		if err != nil {
+			log.Printf("Failed to disconnect %s on node %s: %v", username, node.ID, err)
+			continue
+		}
+		for _, session := range closed {
+			sessions[session] = true
+		}
+	}
+	return len(sessions)
+}
+
+// disconnectHere closes the player's connections to this node, and has
+// other nodes close the connections behind this node's proxies for them.
+// It returns the sessions closed.
+func (h *Hub) disconnectHere(username, reason string) []string {
+	var connections, proxies []*Client
+	h.mutex.RLock()
+	for client := range h.clients {
+		if client.username != username {
+			continue
+		}
+		if client.edge == "" {
+			connections = append(connections, client)
+		} else {
+			proxies = append(proxies, client)
+		}
+	}
+	h.mutex.RUnlock()
+
+	var closed []string
+	for _, client := range connections {
+		closeConnection(client.conn, reason)
+		closed = append(closed, client.session)
+	}
+	for _, proxy := range proxies {
+		edge, _ := h.cluster.Node(proxy.edge)
+		if err := h.cluster.Call(edge, "hub.kick", kickArgs{Session: proxy.session, Reason: reason}, nil); err != nil {
+			log.Printf("Failed to disconnect %s on node %s: %v", username, proxy.edge, err)
+			continue
+		}
+		closed = append(closed, proxy.session)
+	}
+
+	if len(closed) > 0 {
+		log.Printf("Disconnected %s (%d connections): %s", username, len(closed), reason)
+	}
+	return closed
+}
+
+// closeConnection sends a close frame giving reason and closes the
+// connection. The client's read pump then unregisters it as usual.
+func closeConnection(conn *websocket.Conn, reason string) {
+	if len(reason) > maxCloseReason {
+		reason = reason[:maxCloseReason]
+	}
+	message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+	conn.Close()
+}
+
+// Announce sends a maintenance notice to every connection, on every node.
+func (h *Hub) Announce(notice string) {
+	h.announceHere(notice)
+	if h.cluster != nil {
+		h.cluster.Broadcast("hub.announce", notice)
+	}
+}
+
+func (h *Hub) announceHere(notice string) {
+	data, _ := json.Marshal(Message{
+		Type: "maintenance",
+		Data: map[string]interface{}{
+			"message": notice,
+			"sentAt":  time.Now().UnixMilli(),
+		},
+	})
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	// Proxies are skipped: their connections hear it from their own node
+	sent := 0
+	for client := range h.clients {
+		if client.edge != "" {
+			continue
+		}
+		select {
+		case client.send <- data:
+			sent++
+		default:
+		}
+	}
+	log.Printf("Sent maintenance notice to %d connections", sent)
+}
+
+// serveAdmin registers the admin calls other nodes make on this hub.
+func (h *Hub) serveAdmin() {
+	h.cluster.Handle("hub.disconnect", h.handleDisconnect)
+	h.cluster.Handle("hub.kick", h.handleKick)
+	h.cluster.Handle("hub.announce", h.handleAnnounce)
+}
+
+func (h *Hub) handleDisconnect(body json.RawMessage) (interface{}, error) {
+	var args disconnectArgs
+	if err := json.Unmarshal(body, &args); err != nil {
+		return nil, err
+	}
+	return h.disconnectHere(args.Username, args.Reason), nil
+}
+
+func (h *Hub) handleKick(body json.RawMessage) (interface{}, error) {
+	var args kickArgs
+	if err := json.Unmarshal(body, &args); err != nil {
+		return nil, err
+	}
+
+	h.mutex.RLock()
+	client, exists := h.sessions[args.Session]
+	h.mutex.RUnlock()
+	if !exists || client.edge != "" {
+		return nil, errUnknownSession
+	}
+
+	closeConnection(client.conn, args.Reason)
+	return nil, nil
+}
+
+func (h *Hub) handleAnnounce(body json.RawMessage) (interface{}, error) {
+	var notice string
+	if err := json.Unmarshal(body, &notice); err != nil {
+		return nil, err
+	}
+
+	h.announceHere(notice)
+	return nil, nil
+}
@@ -0,0 +1,89 @@
+package websocket
+
+import "connect4-backend/arena"
+
+// SetArenas lets players join arenas over the WebSocket, and passes them
+// the arena director's notices, such as their next game being ready and
+// the leaderboard changing.
+func (h *Hub) SetArenas(director *arena.Director) {
+	h.arenas = director
+	director.SetNotifyCallback(h.NotifyPlayer)
+}
+
+// joinArena enters the player in an arena, or has them paired again after
+// leaving it. Joining again while playing is how a returning player is
+// sent their game.
+func (c *Client) joinArena(arenaID, username string) {
+	if c.hub.arenas == nil {
+		c.sendMessage(Message{
+			Type: "error",
+			Data: map[string]string{"message": "Arenas are not available"},
+		})
+		return
+	}
+
+	a, err := c.hub.arenas.Join(arenaID, username)
+	if err != nil {
+		c.sendMessage(Message{
+			Type: "error",
+			Data: map[string]string{"message": err.Error()},
+		})
+		return
+	}
+
+	c.sendMessage(Message{
+		Type: "arena_joined",
+		Data: map[string]interface{}{
+			"arenaId":     a.ID,
+			"name":        a.Name,
+			"status":      a.Status,
+			"timeControl": a.TimeControl,
+			"startsAt":    a.StartsAt,
+			"endsAt":      a.EndsAt,
+			"leaderboard": arena.Standings(a),
+		},
+	})
+}
+
+// leaveArena stops the player being paired in an arena.
+func (c *Client) leaveArena(arenaID string) {
+	if c.hub.arenas == nil {
+		c.sendMessage(Message{
+			Type: "error",
+			Data: map[string]string{"message": "Arenas are not available"},
+		})
+		return
+	}
+
+	a, err := c.hub.arenas.Leave(arenaID, c.username)
+	if err != nil {
+		c.sendMessage(Message{
+			Type: "error",
+			Data: map[string]string{"message": err.Error()},
+		})
+		return
+	}
+
+	c.sendMessage(Message{
+		Type: "arena_left",
+		Data: map[string]interface{}{
+			"arenaId": a.ID,
+			"name":    a.Name,
+		},
+	})
+}
+
+// berserk halves the player's clock in their current arena game. Everyone
+// in the game hears about it from the game event.
+func (c *Client) berserk() {
+	if c.gameID == "" {
+		return
+	}
+
+	if _, err := c.hub.gameManager.Berserk(c.gameID, c.username); err != nil {
+		c.sendMessage(Message{
+			Type: "error",
+			Data: map[string]string{"message": err.Error()},
+		})
+	}
+}
@@ -0,0 +1,408 @@
+package websocket
+
+import (
+	"connect4-backend/cluster"
+	"connect4-backend/game"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+)
+
+// In cluster mode a client's connection stays on the node it reached, its
+// edge, while its game may be hosted by another node. Messages about such a
+// game are relayed to the game's node, where a proxy client stands in for
+// the connection and passes everything sent to it back to the edge.
+
+// forwardBatch is the most messages a proxy sends to its edge in one call.
+const forwardBatch = 64
+
+var errUnknownSession = errors.New("unknown session")
+
+type sessionArgs struct {
+	Session string `json:"session"`
+	Edge    string `json:"edge,omitempty"`
+}
+
+type deliverArgs struct {
+	sessionArgs
+	Message json.RawMessage `json:"message"`
+}
+
+type attachArgs struct {
+	sessionArgs
+	GameID   string `json:"gameId"`
+	Username string `json:"username"`
+}
+
+type sendArgs struct {
+	Session  string            `json:"session"`
+	Messages []json.RawMessage `json:"messages"`
+}
+
+type redirectArgs struct {
+	Session  string          `json:"session"`
+	Node     string          `json:"node"`
+	GameID   string          `json:"gameId"`
+	Username string          `json:"username"`
+	Message  json.RawMessage `json:"message"` // Sent to the client before it moves
+}
+
+func (h *Hub) newSession() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return h.cluster.Self().ID + "-" + hex.EncodeToString(id)
+}
+
+// serveCluster registers the calls other nodes make on this hub.
+func (h *Hub) serveCluster() {
+	// From edges, for proxies here
+	h.cluster.Handle("hub.deliver", h.handleDeliver)
+	h.cluster.Handle("hub.attach", h.handleAttach)
+	h.cluster.Handle("hub.close", h.handleClose)
+
+	// From proxies, for connections here
+	h.cluster.Handle("hub.send", h.handleSend)
+	h.cluster.Handle("hub.redirect", h.handleRedirect)
+
+	// From any node, for a player's connections here
+	h.cluster.Handle("hub.notify", h.handleNotify)
+}
+
+// route returns the node that should handle a message, or false when the
+// message is about the client's current game, wherever that is.
+func (h *Hub) route(msg Message) (cluster.Node, bool) {
+	data, _ := msg.Data.(map[string]interface{})
+	gameID, _ := data["gameId"].(string)
+	code, _ := data["inviteCode"].(string)
+	gameID, code = strings.TrimSpace(gameID), strings.TrimSpace(code)
+
+	switch msg.Type {
+	case "join_game", "spectate":
+		if code != "" {
+			return h.cluster.Owner(game.InviteKey(code)), true
+		}
+		if gameID != "" {
+			return h.cluster.Owner(gameID), true
+		}
+		// New games are hosted where their player is connected
+		return h.cluster.Self(), msg.Type == "join_game"
+	case "reconnect":
+		return h.cluster.Owner(gameID), true
+	case "create_private_game":
+		return h.cluster.Self(), true
+	}
+	return cluster.Node{}, false
+}
+
+// dispatch handles a message from the client's connection here, or relays
+// it to the node hosting the game it is about.
+func (c *Client) dispatch(raw []byte, msg Message) {
+	if c.hub.cluster == nil {
+		c.handleMessage(msg)
+		return
+	}
+
+	c.relayMutex.Lock()
+	defer c.relayMutex.Unlock()
+
+	if node, routed := c.hub.route(msg); routed {
+		if node.ID == c.hub.cluster.Self().ID {
+			c.stopRelay()
+		} else if c.relay == nil || c.relay.ID != node.ID {
+			c.stopRelay()
+			c.relay = &node
+		}
+	}
+
+	if c.relay == nil {
+		c.handleMessage(msg)
+		return
+	}
+
+	err := c.hub.cluster.Call(*c.relay, "hub.deliver", deliverArgs{
+		sessionArgs: sessionArgs{Session: c.session, Edge: c.hub.cluster.Self().ID},
+		Message:     raw,
+	}, nil)
+	if err != nil {
+		log.Printf("Failed to relay %s to node %s: %v", msg.Type, c.relay.ID, err)
+		c.sendMessage(Message{
+			Type: "error",
+			Data: map[string]string{"message": "Game server unavailable"},
+		})
+	}
+}
+
+// stopRelay drops the client's proxy on the node it was relayed to.
+// Callers must hold relayMutex.
+func (c *Client) stopRelay() {
+	if c.relay == nil {
+		return
+	}
+
+	if err := c.hub.cluster.Call(*c.relay, "hub.close", sessionArgs{Session: c.session}, nil); err != nil {
+		log.Printf("Failed to close relay to node %s: %v", c.relay.ID, err)
+	}
+	c.relay = nil
+}
+
+// closeRelay is stopRelay for a client that has disconnected.
+func (c *Client) closeRelay() {
+	c.relayMutex.Lock()
+	defer c.relayMutex.Unlock()
+	c.stopRelay()
+}
+
+// moveTo puts a client connected here into a game hosted by node.
+func (c *Client) moveTo(node cluster.Node, gameID, username string) {
+	c.relayMutex.Lock()
+	defer c.relayMutex.Unlock()
+
+	if node.ID == c.hub.cluster.Self().ID {
+		c.relay = nil
+		c.attachToGame(gameID, username)
+		return
+	}
+
+	c.relay = &node
+	err := c.hub.cluster.Call(node, "hub.attach", attachArgs{
+		sessionArgs: sessionArgs{Session: c.session, Edge: c.hub.cluster.Self().ID},
+		GameID:      gameID,
+		Username:    username,
+	}, nil)
+	if err != nil {
+		log.Printf("Failed to move %s to game %s on node %s: %v", username, gameID, node.ID, err)
+		c.sendMessage(Message{
+			Type: "error",
+			Data: map[string]string{"message": "Game server unavailable"},
+		})
+	}
+}
+
+// attachToGame seats a client that was moved into a game by the
+// matchmaker, and catches it up on the game.
+func (c *Client) attachToGame(gameID, username string) {
+	gameObj, exists := c.hub.gameManager.GetGame(gameID)
+	if !exists {
+		c.sendMessage(Message{
+			Type: "error",
+			Data: map[string]string{"message": "Game not found"},
+		})
+		return
+	}
+
+	c.stopSpectating()
+	c.stopReplay()
+	c.gameID = gameID
+	c.username = username
+
+	c.hub.mutex.Lock()
+	c.hub.gameClients[gameID] = append(c.hub.gameClients[gameID], c)
+	c.hub.mutex.Unlock()
+	c.hub.gameManager.SeatOccupied(gameID, username)
+
+	messageType := "game_updated"
+	if gameObj.Status == "playing" {
+		messageType = "game_started"
+	}
+	c.sendMessage(Message{
+		Type: messageType,
+		Data: gameObj,
+	})
+}
+
+// handOff sends the clients of a matched player's waiting game to the node
+// hosting the game they were seated in.
+func (h *Hub) handOff(previousGameID string, gameObj *game.Game, player *game.Player) {
+	h.mutex.Lock()
+	clients := h.gameClients[previousGameID]
+	delete(h.gameClients, previousGameID)
+	for _, client := range clients {
+		client.gameID = ""
+	}
+	h.mutex.Unlock()
+
+	node := h.cluster.Owner(gameObj.ID)
+	joined := Message{
+		Type: "game_joined",
+		Data: map[string]interface{}{
+			"game":      gameObj,
+			"player":    player,
+			"isWaiting": false,
+		},
+	}
+
+	for _, client := range clients {
+		if client.edge == "" {
+			client.sendMessage(joined)
+			go client.moveTo(node, gameObj.ID, player.Username)
+			continue
+		}
+
+		// A proxy: the edge moves the connection, and the proxy goes
+		go func(client *Client) {
+			edge, _ := h.cluster.Node(client.edge)
+			message, _ := json.Marshal(joined)
+			err := h.cluster.Call(edge, "hub.redirect", redirectArgs{
+				Session:  client.session,
+				Node:     node.ID,
+				GameID:   gameObj.ID,
+				Username: player.Username,
+				Message:  message,
+			}, nil)
+			if err != nil {
+				log.Printf("Failed to move %s to game %s: %v", player.Username, gameObj.ID, err)
+			}
+			h.unregister <- client
+		}(client)
+	}
+}
+
+// proxy returns the stand-in for a client connected to edge, creating it on
+// first use.
+func (h *Hub) proxy(session, edge string) *Client {
+	h.mutex.Lock()
+	client, exists := h.sessions[session]
+	if exists {
+		h.mutex.Unlock()
+		return client
+	}
+
+	client = &Client{
+		hub:     h,
+		send:    make(chan []byte, 256),
+		session: session,
+		edge:    edge,
+	}
+	h.sessions[session] = client
+	h.mutex.Unlock()
+
+	h.register <- client
+	go client.forwardPump()
+	return client
+}
+
+// forwardPump passes what is sent to a proxy on to its edge. If the edge
+// cannot be reached the proxy is dropped, as a client whose connection
+// failed would be.
+func (c *Client) forwardPump() {
+	edge, _ := c.hub.cluster.Node(c.edge)
+	failed := false
+
+	for message := range c.send {
+		if failed {
+			continue
+		}
+
+		batch := []json.RawMessage{message}
+	collect:
+		for len(batch) < forwardBatch {
+			select {
+			case more, ok := <-c.send:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, more)
+			default:
+				break collect
+			}
+		}
+
+		err := c.hub.cluster.Call(edge, "hub.send", sendArgs{Session: c.session, Messages: batch}, nil)
+		if err != nil {
+			log.Printf("Failed to forward to node %s: %v", c.edge, err)
+			failed = true
+			go func() { c.hub.unregister <- c }()
+		}
+	}
+}
+
+func (h *Hub) handleDeliver(body json.RawMessage) (interface{}, error) {
+	var args deliverArgs
+	if err := json.Unmarshal(body, &args); err != nil {
+		return nil, err
+	}
+
+	var msg Message
+	if err := json.Unmarshal(args.Message, &msg); err != nil {
+		return nil, err
+	}
+
+	h.proxy(args.Session, args.Edge).handleMessage(msg)
+	return nil, nil
+}
+
+func (h *Hub) handleAttach(body json.RawMessage) (interface{}, error) {
+	var args attachArgs
+	if err := json.Unmarshal(body, &args); err != nil {
+		return nil, err
+	}
+
+	h.proxy(args.Session, args.Edge).attachToGame(args.GameID, args.Username)
+	return nil, nil
+}
+
+func (h *Hub) handleClose(body json.RawMessage) (interface{}, error) {
+	var args sessionArgs
+	if err := json.Unmarshal(body, &args); err != nil {
+		return nil, err
+	}
+
+	h.mutex.RLock()
+	client, exists := h.sessions[args.Session]
+	h.mutex.RUnlock()
+	if exists && client.edge != "" {
+		h.unregister <- client
+	}
+	return nil, nil
+}
+
+func (h *Hub) handleSend(body json.RawMessage) (interface{}, error) {
+	var args sendArgs
+	if err := json.Unmarshal(body, &args); err != nil {
+		return nil, err
+	}
+
+	// Sent under the lock, so the client cannot be unregistered and its
+	// channel closed in between
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	client, exists := h.sessions[args.Session]
+	if !exists || client.edge != "" {
+		return nil, errUnknownSession
+	}
+	for _, message := range args.Messages {
+		select {
+		case client.send <- message:
+		default:
+		}
+	}
+	return nil, nil
+}
+
+func (h *Hub) handleRedirect(body json.RawMessage) (interface{}, error) {
+	var args redirectArgs
+	if err := json.Unmarshal(body, &args); err != nil {
+		return nil, err
+	}
+
+	node, known := h.cluster.Node(args.Node)
+	h.mutex.RLock()
+	client, exists := h.sessions[args.Session]
+	if exists && client.edge == "" && len(args.Message) > 0 {
+		select {
+		case client.send <- args.Message:
+		default:
+		}
+	}
+	h.mutex.RUnlock()
+	if !exists || client.edge != "" || !known {
+		return nil, errUnknownSession
+	}
+
+	go client.moveTo(node, args.GameID, args.Username)
+	return nil, nil
+}
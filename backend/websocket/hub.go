@@ -1,7 +1,10 @@
 package websocket
 
 import (
+	"connect4-backend/arena"
+	"connect4-backend/cluster"
 	"connect4-backend/game"
+	"connect4-backend/tournament"
 	"encoding/json"
 	"log"
 	"net/http"
@@ -12,6 +15,10 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+// gameEventBuffer is how many game events may wait for the hub to relay
+// them before it starts missing some.
+const gameEventBuffer = 1024
+
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true // Allow all origins for development
@@ -19,21 +26,34 @@ var upgrader = websocket.Upgrader{
 }
 
 type Hub struct {
-	clients     map[*Client]bool
-	gameClients map[string][]*Client
-	register    chan *Client
-	unregister  chan *Client
-	broadcast   chan []byte
-	gameManager *game.Manager
-	mutex       sync.RWMutex
+	clients        map[*Client]bool
+	gameClients    map[string][]*Client
+	spectators     map[string][]*Client
+	spectatorView  map[string][]byte // Game as spectators currently see it
+	spectatorDelay time.Duration
+	register       chan *Client
+	unregister     chan *Client
+	broadcast      chan []byte
+	gameManager    *game.Manager
+	cluster        *cluster.Cluster     // nil when running on a single node
+	sessions       map[string]*Client   // Connections here and proxies for other nodes' connections, in cluster mode
+	director       *tournament.Director // nil until SetDirector
+	arenas         *arena.Director      // nil until SetArenas
+	mutex          sync.RWMutex
 }
 
 type Client struct {
-	hub      *Hub
-	conn     *websocket.Conn
-	send     chan []byte
-	username string
-	gameID   string
+	hub        *Hub
+	conn       *websocket.Conn
+	send       chan []byte
+	username   string
+	gameID     string
+	spectating string  // ID of the game being watched
+	replay     *replay // Guarded by the hub's mutex
+	session    string  // Identifies the connection across the cluster
+	edge       string  // For a proxy, the node holding the connection
+	relay      *cluster.Node
+	relayMutex sync.Mutex // Guards relay
 }
 
 type Message struct {
@@ -43,16 +63,28 @@ type Message struct {
 
 func NewHub(gameManager *game.Manager) *Hub {
 	hub := &Hub{
-		clients:     make(map[*Client]bool),
-		gameClients: make(map[string][]*Client),
-		register:    make(chan *Client),
-		unregister:  make(chan *Client),
-		broadcast:   make(chan []byte, 256),
-		gameManager: gameManager,
+		clients:        make(map[*Client]bool),
+		gameClients:    make(map[string][]*Client),
+		spectators:     make(map[string][]*Client),
+		spectatorView:  make(map[string][]byte),
+		spectatorDelay: spectatorDelayFromEnv(),
+		register:       make(chan *Client),
+		unregister:     make(chan *Client),
+		broadcast:      make(chan []byte, 256),
+		gameManager:    gameManager,
+		cluster:        gameManager.Cluster(),
+		sessions:       make(map[string]*Client),
+	}
+	if hub.cluster != nil {
+		hub.serveCluster()
+		hub.serveAdmin()
 	}
 	
-	// Set callback for game updates
-	gameManager.SetGameUpdateCallback(hub.onGameUpdate)
+	// Relay game events to the clients in each game
+	gameManager.Events().Subscribe("hub", gameEventBuffer, hub.onGameEvent)
+	gameManager.SetQueueStatusCallback(hub.onQueueStatus)
+	gameManager.SetPlayerMatchedCallback(hub.onPlayerMatched)
+	gameManager.SetPresenceCallbacks(hub.isSeated, hub.onSeatVacated, hub.onSeatReturned)
 	
 	return hub
 }
@@ -63,6 +95,9 @@ func (h *Hub) Run() {
 		case client := <-h.register:
 			h.mutex.Lock()
 			h.clients[client] = true
+			if client.session != "" {
+				h.sessions[client.session] = client
+			}
 			h.mutex.Unlock()
 			log.Printf("Client connected: %s", client.username)
 
@@ -70,14 +105,29 @@ func (h *Hub) Run() {
 			h.mutex.Lock()
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
+				delete(h.sessions, client.session)
+				if client.conn != nil && h.cluster != nil {
+					go client.closeRelay()
+				}
+				h.removeReplay(client)
 				close(client.send)
 				
 				// Remove from game clients
 				if client.gameID != "" {
 					h.removeClientFromGame(client)
+
+					// Stop searching once nobody is left waiting for the match
+					if len(h.gameClients[client.gameID]) == 0 {
+						go h.leaveQueueIfWaiting(client.gameID, client.username)
+					}
 				}
 			}
+			gameID := client.spectating
+			h.removeSpectator(client)
 			h.mutex.Unlock()
+			if gameID != "" {
+				h.sendSpectatorCount(gameID)
+			}
 			log.Printf("Client disconnected: %s", client.username)
 
 		case message := <-h.broadcast:
@@ -107,6 +157,9 @@ func (h *Hub) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 		conn: conn,
 		send: make(chan []byte, 256),
 	}
+	if h.cluster != nil {
+		client.session = h.newSession()
+	}
 
 	client.hub.register <- client
 
@@ -142,7 +195,7 @@ func (c *Client) readPump() {
 			continue
 		}
 
-		c.handleMessage(msg)
+		c.dispatch(messageBytes, msg)
 	}
 }
 
@@ -217,6 +270,14 @@ func (c *Client) handleMessage(msg Message) {
 		
 		c.username = strings.TrimSpace(username)
 		
+		password, _ := data["password"].(string)
+
+		// Private games are joined by invite code
+		if code, ok := data["inviteCode"].(string); ok && strings.TrimSpace(code) != "" {
+			c.joinPrivateGame(c.username, code, password)
+			return
+		}
+
 		// Check if gameId is provided for joining specific game
 		if gameIDInterface, exists := data["gameId"]; exists && gameIDInterface != nil {
 			gameID, ok := gameIDInterface.(string)
@@ -226,7 +287,82 @@ func (c *Client) handleMessage(msg Message) {
 			}
 		}
 		
-		c.joinGame(c.username)
+		mode, _ := data["mode"].(string)
+		c.joinGame(c.username, parsePreferences(data), mode == "play_bot")
+
+	case "create_private_game":
+		data, ok := msg.Data.(map[string]interface{})
+		if !ok {
+			c.sendMessage(Message{
+				Type: "error",
+				Data: map[string]string{"message": "Invalid data format"},
+			})
+			return
+		}
+
+		username, _ := data["username"].(string)
+		password, _ := data["password"].(string)
+		c.username = strings.TrimSpace(username)
+		c.createPrivateGame(c.username, password, parsePreferences(data))
+
+	case "spectate":
+		data, ok := msg.Data.(map[string]interface{})
+		if !ok {
+			c.sendMessage(Message{
+				Type: "error",
+				Data: map[string]string{"message": "Invalid data format"},
+			})
+			return
+		}
+
+		var gameObj *game.Game
+		var err error
+		gameID, _ := data["gameId"].(string)
+		code, _ := data["inviteCode"].(string)
+		password, _ := data["password"].(string)
+		if strings.TrimSpace(code) != "" {
+			gameObj, err = c.hub.gameManager.SpectatePrivateGame(code, password)
+		} else {
+			gameObj, err = c.hub.gameManager.SpectateGame(strings.TrimSpace(gameID))
+		}
+		if err != nil {
+			c.sendMessage(Message{
+				Type: "error",
+				Data: map[string]string{"message": err.Error()},
+			})
+			return
+		}
+
+		if username, ok := data["username"].(string); ok && c.username == "" {
+			c.username = strings.TrimSpace(username)
+		}
+		c.spectate(gameObj)
+
+	case "stop_spectating":
+		c.stopSpectating()
+		c.sendMessage(Message{
+			Type: "spectating_stopped",
+			Data: map[string]interface{}{},
+		})
+
+	case "leave_queue":
+		if err := c.hub.gameManager.LeaveQueue(c.username); err != nil {
+			c.sendMessage(Message{
+				Type: "error",
+				Data: map[string]string{"message": err.Error()},
+			})
+			return
+		}
+
+		c.hub.mutex.Lock()
+		c.hub.removeClientFromGame(c)
+		c.hub.mutex.Unlock()
+		c.gameID = ""
+
+		c.sendMessage(Message{
+			Type: "queue_left",
+			Data: map[string]interface{}{},
+		})
 
 	case "make_move":
 		data, ok := msg.Data.(map[string]interface{})
@@ -315,6 +451,94 @@ func (c *Client) handleMessage(msg Message) {
 		
 		c.username = strings.TrimSpace(username)
 		c.reconnectToGame(strings.TrimSpace(gameID), c.username)
+
+	case "register_tournament":
+		data, ok := msg.Data.(map[string]interface{})
+		if !ok {
+			c.sendMessage(Message{
+				Type: "error",
+				Data: map[string]string{"message": "Invalid data format"},
+			})
+			return
+		}
+
+		tournamentID, _ := data["tournamentId"].(string)
+		username, _ := data["username"].(string)
+		if c.username == "" {
+			c.username = strings.TrimSpace(username)
+		}
+		c.registerTournament(strings.TrimSpace(tournamentID), strings.TrimSpace(username))
+
+	case "join_arena":
+		data, ok := msg.Data.(map[string]interface{})
+		if !ok {
+			c.sendMessage(Message{
+				Type: "error",
+				Data: map[string]string{"message": "Invalid data format"},
+			})
+			return
+		}
+
+		arenaID, _ := data["arenaId"].(string)
+		username, _ := data["username"].(string)
+		if c.username == "" {
+			c.username = strings.TrimSpace(username)
+		}
+		c.joinArena(strings.TrimSpace(arenaID), strings.TrimSpace(username))
+
+	case "leave_arena":
+		data, ok := msg.Data.(map[string]interface{})
+		if !ok {
+			c.sendMessage(Message{
+				Type: "error",
+				Data: map[string]string{"message": "Invalid data format"},
+			})
+			return
+		}
+
+		arenaID, _ := data["arenaId"].(string)
+		c.leaveArena(strings.TrimSpace(arenaID))
+
+	case "berserk":
+		c.berserk()
+
+	case "watch_replay":
+		data, ok := msg.Data.(map[string]interface{})
+		if !ok {
+			c.sendMessage(Message{
+				Type: "error",
+				Data: map[string]string{"message": "Invalid data format"},
+			})
+			return
+		}
+
+		gameID, _ := data["gameId"].(string)
+		ply, _ := data["ply"].(float64)
+		speed, _ := data["speed"].(float64)
+		paused, _ := data["paused"].(bool)
+		c.watchReplay(strings.TrimSpace(gameID), int(ply), speed, paused)
+
+	case "replay_control":
+		data, ok := msg.Data.(map[string]interface{})
+		if !ok {
+			c.sendMessage(Message{
+				Type: "error",
+				Data: map[string]string{"message": "Invalid data format"},
+			})
+			return
+		}
+
+		action, _ := data["action"].(string)
+		ply, _ := data["ply"].(float64)
+		speed, _ := data["speed"].(float64)
+		c.controlReplay(action, int(ply), speed)
+
+	case "stop_replay":
+		c.stopReplay()
+		c.sendMessage(Message{
+			Type: "replay_stopped",
+			Data: map[string]interface{}{},
+		})
 		
 	default:
 		c.sendMessage(Message{
@@ -324,31 +548,52 @@ func (c *Client) handleMessage(msg Message) {
 	}
 }
 
-func (c *Client) joinGame(username string) {
-	gameObj, player, isWaiting := c.hub.gameManager.FindOrCreateGame(username)
-	c.gameID = gameObj.ID
+func (c *Client) joinGame(username string, prefs game.QueuePreferences, playBot bool) {
+	join := c.hub.gameManager.Enqueue
+	if playBot {
+		join = c.hub.gameManager.PlayBot
+	}
+
+	gameObj, player, err := join(username, prefs)
+	if err != nil {
+		c.sendMessage(Message{
+			Type: "error",
+			Data: map[string]string{"message": err.Error()},
+		})
+		return
+	}
+	c.stopSpectating()
 
 	c.hub.mutex.Lock()
-	c.hub.gameClients[gameObj.ID] = append(c.hub.gameClients[gameObj.ID], c)
+	if c.gameID != gameObj.ID {
+		// Not already watching this game from an earlier join
+		c.hub.removeClientFromGame(c)
+		c.hub.gameClients[gameObj.ID] = append(c.hub.gameClients[gameObj.ID], c)
+	}
 	c.hub.mutex.Unlock()
+	c.gameID = gameObj.ID
+	c.hub.gameManager.SeatOccupied(gameObj.ID, player.Username)
 
-	response := Message{
-		Type: "game_joined",
-		Data: map[string]interface{}{
-			"game":      gameObj,
-			"player":    player,
-			"isWaiting": isWaiting,
-		},
+	data := map[string]interface{}{
+		"game":      gameObj,
+		"player":    player,
+		"isWaiting": gameObj.Status == "waiting",
+	}
+	if status, queued := c.hub.gameManager.QueueStatus(username); queued {
+		data["queue"] = status
 	}
 
-	c.sendMessage(response)
+	c.sendMessage(Message{
+		Type: "game_joined",
+		Data: data,
+	})
 
 	// Always broadcast game state to all clients
 	messageType := "game_updated"
 	if gameObj.Status == "playing" {
 		messageType = "game_started"
 	}
-	c.broadcastToGame(gameObj.ID, Message{
+	c.hub.broadcastToGame(gameObj.ID, Message{
 		Type: messageType,
 		Data: gameObj,
 	})
@@ -364,11 +609,46 @@ func (c *Client) joinSpecificGame(username, gameID string) {
 		return
 	}
 
+	c.enterGame(gameObj, player)
+}
+
+func (c *Client) createPrivateGame(username, password string, prefs game.QueuePreferences) {
+	gameObj, player, err := c.hub.gameManager.CreatePrivateGame(username, password, prefs)
+	if err != nil {
+		c.sendMessage(Message{
+			Type: "error",
+			Data: map[string]string{"message": err.Error()},
+		})
+		return
+	}
+
+	c.enterGame(gameObj, player)
+}
+
+func (c *Client) joinPrivateGame(username, code, password string) {
+	gameObj, player, err := c.hub.gameManager.JoinPrivateGame(username, code, password)
+	if err != nil {
+		c.sendMessage(Message{
+			Type: "error",
+			Data: map[string]string{"message": err.Error()},
+		})
+		return
+	}
+
+	c.enterGame(gameObj, player)
+}
+
+// enterGame attaches the client to a game it has joined and tells everyone
+// in the game about it.
+func (c *Client) enterGame(gameObj *game.Game, player *game.Player) {
+	c.stopSpectating()
+	c.stopReplay()
 	c.gameID = gameObj.ID
 
 	c.hub.mutex.Lock()
 	c.hub.gameClients[gameObj.ID] = append(c.hub.gameClients[gameObj.ID], c)
 	c.hub.mutex.Unlock()
+	c.hub.gameManager.SeatOccupied(gameObj.ID, player.Username)
 
 	response := Message{
 		Type: "game_joined",
@@ -387,18 +667,25 @@ func (c *Client) joinSpecificGame(username, gameID string) {
 		messageType = "game_started"
 	}
 	
-	c.broadcastToGame(gameObj.ID, Message{
+	c.hub.broadcastToGame(gameObj.ID, Message{
 		Type: messageType,
 		Data: gameObj,
 	})
 }
 
 func (c *Client) makeMove(column int) {
+	if c.spectating != "" {
+		c.sendMessage(Message{
+			Type: "error",
+			Data: map[string]string{"message": "Spectators cannot make moves"},
+		})
+		return
+	}
 	if c.gameID == "" {
 		return
 	}
 
-	move, gameObj, err := c.hub.gameManager.MakeMove(c.gameID, column, c.username)
+	_, gameObj, err := c.hub.gameManager.MakeMove(c.gameID, column, c.username)
 	if err != nil {
 		c.sendMessage(Message{
 			Type: "error",
@@ -407,40 +694,29 @@ func (c *Client) makeMove(column int) {
 		return
 	}
 
-	// Broadcast move to all game clients
-	c.broadcastToGame(c.gameID, Message{
-		Type: "move_made",
-		Data: map[string]interface{}{
-			"move": move,
-			"game": gameObj,
-		},
-	})
-
 	// If it's bot's turn, make bot move
 	if gameObj.IsBot && gameObj.CurrentTurn == game.PLAYER2 && gameObj.Status == "playing" {
-		go func() {
-			time.Sleep(500 * time.Millisecond) // Small delay for better UX
-			
-			botMove, updatedGame, err := c.hub.gameManager.MakeBotMove(c.gameID)
-			if err != nil {
-				log.Printf("Bot move error: %v", err)
-				return
-			}
+		go c.playBotMove(c.gameID)
+	}
+}
 
-			if botMove != nil {
-				c.broadcastToGame(c.gameID, Message{
-					Type: "move_made",
-					Data: map[string]interface{}{
-						"move": botMove,
-						"game": updatedGame,
-					},
-				})
-			}
-		}()
+func (c *Client) playBotMove(gameID string) {
+	time.Sleep(500 * time.Millisecond) // Small delay for better UX
+
+	if _, _, err := c.hub.gameManager.MakeBotMove(gameID); err != nil {
+		log.Printf("Bot move error: %v", err)
 	}
 }
 
 func (c *Client) reconnectToGame(gameID, username string) {
+	if c.hub.gameManager.IsBanned(username) {
+		c.sendMessage(Message{
+			Type: "error",
+			Data: map[string]string{"message": game.ErrBanned.Error()},
+		})
+		return
+	}
+
 	gameObj, exists := c.hub.gameManager.GetGame(gameID)
 	if !exists {
 		c.sendMessage(Message{
@@ -460,17 +736,25 @@ func (c *Client) reconnectToGame(gameID, username string) {
 		return
 	}
 
+	c.stopSpectating()
+	c.stopReplay()
 	c.gameID = gameID
 	c.username = username
 
 	c.hub.mutex.Lock()
 	c.hub.gameClients[gameID] = append(c.hub.gameClients[gameID], c)
 	c.hub.mutex.Unlock()
+	c.hub.gameManager.SeatOccupied(gameID, username)
 
 	c.sendMessage(Message{
 		Type: "game_reconnected",
 		Data: gameObj,
 	})
+
+	// The bot may have been due to move when the server restarted
+	if gameObj.IsBot && gameObj.CurrentTurn == game.PLAYER2 && gameObj.Status == "playing" {
+		go c.playBotMove(gameID)
+	}
 }
 
 func (c *Client) sendMessage(msg Message) {
@@ -482,10 +766,10 @@ func (c *Client) sendMessage(msg Message) {
 	}
 }
 
-func (c *Client) broadcastToGame(gameID string, msg Message) {
-	c.hub.mutex.RLock()
-	clients := c.hub.gameClients[gameID]
-	c.hub.mutex.RUnlock()
+func (h *Hub) broadcastToGame(gameID string, msg Message) {
+	h.mutex.RLock()
+	clients := h.gameClients[gameID]
+	h.mutex.RUnlock()
 
 	data, _ := json.Marshal(msg)
 	for _, client := range clients {
@@ -495,40 +779,205 @@ func (c *Client) broadcastToGame(gameID string, msg Message) {
 			close(client.send)
 		}
 	}
+
+	h.relayToSpectators(gameID, msg)
 }
 
+// removeClientFromGame detaches a client from its game. When it was the
+// player's last client there, the manager is told the seat is empty.
+// Callers must hold mutex.
 func (h *Hub) removeClientFromGame(client *Client) {
-	if clients, exists := h.gameClients[client.gameID]; exists {
-		for i, c := range clients {
-			if c == client {
-				h.gameClients[client.gameID] = append(clients[:i], clients[i+1:]...)
-				break
-			}
+	clients, exists := h.gameClients[client.gameID]
+	if !exists {
+		return
+	}
+
+	for i, c := range clients {
+		if c == client {
+			h.gameClients[client.gameID] = append(clients[:i], clients[i+1:]...)
+			break
 		}
 	}
+
+	if client.username != "" {
+		go h.seatVacated(client.gameID, client.username)
+	}
 }
 
-func (h *Hub) onGameUpdate(gameID string, gameObj *game.Game) {
+// seatVacated tells the manager a player has left a game, unless they are
+// still there from another client.
+func (h *Hub) seatVacated(gameID, username string) {
+	if !h.isSeated(gameID, username) {
+		h.gameManager.SeatVacated(gameID, username)
+	}
+}
+
+// isSeated reports whether username has a client in the game.
+func (h *Hub) isSeated(gameID, username string) bool {
 	h.mutex.RLock()
-	clients := h.gameClients[gameID]
-	h.mutex.RUnlock()
+	defer h.mutex.RUnlock()
 
-	messageType := "game_updated"
-	if gameObj.Status == "playing" {
-		messageType = "game_started"
+	for _, c := range h.gameClients[gameID] {
+		if c.username == username {
+			return true
+		}
 	}
+	return false
+}
 
-	msg := Message{
-		Type: messageType,
-		Data: gameObj,
+// onGameEvent tells a game's players and spectators what happened in it.
+// Moves that finish a game carry the result, so only games that end some
+// other way get a separate update. Games an admin ended also get the
+// admin's reason.
+func (h *Hub) onGameEvent(event game.Event) {
+	var msg Message
+	var notice string
+	switch e := event.(type) {
+	case game.PlayerJoined:
+		msg = Message{Type: "game_started", Data: e.Game}
+	case game.MoveMade:
+		msg = Message{
+			Type: "move_made",
+			Data: map[string]interface{}{
+				"move": e.Move,
+				"game": e.Game,
+			},
+		}
+	case game.PlayerBerserked:
+		msg = Message{
+			Type: "berserk",
+			Data: map[string]interface{}{
+				"username": e.Player,
+				"game":     e.Game,
+			},
+		}
+	case game.GameFinished:
+		if e.Game.EndReason == "" {
+			return
+		}
+		msg = Message{Type: "game_updated", Data: e.Game}
+		notice = e.Reason
+	case game.GameAborted:
+		msg = Message{Type: "game_updated", Data: e.Game}
+		notice = e.Reason
+	case game.GameExpired:
+		msg = Message{Type: "game_updated", Data: e.Game}
+	case game.RatingsUpdated:
+		msg = Message{
+			Type: "ratings_updated",
+			Data: map[string]interface{}{
+				"gameId":        e.Game.ID,
+				"ratingChanges": e.Changes,
+				"game":          e.Game,
+			},
+		}
+	default:
+		return
 	}
-	
+
+	h.broadcastToGame(event.GameID(), msg)
+	if notice != "" {
+		h.broadcastToGame(event.GameID(), Message{
+			Type: "admin_notice",
+			Data: map[string]interface{}{
+				"gameId":  event.GameID(),
+				"message": notice,
+			},
+		})
+	}
+}
+
+func (h *Hub) onQueueStatus(gameID string, status game.QueueStatus) {
+	h.sendToGame(gameID, Message{
+		Type: "queue_status",
+		Data: status,
+	})
+}
+
+// onPlayerMatched moves a matched player's clients from their own waiting
+// game to the game they were seated in.
+func (h *Hub) onPlayerMatched(previousGameID string, gameObj *game.Game, player *game.Player) {
+	if h.cluster != nil && !h.cluster.Owns(gameObj.ID) {
+		h.handOff(previousGameID, gameObj, player)
+		return
+	}
+
+	h.mutex.Lock()
+	clients := h.gameClients[previousGameID]
+	delete(h.gameClients, previousGameID)
+	for _, client := range clients {
+		client.gameID = gameObj.ID
+	}
+	h.gameClients[gameObj.ID] = append(h.gameClients[gameObj.ID], clients...)
+	h.mutex.Unlock()
+
+	for _, client := range clients {
+		client.sendMessage(Message{
+			Type: "game_joined",
+			Data: map[string]interface{}{
+				"game":      gameObj,
+				"player":    player,
+				"isWaiting": false,
+			},
+		})
+	}
+}
+
+func (h *Hub) onSeatVacated(gameID, username string, deadline time.Time) {
+	h.sendToGame(gameID, Message{
+		Type: "opponent_disconnected",
+		Data: map[string]interface{}{
+			"username": username,
+			"deadline": deadline.UnixMilli(),
+			"seconds":  time.Until(deadline).Round(time.Second).Seconds(),
+		},
+	})
+}
+
+func (h *Hub) onSeatReturned(gameID, username string) {
+	h.sendToGame(gameID, Message{
+		Type: "opponent_reconnected",
+		Data: map[string]interface{}{
+			"username": username,
+		},
+	})
+}
+
+func (h *Hub) leaveQueueIfWaiting(gameID, username string) {
+	if gameObj, exists := h.gameManager.GetGame(gameID); exists && gameObj.Status == "waiting" {
+		h.gameManager.LeaveQueue(username)
+	}
+}
+
+func (h *Hub) sendToGame(gameID string, msg Message) {
+	h.mutex.RLock()
+	clients := h.gameClients[gameID]
+	h.mutex.RUnlock()
+
 	data, _ := json.Marshal(msg)
 	for _, client := range clients {
 		select {
 		case client.send <- data:
 		default:
-			close(client.send)
 		}
 	}
-}
\ No newline at end of file
+}
+
+// parsePreferences reads matchmaking preferences from a join_game message.
+// Games are rated unless the client asks for a casual one.
+func parsePreferences(data map[string]interface{}) game.QueuePreferences {
+	prefs := game.QueuePreferences{Rated: true}
+	if timeControl, ok := data["timeControl"].(string); ok {
+		prefs.TimeControl = strings.TrimSpace(timeControl)
+	}
+	if variant, ok := data["variant"].(string); ok {
+		prefs.Variant = strings.TrimSpace(variant)
+	}
+	if rated, ok := data["rated"].(bool); ok {
+		prefs.Rated = rated
+	}
+	if humanOnly, ok := data["humanOnly"].(bool); ok {
+		prefs.HumanOnly = humanOnly
+	}
+	return prefs
+}
@@ -0,0 +1,69 @@
+package websocket
+
+import "encoding/json"
+
+type notifyArgs struct {
+	Username string          `json:"username"`
+	Message  json.RawMessage `json:"message"`
+}
+
+// NotifyPlayer sends a message to every connection of a player, on any
+// node.
+func (h *Hub) NotifyPlayer(username, messageType string, data interface{}) {
+	message, _ := json.Marshal(Message{Type: messageType, Data: data})
+	h.notifyHere(username, message)
+	if h.cluster != nil {
+		h.cluster.Broadcast("hub.notify", notifyArgs{Username: username, Message: message})
+	}
+}
+
+// notifyHere sends a message to the player's connections here and to the
+// proxies here for their connections elsewhere. A connection relayed to
+// another node hears it from its proxy there instead.
+func (h *Hub) notifyHere(username string, message []byte) {
+	var clients []*Client
+	h.mutex.RLock()
+	for client := range h.clients {
+		if client.username == username {
+			clients = append(clients, client)
+		}
+	}
+	h.mutex.RUnlock()
+
+	targets := clients[:0]
+	for _, client := range clients {
+		if client.edge == "" {
+			client.relayMutex.Lock()
+			relayed := client.relay != nil
+			client.relayMutex.Unlock()
+			if relayed {
+				continue
+			}
+		}
+		targets = append(targets, client)
+	}
+
+	// Sent under the lock, so no client can be unregistered and its
+	// channel closed in between
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for _, client := range targets {
+		if !h.clients[client] {
+			continue
+		}
+		select {
+		case client.send <- message:
+		default:
+		}
+	}
+}
+
+func (h *Hub) handleNotify(body json.RawMessage) (interface{}, error) {
+	var args notifyArgs
+	if err := json.Unmarshal(body, &args); err != nil {
+		return nil, err
+	}
+
+	h.notifyHere(args.Username, args.Message)
+	return nil, nil
+}
@@ -0,0 +1,255 @@
+package websocket
+
+import (
+	"connect4-backend/game"
+	"encoding/json"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	replayInterval = time.Second // Between moves at speed 1
+	minReplaySpeed = 0.25
+	maxReplaySpeed = 16.0
+)
+
+// replay plays a finished game back to one client. Each position is sent
+// as a move_made message, rebuilt from the recorded moves, so clients draw
+// it like a live game.
+type replay struct {
+	client  *Client
+	record  *game.GameRecord
+	mutex   sync.Mutex
+	ply     int
+	speed   float64
+	paused  bool
+	stopped bool
+	timer   *time.Timer
+}
+
+// watchReplay starts playing back a finished game from ply, replacing any
+// replay the client was watching.
+func (c *Client) watchReplay(gameID string, ply int, speed float64, paused bool) {
+	if gameObj, exists := c.hub.gameManager.GetGame(c.gameID); exists && gameObj.Status == "playing" {
+		c.sendMessage(Message{
+			Type: "error",
+			Data: map[string]string{"message": "Finish your game before watching a replay"},
+		})
+		return
+	}
+
+	record, err := c.hub.gameManager.FinishedGame(gameID)
+	if err == nil && (ply < 0 || ply > len(record.Moves)) {
+		err = game.ErrInvalidPly
+	}
+	if err != nil {
+		c.sendMessage(Message{
+			Type: "error",
+			Data: map[string]string{"message": err.Error()},
+		})
+		return
+	}
+
+	r := &replay{
+		client: c,
+		record: record,
+		ply:    ply,
+		speed:  clampReplaySpeed(speed),
+		paused: paused,
+	}
+
+	c.stopSpectating()
+	c.hub.mutex.Lock()
+	c.hub.removeReplay(c)
+	c.replay = r
+	c.hub.mutex.Unlock()
+
+	log.Printf("Client %s is watching a replay of game %s", c.username, gameID)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	c.sendMessage(Message{
+		Type: "replay_started",
+		Data: map[string]interface{}{
+			"record": record,
+			"replay": r.state(),
+		},
+	})
+	r.show()
+	r.schedule()
+}
+
+// controlReplay applies a replay_control action to the client's replay.
+func (c *Client) controlReplay(action string, ply int, speed float64) {
+	c.hub.mutex.RLock()
+	r := c.replay
+	c.hub.mutex.RUnlock()
+
+	if r == nil {
+		c.sendMessage(Message{
+			Type: "error",
+			Data: map[string]string{"message": "Not watching a replay"},
+		})
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	switch action {
+	case "pause":
+		r.paused = true
+		r.sendState()
+	case "resume":
+		r.paused = false
+		if r.ply == len(r.record.Moves) {
+			// Start over from the beginning
+			r.ply = 0
+			r.show()
+		} else {
+			r.sendState()
+		}
+	case "speed":
+		r.speed = clampReplaySpeed(speed)
+		r.sendState()
+	case "seek":
+		if ply < 0 || ply > len(r.record.Moves) {
+			c.sendMessage(Message{
+				Type: "error",
+				Data: map[string]string{"message": game.ErrInvalidPly.Error()},
+			})
+			return
+		}
+		r.ply = ply
+		r.show()
+	case "forward", "back":
+		// Stepping takes over from playback
+		r.paused = true
+		if action == "forward" && r.ply < len(r.record.Moves) {
+			r.ply++
+		} else if action == "back" && r.ply > 0 {
+			r.ply--
+		}
+		r.show()
+	default:
+		c.sendMessage(Message{
+			Type: "error",
+			Data: map[string]string{"message": "Unknown replay action"},
+		})
+		return
+	}
+	r.schedule()
+}
+
+// stopReplay stops the client's replay, if any.
+func (c *Client) stopReplay() {
+	c.hub.mutex.Lock()
+	c.hub.removeReplay(c)
+	c.hub.mutex.Unlock()
+}
+
+// removeReplay stops the client's replay, if any. Once it returns the
+// replay sends nothing more. Callers must hold mutex.
+func (h *Hub) removeReplay(client *Client) {
+	r := client.replay
+	if r == nil {
+		return
+	}
+	client.replay = nil
+
+	r.mutex.Lock()
+	r.stopped = true
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.mutex.Unlock()
+}
+
+// advance moves playback on by one ply.
+func (r *replay) advance() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.stopped || r.paused || r.ply >= len(r.record.Moves) {
+		return
+	}
+	r.ply++
+	r.show()
+	r.schedule()
+}
+
+// schedule sets up the next move of playback. Callers must hold mutex.
+func (r *replay) schedule() {
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	if r.stopped || r.paused || r.ply >= len(r.record.Moves) {
+		return
+	}
+	interval := time.Duration(float64(replayInterval) / r.speed)
+	r.timer = time.AfterFunc(interval, r.advance)
+}
+
+// show sends the position at the current ply. Callers must hold mutex.
+func (r *replay) show() {
+	if r.ply == len(r.record.Moves) {
+		r.paused = true
+	}
+
+	gameObj, move, err := r.record.Position(r.ply)
+	if err != nil {
+		log.Printf("Failed to replay game %s: %v", r.record.ID, err)
+		r.send(Message{
+			Type: "error",
+			Data: map[string]string{"message": err.Error()},
+		})
+		return
+	}
+
+	r.send(Message{
+		Type: "move_made",
+		Data: map[string]interface{}{
+			"move":   move,
+			"game":   gameObj,
+			"replay": r.state(),
+		},
+	})
+}
+
+// sendState tells the client where playback is. Callers must hold mutex.
+func (r *replay) sendState() {
+	r.send(Message{
+		Type: "replay_state",
+		Data: r.state(),
+	})
+}
+
+func (r *replay) state() map[string]interface{} {
+	return map[string]interface{}{
+		"gameId":     r.record.ID,
+		"ply":        r.ply,
+		"totalPlies": len(r.record.Moves),
+		"speed":      r.speed,
+		"paused":     r.paused,
+	}
+}
+
+// send queues a message for the client without blocking playback.
+func (r *replay) send(msg Message) {
+	data, _ := json.Marshal(msg)
+	select {
+	case r.client.send <- data:
+	default:
+	}
+}
+
+func clampReplaySpeed(speed float64) float64 {
+	if speed == 0 || math.IsNaN(speed) {
+		return 1
+	}
+	return math.Max(minReplaySpeed, math.Min(maxReplaySpeed, speed))
+}
@@ -0,0 +1,185 @@
+package websocket
+
+import (
+	"connect4-backend/game"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+)
+
+// spectatorDelayFromEnv reads SPECTATOR_DELAY, how far behind the live game
+// spectators are kept so they cannot feed moves to a player.
+func spectatorDelayFromEnv() time.Duration {
+	value := os.Getenv("SPECTATOR_DELAY")
+	if value == "" {
+		return 0
+	}
+
+	delay, err := time.ParseDuration(value)
+	if err != nil || delay < 0 {
+		log.Printf("Ignoring invalid SPECTATOR_DELAY %q", value)
+		return 0
+	}
+	return delay
+}
+
+// spectate subscribes the client to gameObj read-only. The client gets the
+// game as spectators currently see it, which lags the live game by the
+// spectator delay; until the delayed view has caught up with a new game
+// there is nothing to show yet and the game is null.
+func (c *Client) spectate(gameObj *game.Game) {
+	h := c.hub
+	live, _ := json.Marshal(gameObj)
+
+	h.mutex.Lock()
+	if c.gameID != "" {
+		h.removeClientFromGame(c)
+		c.gameID = ""
+	}
+	h.removeSpectator(c)
+	h.removeReplay(c)
+	c.spectating = gameObj.ID
+	h.spectators[gameObj.ID] = append(h.spectators[gameObj.ID], c)
+
+	snapshot := h.spectatorView[gameObj.ID]
+	if h.spectatorDelay == 0 {
+		snapshot = live
+	} else if snapshot == nil {
+		snapshot = []byte("null")
+	}
+	h.mutex.Unlock()
+
+	log.Printf("Client %s is spectating game %s", c.username, gameObj.ID)
+
+	c.sendMessage(Message{
+		Type: "spectating",
+		Data: map[string]interface{}{
+			"game":  json.RawMessage(snapshot),
+			"delay": h.spectatorDelay.Seconds(),
+		},
+	})
+	h.sendSpectatorCount(gameObj.ID)
+}
+
+// stopSpectating unsubscribes the client from the game it is watching, if
+// any.
+func (c *Client) stopSpectating() {
+	h := c.hub
+
+	h.mutex.Lock()
+	gameID := c.spectating
+	h.removeSpectator(c)
+	h.mutex.Unlock()
+
+	if gameID != "" {
+		h.sendSpectatorCount(gameID)
+	}
+}
+
+// removeSpectator drops the client from its game's spectators. Callers must
+// hold mutex.
+func (h *Hub) removeSpectator(client *Client) {
+	gameID := client.spectating
+	if gameID == "" {
+		return
+	}
+	client.spectating = ""
+
+	spectators := h.spectators[gameID]
+	for i, c := range spectators {
+		if c == client {
+			spectators = append(spectators[:i], spectators[i+1:]...)
+			break
+		}
+	}
+	if len(spectators) == 0 {
+		delete(h.spectators, gameID)
+	} else {
+		h.spectators[gameID] = spectators
+	}
+}
+
+// relayToSpectators passes a game message on to the game's spectators once
+// the spectator delay has passed. The message and game are encoded now, so
+// spectators see the game as it was when the message was sent. It may be
+// called with the manager's lock held, so it must not call back into the
+// manager.
+func (h *Hub) relayToSpectators(gameID string, msg Message) {
+	data, _ := json.Marshal(msg)
+	var snapshot []byte
+	over := false
+	if gameObj := gameInMessage(msg); gameObj != nil {
+		snapshot, _ = json.Marshal(gameObj)
+		over = gameObj.Status == "finished" || gameObj.Status == "expired" || gameObj.Status == "aborted"
+	}
+
+	relay := func() {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+
+		if over && len(h.spectators[gameID]) == 0 {
+			// Nobody is watching, and nobody new will be interested
+			delete(h.spectatorView, gameID)
+		} else if snapshot != nil {
+			h.spectatorView[gameID] = snapshot
+		}
+		h.sendToSpectators(gameID, data)
+	}
+
+	if h.spectatorDelay == 0 {
+		relay()
+		return
+	}
+	time.AfterFunc(h.spectatorDelay, relay)
+}
+
+// sendSpectatorCount tells players and spectators how many people are
+// watching a game.
+func (h *Hub) sendSpectatorCount(gameID string) {
+	h.mutex.RLock()
+	count := len(h.spectators[gameID])
+	h.mutex.RUnlock()
+
+	msg := Message{
+		Type: "spectators",
+		Data: map[string]interface{}{
+			"gameId": gameID,
+			"count":  count,
+		},
+	}
+
+	h.sendToGame(gameID, msg)
+
+	data, _ := json.Marshal(msg)
+	h.mutex.RLock()
+	h.sendToSpectators(gameID, data)
+	h.mutex.RUnlock()
+}
+
+// sendToSpectators sends data to a game's spectators without waiting for
+// slow ones. Callers must hold mutex, so that Run cannot close a client's
+// channel during the send; clients Run has already dropped are skipped.
+func (h *Hub) sendToSpectators(gameID string, data []byte) {
+	for _, client := range h.spectators[gameID] {
+		if !h.clients[client] {
+			continue
+		}
+		select {
+		case client.send <- data:
+		default:
+		}
+	}
+}
+
+// gameInMessage returns the game carried by a game message, if any.
+func gameInMessage(msg Message) *game.Game {
+	switch data := msg.Data.(type) {
+	case *game.Game:
+		return data
+	case map[string]interface{}:
+		gameObj, _ := data["game"].(*game.Game)
+		return gameObj
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package websocket
+
+import "connect4-backend/tournament"
+
+// SetDirector lets players register for tournaments over the WebSocket,
+// and passes them the director's notices, such as their next game being
+// ready.
+func (h *Hub) SetDirector(director *tournament.Director) {
+	h.director = director
+	director.SetNotifyCallback(h.NotifyPlayer)
+}
+
+// registerTournament enters the player in a tournament. Once it has
+// started, registering again is how a returning player is sent their game.
+func (c *Client) registerTournament(tournamentID, username string) {
+	if c.hub.director == nil {
+		c.sendMessage(Message{
+			Type: "error",
+			Data: map[string]string{"message": "Tournaments are not available"},
+		})
+		return
+	}
+
+	t, err := c.hub.director.Register(tournamentID, username)
+	if err != nil {
+		c.sendMessage(Message{
+			Type: "error",
+			Data: map[string]string{"message": err.Error()},
+		})
+		return
+	}
+
+	c.sendMessage(Message{
+		Type: "tournament_registered",
+		Data: map[string]interface{}{
+			"tournamentId": t.ID,
+			"name":         t.Name,
+			"status":       t.Status,
+			"players":      len(t.Players),
+			"startsAt":     t.StartsAt,
+		},
+	})
+}
@@ -114,7 +114,7 @@ function App() {
       const response = await fetch('/api/leaderboard');
       if (response.ok) {
         const data = await response.json();
-        setLeaderboard(data);
+        setLeaderboard(data.entries);
       }
     } catch (error) {
       console.error('Failed to fetch leaderboard:', error);
@@ -288,9 +288,9 @@ function App() {
             {leaderboard.length > 0 ? (
               leaderboard.map((entry, index) => {
                 let timeDisplay = "No wins yet";
-                if (entry.bestTime && entry.bestTime > 0) {
-                  const minutes = Math.floor(entry.bestTime / 60);
-                  const seconds = Math.floor(entry.bestTime % 60);
+                if (entry.fastestWin && entry.fastestWin > 0) {
+                  const minutes = Math.floor(entry.fastestWin / 60);
+                  const seconds = Math.floor(entry.fastestWin % 60);
                   timeDisplay = `${minutes}:${seconds.toString().padStart(2, '0')}`;
                 }
                 return (
@@ -393,9 +393,9 @@ function App() {
           {leaderboard.length > 0 ? (
             leaderboard.map((entry, index) => {
               let timeDisplay = "No wins yet";
-              if (entry.bestTime && entry.bestTime > 0) {
-                const minutes = Math.floor(entry.bestTime / 60);
-                const seconds = Math.floor(entry.bestTime % 60);
+              if (entry.fastestWin && entry.fastestWin > 0) {
+                const minutes = Math.floor(entry.fastestWin / 60);
+                const seconds = Math.floor(entry.fastestWin % 60);
                 timeDisplay = `${minutes}:${seconds.toString().padStart(2, '0')}`;
               }
               return (
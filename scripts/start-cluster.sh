@@ -0,0 +1,84 @@
+#!/bin/bash
+
+# Starts a cluster of game servers on this machine, sharing one SQLite
+# database, to try out cluster mode. Node n is on port 8080+n, and serves
+# the other nodes' calls on port 9080+n.
+#
+#   scripts/start-cluster.sh [nodes] [--check]
+#
+# With --check, cmd/clustercheck plays games across the nodes once they are
+# up. Logs go to backend/data/cluster/.
+
+set -e
+
+NODES=3
+CHECK=false
+for arg in "$@"; do
+    case $arg in
+        --check) CHECK=true ;;
+        *) NODES=$arg ;;
+    esac
+done
+
+cd "$(dirname "$0")/../backend"
+mkdir -p data/cluster
+
+cleanup() {
+    echo ""
+    echo "Stopping cluster..."
+    for pid in $PIDS; do
+        kill $pid 2>/dev/null || true
+    done
+    exit 0
+}
+trap cleanup INT TERM
+
+echo "Building backend..."
+go build -o data/cluster/server .
+
+CLUSTER_NODES=""
+URLS=""
+for i in $(seq 1 $NODES); do
+    CLUSTER_NODES="$CLUSTER_NODES${CLUSTER_NODES:+,}node$i=http://127.0.0.1:$((9080 + i))"
+    URLS="$URLS${URLS:+,}http://127.0.0.1:$((8080 + i))"
+done
+
+export CLUSTER_NODES
+export CLUSTER_SECRET=$(head -c 16 /dev/urandom | od -An -tx1 | tr -d ' \n')
+export SQLITE_PATH=data/cluster/connect4.db
+export DATABASE_URL=""
+export KAFKA_BROKERS=""
+
+PIDS=""
+for i in $(seq 1 $NODES); do
+    NODE_ID=node$i PORT=$((8080 + i)) data/cluster/server > data/cluster/node$i.log 2>&1 &
+    PIDS="$PIDS $!"
+done
+
+echo "Waiting for $NODES nodes..."
+for i in $(seq 1 $NODES); do
+    for attempt in $(seq 1 30); do
+        if curl -s "http://127.0.0.1:$((8080 + i))/health" >/dev/null 2>&1; then
+            break
+        fi
+        if [ $attempt -eq 30 ]; then
+            echo "ERROR: node$i did not start, see backend/data/cluster/node$i.log"
+            cleanup
+        fi
+        sleep 1
+    done
+    echo "node$i: http://127.0.0.1:$((8080 + i))"
+done
+
+if [ "$CHECK" = true ]; then
+    STATUS=0
+    go run ./cmd/clustercheck -nodes "$URLS" || STATUS=1
+    for pid in $PIDS; do
+        kill $pid 2>/dev/null || true
+    done
+    exit $STATUS
+fi
+
+echo ""
+echo "Press Ctrl+C to stop the cluster"
+wait
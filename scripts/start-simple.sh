@@ -41,7 +41,7 @@ echo "🔧 Building and starting backend server..."
 cd backend
 go mod tidy
 
-# Start backend server (without database and Kafka for simplicity)
+# Start backend server (SQLite instead of Postgres, and no Kafka, for simplicity)
 export DATABASE_URL=""
 export KAFKA_BROKERS=""
 go run main.go &
@@ -69,10 +69,9 @@ echo "API Health: http://localhost:8080/health"
 echo "Statistics: http://localhost:8080/api/stats"
 echo "Leaderboard: http://localhost:8080/api/leaderboard"
 echo ""
-echo "Note: Running in simple mode (no database/analytics)"
-echo "   - Leaderboard will be empty"
+echo "Note: Running in simple mode (SQLite, no analytics)"
+echo "   - Games and ratings are saved to backend/data/connect4.db"
 echo "   - No game analytics"
-echo "   - Games are not persisted"
 echo ""
 echo "📱 Open your browser and go to: http://localhost:8080"
 echo ""